@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Eun/domwatch/client"
+)
+
+func keysUsage() {
+	fmt.Printf("usage: %s -server <url> -key <api key> keys <command>\n", path.Base(os.Args[0]))
+	fmt.Println("    Commands:")
+	fmt.Println("    list                                         List the API keys of the account")
+	fmt.Println("    create [-scopes s1,s2] [-expires 720h] name  Create a key, it is only shown once")
+	fmt.Println("    revoke id                                    Revoke a key")
+	fmt.Println("    Scopes: watch:read, watch:write, check, keys")
+	os.Exit(1)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// runKeys manages the API keys of the account the key belongs to
+func runKeys(server string, key string, args []string) error {
+	if len(args) == 0 || server == "" || key == "" {
+		keysUsage()
+	}
+
+	api := client.New(server, key)
+	ctx := context.Background()
+	switch args[0] {
+	case "list":
+		keys, err := api.Keys(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tPREFIX\tSCOPES\tEXPIRES\tLAST USED")
+		for _, k := range keys {
+			fmt.Fprintf(w, "%d\t%s\t%s…\t%s\t%s\t%s\n", k.ID, k.Name, k.Prefix, strings.Join(k.Scopes, ","), formatTime(k.ExpiresAt), formatTime(k.LastUsed))
+		}
+		return w.Flush()
+
+	case "create":
+		flags := flag.NewFlagSet("create", flag.ExitOnError)
+		scopes := flags.String("scopes", "watch:read", "comma separated scopes")
+		expires := flags.String("expires", "", "lifetime of the key, e.g. 720h")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			keysUsage()
+		}
+		created, err := api.CreateKey(ctx, &client.CreateKeyRequest{
+			Name:    flags.Arg(0),
+			Scopes:  strings.Split(*scopes, ","),
+			Expires: *expires,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created key %d '%s', it will not be shown again:\n%s\n", created.ID, created.Name, created.Key)
+		return nil
+
+	case "revoke":
+		if len(args) != 2 {
+			keysUsage()
+		}
+		id, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			keysUsage()
+		}
+		err = api.RevokeKey(ctx, uint(id))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Revoked key %s\n", args[1])
+		return nil
+	}
+	keysUsage()
+	return nil
+}
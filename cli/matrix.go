@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Eun/domwatch"
+	"github.com/Eun/domwatch/client"
+)
+
+func matrixUsage() {
+	fmt.Printf("usage: %s <options> matrix [-tlds popular] [-concurrency 8] [-watch] label <label2>...\n", path.Base(os.Args[0]))
+	fmt.Println("    -tlds         List of TLDs (com,io,dev), a group or all for all generic TLDs")
+	fmt.Printf("                  Groups: %s\n", strings.Join(domwatch.TLDGroupNames(), ", "))
+	fmt.Println("    -concurrency  Number of concurrent checks")
+	fmt.Println("    -watch        Watch the taken domains, needs -server and -key")
+	os.Exit(1)
+}
+
+// runMatrix prints the availability of the labels in a set of TLDs
+func runMatrix(server string, key string, transport string, types []uint16, debugLogger *log.Logger, args []string) error {
+	flags := flag.NewFlagSet("matrix", flag.ExitOnError)
+	tldSet := flags.String("tlds", "popular", "")
+	concurrency := flags.Int("concurrency", 8, "")
+	watch := flags.Bool("watch", false, "")
+	flags.Usage = matrixUsage
+	flags.Parse(args)
+	if flags.NArg() == 0 || (*watch && (server == "" || key == "")) {
+		matrixUsage()
+	}
+
+	var labels []string
+	for _, label := range flags.Args() {
+		label = strings.ToLower(strings.TrimSpace(label))
+		if label == "" || strings.Contains(label, ".") {
+			return fmt.Errorf("'%s' is not a label", label)
+		}
+		labels = append(labels, label)
+	}
+	tlds, err := domwatch.ResolveTLDs(*tldSet, func() ([]string, error) {
+		return domwatch.FetchGTLDs(nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	matrix := domwatch.CheckMatrix(labels, tlds, *concurrency, func(domain string) (*domwatch.Result, error) {
+		return domwatch.CheckDomain("8.8.8.8", domain, transport, types, debugLogger)
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "LABEL\t.%s\n", strings.Join(matrix.TLDs, "\t."))
+	for _, row := range matrix.Rows {
+		cells := []string{row.Label}
+		for _, cell := range row.Cells {
+			switch {
+			case cell.Error != "":
+				cells = append(cells, "error")
+			case cell.Available:
+				cells = append(cells, "FREE")
+			default:
+				cells = append(cells, "taken")
+			}
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	taken := matrix.Taken()
+	if !*watch || len(taken) == 0 {
+		return nil
+	}
+	_, err = client.New(server, key).Watch(context.Background(), &client.WatchRequest{Domains: taken})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Watching %d taken domains\n", len(taken))
+	return nil
+}
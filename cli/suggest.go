@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Eun/domwatch"
+)
+
+func suggestUsage() {
+	fmt.Printf("usage: %s <options> suggest [-tld com] [-check] keyword <keyword2>...\n", path.Base(os.Args[0]))
+	fmt.Println("    -tld          TLD of the names")
+	fmt.Printf("    -prefixes     Prefixes, separated by commas (default %s)\n", strings.Join(domwatch.DefaultPrefixes, ","))
+	fmt.Printf("    -suffixes     Suffixes, separated by commas (default %s)\n", strings.Join(domwatch.DefaultSuffixes, ","))
+	fmt.Println("    -words        File with words to combine with the keywords, one per line")
+	fmt.Println("    -hyphenate    Also join the parts with hyphens")
+	fmt.Println("    -plurals      Also use the plurals of the keywords")
+	fmt.Println("    -digits       Allow digits")
+	fmt.Println("    -min          Shortest name without tld")
+	fmt.Println("    -max          Longest name without tld")
+	fmt.Println("    -limit        Maximum number of names, 0 for all")
+	fmt.Println("    -check        Check the names and print the available ones")
+	fmt.Println("    -concurrency  Number of concurrent checks")
+	os.Exit(1)
+}
+
+// readWords reads a word list with one word per line, lines starting with # are skipped
+func readWords(name string) ([]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	return words, scanner.Err()
+}
+
+// splitList splits a list separated by commas, an empty string is an empty list
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// runSuggest prints names generated from the keywords, with -check only the available ones
+func runSuggest(transport string, types []uint16, debugLogger *log.Logger, args []string) error {
+	flags := flag.NewFlagSet("suggest", flag.ExitOnError)
+	tld := flags.String("tld", "com", "")
+	prefixes := flags.String("prefixes", strings.Join(domwatch.DefaultPrefixes, ","), "")
+	suffixes := flags.String("suffixes", strings.Join(domwatch.DefaultSuffixes, ","), "")
+	wordList := flags.String("words", "", "")
+	hyphenate := flags.Bool("hyphenate", false, "")
+	plurals := flags.Bool("plurals", false, "")
+	digits := flags.Bool("digits", false, "")
+	minLength := flags.Int("min", 0, "")
+	maxLength := flags.Int("max", 0, "")
+	limit := flags.Int("limit", 100, "")
+	check := flags.Bool("check", false, "")
+	concurrency := flags.Int("concurrency", 8, "")
+	flags.Usage = suggestUsage
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		suggestUsage()
+	}
+
+	options := domwatch.GeneratorOptions{
+		Prefixes:  splitList(*prefixes),
+		Suffixes:  splitList(*suffixes),
+		Hyphenate: *hyphenate,
+		Plurals:   *plurals,
+		Digits:    *digits,
+		MinLength: *minLength,
+		MaxLength: *maxLength,
+		Limit:     *limit,
+	}
+	if *wordList != "" {
+		words, err := readWords(*wordList)
+		if err != nil {
+			return err
+		}
+		options.Words = words
+	}
+	names := domwatch.GenerateNames(flags.Args(), options)
+	suffix := "." + strings.ToLower(strings.Trim(*tld, ". "))
+
+	if !*check {
+		for _, name := range names {
+			fmt.Println(name + suffix)
+		}
+		return nil
+	}
+
+	// a matrix with a single tld checks all names concurrently
+	matrix := domwatch.CheckMatrix(names, []string{strings.TrimPrefix(suffix, ".")}, *concurrency, func(domain string) (*domwatch.Result, error) {
+		return domwatch.CheckDomain("8.8.8.8", domain, transport, types, debugLogger)
+	})
+	for _, row := range matrix.Rows {
+		cell := row.Cells[0]
+		switch {
+		case cell.Error != "":
+			fmt.Fprintf(os.Stderr, "'%s': %s\n", cell.Domain, cell.Error)
+		case cell.Available:
+			fmt.Println(cell.Domain)
+		}
+	}
+	return nil
+}
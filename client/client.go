@@ -0,0 +1,320 @@
+// Package client calls the API of the domwatch daemon, it follows the OpenAPI document at /api1/openapi.json
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error is returned for responses with an error status
+type Error struct {
+	StatusCode int
+	Message    string        // Error of the response body
+	RetryAfter time.Duration // set for 429 Too Many Requests
+}
+
+func (err *Error) Error() string {
+	if err.Message == "" {
+		return fmt.Sprintf("unexpected status %d", err.StatusCode)
+	}
+	return fmt.Sprintf("%s (status %d)", err.Message, err.StatusCode)
+}
+
+// Client calls the daemon at BaseURL, e.g. https://dom.watch
+type Client struct {
+	BaseURL    string
+	Key        string // API key, requests are anonymous without one
+	HTTPClient *http.Client
+}
+
+// New returns a client for the daemon at baseURL that authenticates with the API key
+func New(baseURL string, key string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Key:        key,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// send sends a request to a path below /api1 with body encoded as JSON
+func (c *Client) send(ctx context.Context, method string, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(raw)
+	}
+
+	u := c.BaseURL + "/api1" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Key)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+func responseError(resp *http.Response) error {
+	apiError := &Error{StatusCode: resp.StatusCode}
+	var body struct{ Error string }
+	if raw, err := ioutil.ReadAll(resp.Body); err == nil && json.Unmarshal(raw, &body) == nil {
+		apiError.Message = body.Error
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiError.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiError
+}
+
+// do sends a request and decodes the JSON response into v, v may be nil
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body interface{}, v interface{}) error {
+	resp, err := c.send(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if v == nil || len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// stream sends a request and calls fn with every line of an NDJSON response until it returns an error
+func (c *Client) stream(ctx context.Context, path string, query url.Values, body interface{}, fn func(*BatchResult) error) error {
+	resp, err := c.send(ctx, "POST", path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var result BatchResult
+		if err = json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			return err
+		}
+		if err = fn(&result); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Health returns the health of the daemon, an unhealthy daemon returns an *Error with status 503
+func (c *Client) Health(ctx context.Context) (*Health, error) {
+	var health Health
+	return &health, c.do(ctx, "GET", "/health", nil, nil, &health)
+}
+
+// Stats returns the number of domains and users
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	var stats Stats
+	return &stats, c.do(ctx, "GET", "/stats", nil, nil, &stats)
+}
+
+// Check checks a domain right away
+func (c *Client) Check(ctx context.Context, domain string) (*CheckResult, error) {
+	var result CheckResult
+	return &result, c.do(ctx, "GET", "/check", url.Values{"domain": {domain}}, nil, &result)
+}
+
+// CheckBatch checks many domains and calls fn with every result as soon as it is ready,
+// names without a tld get tld. If fn returns an error the batch is cancelled.
+func (c *Client) CheckBatch(ctx context.Context, domains []string, tld string, fn func(*BatchResult) error) error {
+	return c.stream(ctx, "/check/batch", nil, &BatchRequest{domains, tld}, fn)
+}
+
+// Matrix checks labels in a set of TLDs, with request.Watch the taken domains are watched
+func (c *Client) Matrix(ctx context.Context, request *MatrixRequest) (*Matrix, error) {
+	var matrix Matrix
+	return &matrix, c.do(ctx, "POST", "/check/matrix", nil, request, &matrix)
+}
+
+// Suggest generates names from keywords, request.Check is ignored, use SuggestAndCheck
+func (c *Client) Suggest(ctx context.Context, request SuggestRequest) (*Suggestions, error) {
+	request.Check = false
+	var suggestions Suggestions
+	return &suggestions, c.do(ctx, "POST", "/suggest", nil, &request, &suggestions)
+}
+
+// SuggestAndCheck generates names from keywords and calls fn with the result of their checks
+func (c *Client) SuggestAndCheck(ctx context.Context, request SuggestRequest, fn func(*BatchResult) error) error {
+	request.Check = true
+	return c.stream(ctx, "/suggest", nil, &request, fn)
+}
+
+// Watch watches domains, anonymous watches are pending until the email is confirmed
+func (c *Client) Watch(ctx context.Context, request *WatchRequest) (*WatchResponse, error) {
+	var response WatchResponse
+	return &response, c.do(ctx, "POST", "/watch", nil, request, &response)
+}
+
+// ResendConfirmation sends the confirmation mail for the pending watches of an email again
+func (c *Client) ResendConfirmation(ctx context.Context, email string) error {
+	return c.do(ctx, "POST", "/watch/resend", nil, &ResendRequest{email}, nil)
+}
+
+// Unwatch removes watches, without a key or token a mail with unsubscribe links is sent
+func (c *Client) Unwatch(ctx context.Context, request *UnwatchRequest) (*UnwatchResponse, error) {
+	var response UnwatchResponse
+	return &response, c.do(ctx, "POST", "/unwatch", nil, request, &response)
+}
+
+// Watches returns a page of the watches of the account
+func (c *Client) Watches(ctx context.Context, options *WatchesOptions) (*WatchList, error) {
+	query := url.Values{}
+	if options != nil {
+		set := func(name string, value string) {
+			if value != "" {
+				query.Set(name, value)
+			}
+		}
+		set("q", options.Query)
+		set("status", options.Status)
+		set("channel", options.Channel)
+		set("sort", options.Sort)
+		if options.Page > 0 {
+			set("page", strconv.Itoa(options.Page))
+		}
+		if options.PerPage > 0 {
+			set("per_page", strconv.Itoa(options.PerPage))
+		}
+	}
+	var list WatchList
+	return &list, c.do(ctx, "GET", "/watches", query, nil, &list)
+}
+
+// GetWatch returns a watch of the account
+func (c *Client) GetWatch(ctx context.Context, domain string) (*Watch, error) {
+	var watch Watch
+	return &watch, c.do(ctx, "GET", "/watches/"+url.PathEscape(domain), nil, nil, &watch)
+}
+
+// Account returns the account of the key and its watches
+func (c *Client) Account(ctx context.Context) (*Account, error) {
+	var account Account
+	return &account, c.do(ctx, "GET", "/account", nil, nil, &account)
+}
+
+// Keys returns the API keys of the account
+func (c *Client) Keys(ctx context.Context) ([]APIKey, error) {
+	var keys []APIKey
+	err := c.do(ctx, "GET", "/keys", nil, nil, &keys)
+	return keys, err
+}
+
+// CreateKey creates an API key, its Key is only returned here
+func (c *Client) CreateKey(ctx context.Context, request *CreateKeyRequest) (*APIKey, error) {
+	var key APIKey
+	return &key, c.do(ctx, "POST", "/keys", nil, request, &key)
+}
+
+// RevokeKey revokes an API key of the account
+func (c *Client) RevokeKey(ctx context.Context, id uint) error {
+	return c.do(ctx, "DELETE", "/keys/"+strconv.FormatUint(uint64(id), 10), nil, nil, nil)
+}
+
+func orgPath(id uint, path string) string {
+	return "/orgs/" + strconv.FormatUint(uint64(id), 10) + path
+}
+
+// Orgs returns the organisations of the account
+func (c *Client) Orgs(ctx context.Context) ([]Org, error) {
+	var orgs []Org
+	err := c.do(ctx, "GET", "/orgs", nil, nil, &orgs)
+	return orgs, err
+}
+
+// CreateOrg creates an organisation owned by the account
+func (c *Client) CreateOrg(ctx context.Context, name string) (*Org, error) {
+	var org Org
+	return &org, c.do(ctx, "POST", "/orgs", nil, &CreateOrgRequest{name}, &org)
+}
+
+// GetOrg returns the members and the watch list of an organisation
+func (c *Client) GetOrg(ctx context.Context, id uint) (*OrgDetail, error) {
+	var org OrgDetail
+	return &org, c.do(ctx, "GET", orgPath(id, ""), nil, nil, &org)
+}
+
+// DeleteOrg deletes an organisation, only owners can do this
+func (c *Client) DeleteOrg(ctx context.Context, id uint) error {
+	return c.do(ctx, "DELETE", orgPath(id, ""), nil, nil, nil)
+}
+
+// OrgWatch adds domains to the watch list of an organisation
+func (c *Client) OrgWatch(ctx context.Context, id uint, domains []string) error {
+	return c.do(ctx, "POST", orgPath(id, "/watch"), nil, &DomainsRequest{domains}, nil)
+}
+
+// OrgUnwatch removes domains from the watch list of an organisation
+func (c *Client) OrgUnwatch(ctx context.Context, id uint, domains []string) error {
+	return c.do(ctx, "POST", orgPath(id, "/unwatch"), nil, &DomainsRequest{domains}, nil)
+}
+
+// InviteMember invites an email to an organisation or changes the role of a member
+func (c *Client) InviteMember(ctx context.Context, id uint, email string, role string) (bool, error) {
+	var response struct{ Pending bool }
+	err := c.do(ctx, "POST", orgPath(id, "/members"), nil, &InviteRequest{email, role}, &response)
+	return response.Pending, err
+}
+
+// RevokeInvitation revokes the invitation of an address that has no account yet
+func (c *Client) RevokeInvitation(ctx context.Context, id uint, email string) error {
+	return c.do(ctx, "DELETE", orgPath(id, "/members"), nil, &RevokeInvitationRequest{email}, nil)
+}
+
+// RemoveMember removes a member from an organisation, members can remove themselves
+func (c *Client) RemoveMember(ctx context.Context, id uint, accountID uint) error {
+	return c.do(ctx, "DELETE", orgPath(id, "/members/"+strconv.FormatUint(uint64(accountID), 10)), nil, nil, nil)
+}
+
+// AcceptInvitation accepts the invitation to an organisation
+func (c *Client) AcceptInvitation(ctx context.Context, id uint) error {
+	return c.do(ctx, "POST", orgPath(id, "/accept"), nil, nil, nil)
+}
+
+// SetOrgPreferences sets the channels and muting of the watch list of an organisation for the account
+func (c *Client) SetOrgPreferences(ctx context.Context, id uint, preferences *OrgPreferences) error {
+	return c.do(ctx, "POST", orgPath(id, "/preferences"), nil, preferences, nil)
+}
@@ -0,0 +1,400 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Eun/domwatch/fcgi/api1"
+	"github.com/Eun/domwatch/fcgi/api1/api1test"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/miekg/dns"
+)
+
+// testServer runs the api1 router of the daemon with a sqlite database
+type testServer struct {
+	*httptest.Server
+	t      *testing.T
+	db     *gorm.DB
+	router *mux.Router
+}
+
+func newTestServer(t *testing.T) *testServer {
+	env := api1test.New(t, map[string]interface{}{
+		"BaseURL":   "https://example.com",
+		"DNSServer": "127.0.0.1",
+	})
+	config, err := api1.NewConfigFromMap(env.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	sub := router.PathPrefix("/api1").Subrouter()
+	if _, err = api1.NewApi(config, env.DB, sub, api1test.Logger(t)); err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{Server: httptest.NewServer(router), t: t, db: env.DB, router: sub}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// client creates an account for email and returns a client with an API key of the account
+func (s *testServer) client(email string, scopes ...string) *Client {
+	account := api1.Account{Email: email}
+	if err := s.db.Create(&account).Error; err != nil {
+		s.t.Fatal(err)
+	}
+	key := "dw_" + hex.EncodeToString([]byte(email))
+	err := s.db.Create(&api1.APIKey{
+		AccountID: account.ID,
+		Name:      "test",
+		Prefix:    key[:8],
+		Hash:      api1test.HashKey(key),
+		Scopes:    strings.Join(scopes, ","),
+	}).Error
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return New(s.URL, key)
+}
+
+func TestClient(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	c := s.client("a@example.com", api1.ScopeWatchRead, api1.ScopeWatchWrite, api1.ScopeCheck, api1.ScopeKeys)
+
+	if _, err := c.Stats(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if response, err := c.Watch(ctx, &WatchRequest{Domains: []string{"a-example.test", "b-example.test"}}); err != nil || response.Pending {
+		t.Fatal(response, err)
+	}
+	list, err := c.Watches(ctx, &WatchesOptions{Sort: "-domain", PerPage: 1})
+	if err != nil || list.Total != 2 || len(list.Watches) != 1 || list.Watches[0].Domain != "b-example.test" {
+		t.Fatal(list, err)
+	}
+	if watch, err := c.GetWatch(ctx, "a-example.test"); err != nil || watch.Domain != "a-example.test" {
+		t.Fatal(watch, err)
+	}
+	_, err = c.GetWatch(ctx, "c-example.test")
+	if e, ok := err.(*Error); !ok || e.StatusCode != 404 || e.Message != "not found" {
+		t.Fatal(err)
+	}
+	if _, err = c.Unwatch(ctx, &UnwatchRequest{Domains: []string{"b-example.test"}}); err != nil {
+		t.Fatal(err)
+	}
+	account, err := c.Account(ctx)
+	if err != nil || account.Email != "a@example.com" || len(account.Watches) != 1 {
+		t.Fatal(account, err)
+	}
+
+	key, err := c.CreateKey(ctx, &CreateKeyRequest{Name: "bot", Scopes: []string{api1.ScopeCheck}})
+	if err != nil || !strings.HasPrefix(key.Key, "dw_") {
+		t.Fatal(key, err)
+	}
+	keys, err := c.Keys(ctx)
+	if err != nil || len(keys) != 2 {
+		t.Fatal(keys, err)
+	}
+	if err = c.RevokeKey(ctx, key.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	org, err := c.CreateOrg(ctx, "Acme")
+	if err != nil || org.Role != "owner" {
+		t.Fatal(org, err)
+	}
+	if err = c.OrgWatch(ctx, org.ID, []string{"o-example.test"}); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := c.InviteMember(ctx, org.ID, "b@example.com", "member"); err != nil || !pending {
+		t.Fatal(pending, err)
+	}
+	detail, err := c.GetOrg(ctx, org.ID)
+	if err != nil || detail.Name != "Acme" || len(detail.Members) != 2 || len(detail.Watches) != 1 {
+		t.Fatal(detail, err)
+	}
+	if err = c.SetOrgPreferences(ctx, org.ID, &OrgPreferences{Muted: true}); err != nil {
+		t.Fatal(err)
+	}
+	if orgs, err := c.Orgs(ctx); err != nil || len(orgs) != 1 {
+		t.Fatal(orgs, err)
+	}
+	if err = c.OrgUnwatch(ctx, org.ID, []string{"o-example.test"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.DeleteOrg(ctx, org.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// errors of the daemon are returned as *Error
+	if _, err = New(s.URL, "").Account(ctx); err == nil || err.(*Error).StatusCode != 403 {
+		t.Fatal(err)
+	}
+	if _, err = c.Check(ctx, "bad_!"); err == nil || err.(*Error).Message != "invalid domain" {
+		t.Fatal(err)
+	}
+	readOnly := s.client("c@example.com", api1.ScopeWatchRead)
+	if _, err = readOnly.Watch(ctx, &WatchRequest{Domains: []string{"a-example.test"}}); err == nil || err.(*Error).StatusCode != 403 {
+		t.Fatal(err)
+	}
+}
+
+// startDNS answers the queries of the checks on 127.0.0.1:53, names that start
+// with taken are registered in the zone test
+func startDNS(t *testing.T) {
+	handler := dns.NewServeMux()
+	handler.HandleFunc(".", func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		question := r.Question[0]
+		switch {
+		case question.Name == "test." && question.Qtype == dns.TypeNS:
+			rr, _ := dns.NewRR("test. 3600 IN NS 127.0.0.1.")
+			msg.Answer = append(msg.Answer, rr)
+		case strings.HasPrefix(question.Name, "taken") && question.Qtype == dns.TypeNS:
+			rr, _ := dns.NewRR(question.Name + " 3600 IN NS ns1.example.")
+			msg.Ns = append(msg.Ns, rr)
+		}
+		w.WriteMsg(msg)
+	})
+	server := &dns.Server{Addr: "127.0.0.1:53", Net: "tcp", Handler: handler}
+	started := make(chan error, 1)
+	server.NotifyStartedFunc = func() { started <- nil }
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			started <- err
+		}
+	}()
+	if err := <-started; err != nil {
+		t.Skip("no DNS server:", err)
+	}
+	t.Cleanup(func() { server.Shutdown() })
+}
+
+func TestClientChecks(t *testing.T) {
+	startDNS(t)
+	s := newTestServer(t)
+	ctx := context.Background()
+	c := s.client("a@example.com", api1.ScopeWatchRead, api1.ScopeWatchWrite, api1.ScopeCheck)
+
+	result, err := c.Check(ctx, "taken.test")
+	if err != nil || result.Available || result.Verdict != "registered" || result.CheckedAt.IsZero() {
+		t.Fatal(result, err)
+	}
+	var results []BatchResult
+	err = c.CheckBatch(ctx, []string{"taken", "free", "bad_!"}, "test", func(r *BatchResult) error {
+		results = append(results, *r)
+		return nil
+	})
+	if err != nil || len(results) != 3 {
+		t.Fatal(results, err)
+	}
+	for _, r := range results {
+		if (r.Index == 1 && (!r.Available || r.Domain != "free.test")) || (r.Index == 2 && r.Error == "") {
+			t.Fatal(r)
+		}
+	}
+	matrix, err := c.Matrix(ctx, &MatrixRequest{Labels: []string{"taken", "free"}, TLDs: "test", Watch: true})
+	if err != nil || len(matrix.Rows) != 2 || !matrix.Rows[1].Cells[0].Available || len(matrix.Watched) != 1 {
+		t.Fatal(matrix, err)
+	}
+	suggestions, err := c.Suggest(ctx, SuggestRequest{Keywords: []string{"taken"}, Prefixes: []string{}, Suffixes: []string{"x"}, TLD: "test"})
+	if err != nil || len(suggestions.Domains) != 2 {
+		t.Fatal(suggestions, err)
+	}
+	n := 0
+	err = c.SuggestAndCheck(ctx, SuggestRequest{Keywords: []string{"taken"}, Prefixes: []string{}, Suffixes: []string{}, TLD: "test"}, func(*BatchResult) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+}
+
+// undocumentedRoutes are the routes that the description of the OpenAPI document leaves out
+var undocumentedRoutes = []string{
+	"/ack", "/confirm", "/login", "/logout", "/oidc", "/oidc/callback", "/oidc/login", "/openapi.json",
+	"/outbox", "/outbox/{id}/deliveries", "/outbox/{id}/retry", "/preferences",
+	"/push/key", "/push/subscribe", "/push/unsubscribe", "/templates/{name}/preview", "/unsubscribe",
+}
+
+// openAPIDocument returns the document that the server serves
+func (s *testServer) openAPIDocument() []byte {
+	resp, err := http.Get(s.URL + "/api1/openapi.json")
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != 200 {
+		s.t.Fatal(resp.StatusCode, err)
+	}
+	return raw
+}
+
+// operationSamples fills in the parameters of the documented paths
+var operationSamples = strings.NewReplacer("{domain}", "example.com", "{id}", "1", "{account}", "1")
+
+// TestOpenAPIDocument compares the document with the routes of the router
+func TestOpenAPIDocument(t *testing.T) {
+	s := newTestServer(t)
+	raw := s.openAPIDocument()
+	var document struct {
+		OpenAPI string
+		Servers []struct{ URL string }
+		Paths   map[string]map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.OpenAPI != "3.0.3" || len(document.Servers) != 1 || document.Servers[0].URL != "https://example.com/api1" {
+		t.Fatal(document.OpenAPI, document.Servers)
+	}
+
+	routes := make(map[string]bool)
+	variable := regexp.MustCompile(`\{(\w+):[^}]*\}`)
+	s.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if template, err := route.GetPathTemplate(); err == nil {
+			routes[variable.ReplaceAllString(strings.TrimPrefix(template, "/api1"), "{$1}")] = true
+		}
+		return nil
+	})
+	for path := range document.Paths {
+		if !routes[path] {
+			t.Errorf("%s is documented but has no route", path)
+		}
+	}
+	var undocumented []string
+	for path := range routes {
+		if _, ok := document.Paths[path]; !ok {
+			undocumented = append(undocumented, path)
+		}
+	}
+	sort.Strings(undocumented)
+	if strings.Join(undocumented, " ") != strings.Join(undocumentedRoutes, " ") {
+		t.Errorf("undocumented routes: %v", undocumented)
+	}
+
+	// the routes check the method before anything else, so the documented methods never get "Must be a ..."
+	for path, operations := range document.Paths {
+		for method := range operations {
+			if method == "parameters" {
+				continue
+			}
+			req, _ := http.NewRequest(strings.ToUpper(method), s.URL+"/api1"+operationSamples.Replace(path), nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if strings.Contains(string(body), "Must be a") {
+				t.Errorf("%s %s: %s", method, path, body)
+			}
+		}
+	}
+
+	// every reference resolves
+	var all struct {
+		Components map[string]map[string]interface{}
+	}
+	json.Unmarshal(raw, &all)
+	refs := regexp.MustCompile(`"\$ref":"#/components/(\w+)/(\w+)"`).FindAllStringSubmatch(string(raw), -1)
+	if len(refs) == 0 {
+		t.Fatal("no references")
+	}
+	for _, ref := range refs {
+		if all.Components[ref[1]][ref[2]] == nil {
+			t.Errorf("%s does not resolve", ref[0])
+		}
+	}
+}
+
+// TestClientOperations calls every method of the client and compares the requests with the document
+func TestClientOperations(t *testing.T) {
+	var document struct {
+		Paths map[string]map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(newTestServer(t).openAPIDocument(), &document); err != nil {
+		t.Fatal(err)
+	}
+	// the parameters of a path match any segment
+	operations := make(map[string][]*regexp.Regexp)
+	for path, methods := range document.Paths {
+		segments := strings.Split(path, "/")
+		for i, segment := range segments {
+			if strings.HasPrefix(segment, "{") {
+				segments[i] = `[^/]+`
+			} else {
+				segments[i] = regexp.QuoteMeta(segment)
+			}
+		}
+		pattern := regexp.MustCompile("^/api1" + strings.Join(segments, "/") + "$")
+		for method := range methods {
+			if method != "parameters" {
+				operations[strings.ToUpper(method)] = append(operations[strings.ToUpper(method)], pattern)
+			}
+		}
+	}
+
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	c := New(server.URL, "dw_test")
+
+	// the arguments are zero values except for the context, names, ids, structs and callbacks
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
+	value := reflect.ValueOf(c)
+	for i := 0; i < value.NumMethod(); i++ {
+		name := value.Type().Method(i).Name
+		method := value.Method(i)
+		var args []reflect.Value
+		for j := 0; j < method.Type().NumIn(); j++ {
+			in := method.Type().In(j)
+			switch {
+			case j == 0:
+				args = append(args, reflect.ValueOf(context.Background()))
+			case in.Kind() == reflect.String:
+				args = append(args, reflect.ValueOf("example.com").Convert(in))
+			case in.Kind() == reflect.Uint:
+				args = append(args, reflect.ValueOf(uint(1)).Convert(in))
+			case in.Kind() == reflect.Ptr:
+				args = append(args, reflect.New(in.Elem()))
+			case in.Kind() == reflect.Func:
+				args = append(args, reflect.MakeFunc(in, func([]reflect.Value) []reflect.Value {
+					return []reflect.Value{reflect.Zero(errorType)}
+				}))
+			default:
+				args = append(args, reflect.Zero(in))
+			}
+		}
+		requests = nil
+		method.Call(args)
+		if len(requests) != 1 {
+			t.Errorf("%s sent %v", name, requests)
+			continue
+		}
+		request := strings.SplitN(requests[0], " ", 2)
+		found := false
+		for _, pattern := range operations[request[0]] {
+			found = found || pattern.MatchString(request[1])
+		}
+		if !found {
+			t.Errorf("%s sent %s which is not documented", name, requests[0])
+		}
+	}
+}
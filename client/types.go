@@ -0,0 +1,218 @@
+package client
+
+import "time"
+
+// Health is ok or error for each component
+type Health struct {
+	Database string
+	Mail     string
+}
+
+type Stats struct {
+	Domains int
+	Users   int
+}
+
+type CheckResult struct {
+	Domain      string
+	Available   bool
+	Verdict     string // available or registered
+	NameServers []string
+	Evidence    []string
+	CheckedAt   time.Time
+	Cached      bool
+}
+
+type BatchRequest struct {
+	Domains []string
+	TLD     string
+}
+
+// BatchResult is the result of one name of a batch, names that could not be checked have an Error
+type BatchResult struct {
+	Index int // position of the name in the request
+	CheckResult
+	Error string
+}
+
+type MatrixRequest struct {
+	Labels   []string
+	TLDs     string // list separated by commas, a group or all
+	Watch    bool   // watch the taken domains
+	Channels []string
+}
+
+type MatrixCell struct {
+	TLD       string
+	Domain    string
+	Available bool
+	Verdict   string
+	Error     string
+}
+
+type MatrixRow struct {
+	Label string
+	Cells []MatrixCell
+}
+
+type Matrix struct {
+	TLDs    []string
+	Rows    []MatrixRow
+	Watched []string
+}
+
+// SuggestRequest are the keywords and the rules of the name generator,
+// nil Prefixes or Suffixes use the defaults of the daemon
+type SuggestRequest struct {
+	Keywords  []string
+	Prefixes  []string
+	Suffixes  []string
+	Words     []string
+	Hyphenate bool
+	Plurals   bool
+	Digits    bool
+	MinLength int
+	MaxLength int
+	Limit     int
+	TLD       string
+	Check     bool
+}
+
+type Suggestions struct {
+	TLD     string
+	Names   []string
+	Domains []string
+}
+
+type WatchRequest struct {
+	Domains  []string
+	Email    string // ignored with an API key
+	Channels []string
+	Locale   string
+}
+
+type WatchResponse struct {
+	Pending bool // a confirmation mail was sent
+}
+
+type ResendRequest struct {
+	Email string
+}
+
+type UnwatchRequest struct {
+	Domains []string
+	Email   string
+	Token   string // of an unsubscribe link, not needed with an API key
+}
+
+type UnwatchResponse struct {
+	Sent bool // a mail with unsubscribe links was sent
+}
+
+// WatchesOptions filter, sort and page the watches of an account
+type WatchesOptions struct {
+	Query   string // part of the domain
+	Status  string // pending, active or suspended
+	Channel string
+	Sort    string // domain, created or checked, - for descending
+	Page    int
+	PerPage int
+}
+
+type Watch struct {
+	Domain      string
+	Channels    []string
+	Status      string // pending, active or suspended
+	Verdict     string
+	CreatedAt   time.Time
+	LastChecked *time.Time
+	NextCheck   *time.Time
+}
+
+type WatchList struct {
+	Total   int
+	Page    int
+	PerPage int
+	Watches []Watch
+}
+
+type AccountWatch struct {
+	Domain    string
+	Channels  []string
+	Pending   bool
+	CreatedAt time.Time
+}
+
+type Account struct {
+	Email   string
+	Admin   bool
+	Watches []AccountWatch
+}
+
+type APIKey struct {
+	ID        uint
+	Name      string
+	Prefix    string
+	Scopes    []string
+	ExpiresAt *time.Time
+	LastUsed  *time.Time
+	CreatedAt time.Time
+	Key       string // only set by CreateKey
+}
+
+type CreateKeyRequest struct {
+	Name    string
+	Scopes  []string
+	Expires string // duration like 720h, the key does not expire if empty
+}
+
+type Org struct {
+	ID      uint
+	Name    string
+	Role    string // owner, admin or member
+	Pending bool
+}
+
+type OrgMember struct {
+	AccountID uint // 0 for an invitation to an address without an account
+	Email     string
+	Role      string
+	Channels  []string
+	Muted     bool
+	Pending   bool
+}
+
+type OrgWatch struct {
+	Domain    string
+	AddedBy   string
+	CreatedAt time.Time
+}
+
+type OrgDetail struct {
+	Org
+	Members []OrgMember
+	Watches []OrgWatch
+}
+
+type CreateOrgRequest struct {
+	Name string
+}
+
+type DomainsRequest struct {
+	Domains []string
+}
+
+type InviteRequest struct {
+	Email string
+	Role  string
+}
+
+type RevokeInvitationRequest struct {
+	Email string
+}
+
+// OrgPreferences of an account for the watch list of an organisation, nil Channels are kept
+type OrgPreferences struct {
+	Channels []string
+	Muted    bool
+}
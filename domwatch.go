@@ -1,104 +1,141 @@
-package domwatch
-
-import (
-	"errors"
-	"fmt"
-
-	"log"
-
-	"strings"
-
-	"github.com/miekg/dns"
-)
-
-func IsDomainAvailable(server string, domain string, transport string, types []uint16, debugLogger *log.Logger) (bool, error) {
-	var err error
-	var nameServers []string
-	nameServers, err = getNameServers(server, domain, transport, debugLogger)
-	if err != nil {
-		return false, err
-	}
-	if nameServers == nil || len(nameServers) <= 0 {
-		return false, fmt.Errorf("Unable to find nameservers for '%s'", domain)
-	}
-
-	domain = domain + "."
-
-	var client dns.Client
-	var request dns.Msg
-	var response *dns.Msg
-	client.Net = transport
-
-	// some nameservers do not support multiple Questions
-	// so pack them into multiple requests
-	for _, t := range types {
-		for _, ns := range nameServers {
-			debugLogger.Printf("Querying '%s' with type '%d'\n", ns, t)
-			request.SetQuestion(domain, t)
-			response, _, err = client.Exchange(&request, ns+":53")
-			if err != nil {
-				debugLogger.Printf("Error from nameserver %s: %s", ns, err.Error())
-				continue
-			}
-
-			if len(response.Answer) > 0 {
-				for _, a := range response.Answer {
-					if strings.ToLower(a.Header().Name) == domain {
-						return false, nil
-					}
-				}
-
-			}
-
-			if len(response.Ns) > 0 {
-				for _, a := range response.Ns {
-					if strings.ToLower(a.Header().Name) == domain {
-						return false, nil
-					}
-				}
-
-			}
-		}
-	}
-	debugLogger.Printf("%s is available", domain)
-	return true, nil
-}
-
-func getNameServers(server string, domain string, transport string, debugLogger *log.Logger) ([]string, error) {
-	var err error
-	var client dns.Client
-	var request dns.Msg
-	var response *dns.Msg
-	client.Net = transport
-
-	debugLogger.Printf("Getting root ns for '%s'\n", domain)
-
-	domainParts := strings.Split(domain, ".")
-	if len(domainParts) <= 1 {
-		return nil, errors.New("Invalid domain")
-	}
-	tld := domainParts[len(domainParts)-1] + "."
-	request.SetQuestion(tld, dns.TypeNS)
-	response, _, err = client.Exchange(&request, server+":53")
-
-	if err != nil {
-		return nil, err
-	}
-
-	l := len(response.Answer)
-
-	if l <= 0 {
-		return nil, fmt.Errorf("No nameservers found for '%s'", tld)
-	}
-
-	var servers []string
-
-	for i := 0; i < l; i++ {
-		switch response.Answer[i].(type) {
-		case *dns.NS:
-			servers = append(servers, strings.TrimSpace(strings.Trim(response.Answer[i].(*dns.NS).Ns, ".")))
-		}
-	}
-
-	return servers, nil
-}
+package domwatch
+
+import (
+	"errors"
+	"fmt"
+
+	"log"
+
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	VerdictAvailable  = "available"
+	VerdictRegistered = "registered"
+)
+
+// Result is the outcome of a domain check
+type Result struct {
+	Domain      string
+	Available   bool
+	Verdict     string
+	NameServers []string
+	// Evidence holds the records that prove that the domain is registered,
+	// or the queries that came back empty if it is available
+	Evidence []string
+}
+
+func IsDomainAvailable(server string, domain string, transport string, types []uint16, debugLogger *log.Logger) (bool, error) {
+	result, err := CheckDomain(server, domain, transport, types, debugLogger)
+	if err != nil {
+		return false, err
+	}
+	return result.Available, nil
+}
+
+// CheckDomain asks the nameservers of the tld for the domain and returns the verdict with its evidence
+func CheckDomain(server string, domain string, transport string, types []uint16, debugLogger *log.Logger) (*Result, error) {
+	var err error
+	var nameServers []string
+	nameServers, err = getNameServers(server, domain, transport, debugLogger)
+	if err != nil {
+		return nil, err
+	}
+	if nameServers == nil || len(nameServers) <= 0 {
+		return nil, fmt.Errorf("Unable to find nameservers for '%s'", domain)
+	}
+
+	result := &Result{
+		Domain:      domain,
+		NameServers: nameServers,
+	}
+
+	domain = domain + "."
+
+	var client dns.Client
+	var request dns.Msg
+	var response *dns.Msg
+	client.Net = transport
+
+	// some nameservers do not support multiple Questions
+	// so pack them into multiple requests
+	for _, t := range types {
+		for _, ns := range nameServers {
+			debugLogger.Printf("Querying '%s' with type '%d'\n", ns, t)
+			request.SetQuestion(domain, t)
+			response, _, err = client.Exchange(&request, ns+":53")
+			if err != nil {
+				debugLogger.Printf("Error from nameserver %s: %s", ns, err.Error())
+				continue
+			}
+
+			if len(response.Answer) > 0 {
+				for _, a := range response.Answer {
+					if strings.ToLower(a.Header().Name) == domain {
+						result.Verdict = VerdictRegistered
+						result.Evidence = append(result.Evidence, a.String())
+						return result, nil
+					}
+				}
+
+			}
+
+			if len(response.Ns) > 0 {
+				for _, a := range response.Ns {
+					if strings.ToLower(a.Header().Name) == domain {
+						result.Verdict = VerdictRegistered
+						result.Evidence = append(result.Evidence, a.String())
+						return result, nil
+					}
+				}
+
+			}
+			result.Evidence = append(result.Evidence, fmt.Sprintf("%s: no %s record for %s", ns, dns.TypeToString[t], domain))
+		}
+	}
+	debugLogger.Printf("%s is available", domain)
+	result.Available = true
+	result.Verdict = VerdictAvailable
+	return result, nil
+}
+
+func getNameServers(server string, domain string, transport string, debugLogger *log.Logger) ([]string, error) {
+	var err error
+	var client dns.Client
+	var request dns.Msg
+	var response *dns.Msg
+	client.Net = transport
+
+	debugLogger.Printf("Getting root ns for '%s'\n", domain)
+
+	domainParts := strings.Split(domain, ".")
+	if len(domainParts) <= 1 {
+		return nil, errors.New("Invalid domain")
+	}
+	tld := domainParts[len(domainParts)-1] + "."
+	request.SetQuestion(tld, dns.TypeNS)
+	response, _, err = client.Exchange(&request, server+":53")
+
+	if err != nil {
+		return nil, err
+	}
+
+	l := len(response.Answer)
+
+	if l <= 0 {
+		return nil, fmt.Errorf("No nameservers found for '%s'", tld)
+	}
+
+	var servers []string
+
+	for i := 0; i < l; i++ {
+		switch response.Answer[i].(type) {
+		case *dns.NS:
+			servers = append(servers, strings.TrimSpace(strings.Trim(response.Answer[i].(*dns.NS).Ns, ".")))
+		}
+	}
+
+	return servers, nil
+}
@@ -0,0 +1,401 @@
+package api1
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/jinzhu/gorm"
+)
+
+const sessionCookie = "domwatch_session"
+
+// Account is the identity of a user, it owns the Email with the same address
+type Account struct {
+	ID        uint   `gorm:"primary_key;not null"`
+	Email     string `gorm:"type:char(255);unique;not null"`
+	Subject   string `gorm:"type:char(255);not null;default:'';index"` // issuer and subject of the OIDC identity
+	Admin     bool   `gorm:"not null;default:false"`                   // granted by the OIDC groups
+	LastLogin *time.Time
+	CreatedAt time.Time
+}
+
+// Session is a login of an account, only the hash of the cookie value is stored
+type Session struct {
+	ID        string `gorm:"type:char(64);primary_key;not null"`
+	AccountID uint   `gorm:"not null;index"`
+	ExpiresAt int64  `gorm:"not null;index"`
+	CreatedAt time.Time
+}
+
+// LoginLink is a magic link that was sent and not used yet, only the hash of its nonce is stored
+type LoginLink struct {
+	ID        string `gorm:"type:char(64);primary_key;not null"`
+	ExpiresAt int64  `gorm:"not null;index"`
+	CreatedAt time.Time
+}
+
+type loginTemplateData struct {
+	mailContext
+	Link    string
+	Expires string
+}
+
+func hashSecret(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// ensureAccount returns the account of an email and creates it if there is none,
+// it must only be called once the ownership of the email was proven
+func ensureAccount(db *gorm.DB, email *Email) (*Account, error) {
+	var account Account
+	err := db.Where(&Account{Email: email.Email}).FirstOrCreate(&account).Error
+	if err != nil {
+		return nil, err
+	}
+	if email.AccountID != account.ID {
+		email.AccountID = account.ID
+		err = db.Model(email).Update("account_id", account.ID).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = bindInvitations(db, &account)
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// migrateAccounts creates the accounts for confirmed emails that were added before accounts existed
+func (api *API) migrateAccounts() error {
+	var emails []Email
+	err := api.db.Where("account_id = 0 AND id IN (SELECT email_id FROM watches WHERE pending = ?)", false).Find(&emails).Error
+	if err != nil {
+		return err
+	}
+	for i := range emails {
+		_, err = ensureAccount(api.db, &emails[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accountEmail returns the email record of an account
+func (api *API) accountEmail(account *Account) (*Email, error) {
+	var email Email
+	err := api.db.Where(&Email{Email: account.Email}).Attrs(&Email{AccountID: account.ID}).FirstOrCreate(&email).Error
+	if err != nil {
+		return nil, err
+	}
+	return &email, nil
+}
+
+// currentAccount returns the account of the session cookie, nil if there is no valid session
+func (api *API) currentAccount(r *http.Request) (*Account, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, nil
+	}
+	var session Session
+	err = api.db.Where("id = ? AND expires_at > ?", hashSecret(cookie.Value), time.Now().UTC().Unix()).First(&session).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var account Account
+	err = api.db.Where(&Account{ID: session.AccountID}).First(&account).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (api *API) setSessionCookie(w http.ResponseWriter, value string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(*api.config.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// createSession logs an account in
+func (api *API) createSession(w http.ResponseWriter, account *Account) error {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	value := base64.RawURLEncoding.EncodeToString(random)
+	expires := time.Now().Add(api.config.sessionTTL)
+	err := api.db.Create(&Session{
+		ID:        hashSecret(value),
+		AccountID: account.ID,
+		ExpiresAt: expires.UTC().Unix(),
+	}).Error
+	if err != nil {
+		return err
+	}
+	api.setSessionCookie(w, value, expires)
+	return nil
+}
+
+func (api *API) expireSessions() error {
+	now := time.Now().UTC().Unix()
+	err := api.db.Where("expires_at < ?", now).Delete(&Session{}).Error
+	if err != nil {
+		return err
+	}
+	return api.db.Where("expires_at < ?", now).Delete(&LoginLink{}).Error
+}
+
+// sendLoginLink sends the mail with the magic link, the link can be used once
+// and confirms the watches that were added before it was sent
+func (api *API) sendLoginLink(recipient string) error {
+	expires := time.Now().Add(api.config.loginTTL)
+	nonce, err := randomString()
+	if err != nil {
+		return err
+	}
+	err = api.db.Create(&LoginLink{ID: hashSecret(nonce), ExpiresAt: expires.UTC().Unix()}).Error
+	if err != nil {
+		return err
+	}
+	until := time.Unix(time.Now().Unix()+1, 0)
+	token := api.signToken("login", expires, recipient, nonce, strconv.FormatInt(until.Unix(), 10))
+	return api.sendMail(recipient, TemplateLogin, &loginTemplateData{
+		mailContext: api.mailContext(),
+		Link:        api.link("/api1/login?token=" + url.QueryEscape(token)),
+		Expires:     expires.UTC().Format(time.RFC1123Z),
+	})
+}
+
+// loginRoute sends a magic link (POST with an email) and logs in with it (POST with the token),
+// the link in the mail opens a page that posts the token
+func (api *API) loginRoute(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if strings.EqualFold(r.Method, "GET") {
+		w.Header().Set("Location", "/login.html?token="+url.QueryEscape(token))
+		w.WriteHeader(302)
+		return
+	}
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST request")
+		return
+	}
+	if token == "" && strings.EqualFold(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		token = r.PostFormValue("token")
+	}
+	if token != "" {
+		api.verifyLogin(w, r, token)
+		return
+	}
+
+	apiRequest := struct {
+		Email string
+	}{}
+	redirect := false
+	contentType := r.Header.Get("Content-Type")
+	if strings.EqualFold(contentType, "application/json") {
+		err := json.NewDecoder(r.Body).Decode(&apiRequest)
+		if err != nil {
+			api.writeError(w, "invalid request")
+			return
+		}
+	} else if strings.EqualFold(contentType, "application/x-www-form-urlencoded") {
+		apiRequest.Email = r.FormValue("email")
+		redirect = true
+	} else {
+		api.writeError(w, "invalid request")
+		return
+	}
+
+	apiRequest.Email = strings.ToLower(apiRequest.Email)
+	if !govalidator.IsEmail(apiRequest.Email) {
+		if redirect {
+			w.Header().Set("Location", "/#invalid_email")
+			w.WriteHeader(302)
+		} else {
+			api.writeError(w, "invalid email")
+		}
+		return
+	}
+
+	// the account is created when the link is used, so unknown addresses get a link as well
+	err := api.enqueueMail(api.db, KindLogin, &Email{Email: apiRequest.Email})
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	if redirect {
+		w.Header().Set("Location", "/#login_mail")
+		w.WriteHeader(302)
+	} else {
+		api.writeSuccessResponse(w, &struct{ Sent bool }{true})
+	}
+}
+
+// verifyLogin creates a session for the magic link in token and invalidates the link,
+// using the link proves the ownership of the email, so its pending watches are confirmed
+func (api *API) verifyLogin(w http.ResponseWriter, r *http.Request, token string) {
+	fields, err := api.verifyToken("login", token)
+	var until int64
+	if err == nil && len(fields) == 3 {
+		until, err = strconv.ParseInt(fields[2], 10, 64)
+	}
+	if err != nil || len(fields) != 3 {
+		w.Header().Set("Location", "/#invalid_token")
+		w.WriteHeader(302)
+		return
+	}
+
+	// deleting the nonce makes sure only one request can use the link
+	db := api.db.Where(&LoginLink{ID: hashSecret(fields[1])}).Delete(&LoginLink{})
+	if db.Error != nil {
+		api.logError(w, db.Error)
+		return
+	}
+	if db.RowsAffected != 1 {
+		w.Header().Set("Location", "/#invalid_token")
+		w.WriteHeader(302)
+		return
+	}
+
+	var email Email
+	err = api.db.Where(&Email{Email: fields[0]}).Attrs(&Email{Locale: requestLocale(r)}).FirstOrCreate(&email).Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	account, err := ensureAccount(api.db, &email)
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	// watches that were added after the mail was sent need a confirmation of their own,
+	// the address received the link so it receives mails again
+	now := time.Now().UTC()
+	err = api.db.Model(account).Update("last_login", &now).Error
+	if err == nil {
+		err = api.db.Model(&email).Updates(map[string]interface{}{"bounces": 0, "suspended": false}).Error
+	}
+	if err == nil {
+		err = api.db.Model(&Watch{}).
+			Where("email_id = ? AND pending = ? AND created_at <= ?", email.ID, true, time.Unix(until, 0)).
+			Updates(map[string]interface{}{"pending": false}).Error
+	}
+	if err == nil {
+		err = api.createSession(w, account)
+	}
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/account.html")
+	w.WriteHeader(302)
+}
+
+func (api *API) logoutRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST request")
+		return
+	}
+
+	if cookie, err := r.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+		err = api.db.Where(&Session{ID: hashSecret(cookie.Value)}).Delete(&Session{}).Error
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+	}
+	api.setSessionCookie(w, "", time.Unix(0, 0))
+
+	if strings.EqualFold(r.Header.Get("Content-Type"), "application/json") {
+		api.writeSuccessResponse(w, nil)
+	} else {
+		w.Header().Set("Location", "/")
+		w.WriteHeader(302)
+	}
+}
+
+type accountWatch struct {
+	Domain    string
+	Channels  []string
+	Pending   bool
+	CreatedAt time.Time
+}
+
+// accountRoute returns the logged in account and its watches
+func (api *API) accountRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false {
+		api.writeError(w, "Must be a GET request")
+		return
+	}
+
+	account, ok := api.requestAccount(w, r, ScopeWatchRead)
+	if !ok {
+		return
+	}
+	if account == nil {
+		api.writeAccessDenied(w)
+		return
+	}
+	email, err := api.accountEmail(account)
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	rows, err := api.db.Table("watches").
+		Select("domains.domain, watches.channels, watches.pending, watches.created_at").
+		Joins("JOIN domains ON domains.id = watches.domain_id").
+		Where("watches.email_id = ?", email.ID).
+		Order("domains.domain").
+		Rows()
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	watches := []accountWatch{}
+	for rows.Next() {
+		var watch accountWatch
+		var channels string
+		err = rows.Scan(&watch.Domain, &channels, &watch.Pending, &watch.CreatedAt)
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+		watch.Channels = strings.Split(channels, ",")
+		watches = append(watches, watch)
+	}
+
+	api.writeSuccessResponse(w, &struct {
+		Email   string
+		Admin   bool
+		Watches []accountWatch
+	}{account.Email, account.Admin, watches})
+}
@@ -0,0 +1,89 @@
+package api1
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestLoginLink(t *testing.T) {
+	api := newTestAPI(t, nil)
+	pending := func(domain string) bool {
+		var watch Watch
+		err := api.db.Table("watches").
+			Joins("JOIN domains ON domains.id = watches.domain_id").
+			Where("domains.domain = ?", domain).
+			First(&watch).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		return watch.Pending
+	}
+
+	w := api.request("POST", "/api1/watch", map[string]interface{}{"Email": "a@example.com", "Domains": []string{"a-example.com"}}, nil)
+	if w.Code != 200 || !pending("a-example.com") {
+		t.Fatal(w.Body.String())
+	}
+	// the address gets an account once it proved to be its owner
+	if api.hasAccount("a@example.com") {
+		t.Fatal("an unconfirmed address has an account")
+	}
+	if err := api.sendLoginLink("a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	mails := api.mails()
+	if len(mails) != 1 {
+		t.Fatal(mails)
+	}
+	link, err := url.Parse(api.link(mails[0], "http://domwatch.test/api1/login?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := link.Query().Get("token")
+
+	// a watch that is added after the mail was sent is not confirmed by the login
+	w = api.request("POST", "/api1/watch", map[string]interface{}{"Email": "a@example.com", "Domains": []string{"b-example.com"}}, nil)
+	if w.Code != 200 {
+		t.Fatal(w.Body.String())
+	}
+	var domain Domain
+	api.db.Where(&Domain{Domain: "b-example.com"}).First(&domain)
+	api.db.Model(&Watch{}).Where("domain_id = ?", domain.ID).Update("created_at", time.Now().Add(time.Minute))
+
+	// a GET only shows the login page
+	w = api.request("GET", link.RequestURI(), nil, nil)
+	if w.Code != 302 || w.Header().Get("Location") != "/login.html?token="+url.QueryEscape(token) || sessionHeader(w) != nil {
+		t.Fatal(w.Code, w.Header())
+	}
+	if !pending("a-example.com") {
+		t.Fatal("GET confirmed the watch")
+	}
+
+	w = api.request("POST", "/api1/login", "token="+url.QueryEscape(token), nil)
+	if w.Code != 302 || w.Header().Get("Location") != "/account.html" || sessionHeader(w) == nil {
+		t.Fatal(w.Code, w.Header())
+	}
+	if pending("a-example.com") || !pending("b-example.com") {
+		t.Fatal("confirmed the wrong watches")
+	}
+	if !api.hasAccount("a@example.com") {
+		t.Fatal("no account")
+	}
+
+	// the link works once
+	w = api.request("POST", "/api1/login", "token="+url.QueryEscape(token), nil)
+	if w.Code != 302 || w.Header().Get("Location") != "/#invalid_token" || sessionHeader(w) != nil {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	w = api.request("POST", "/api1/login", "token=invalid", nil)
+	if w.Code != 302 || w.Header().Get("Location") != "/#invalid_token" {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	// a form without a token asks for a link
+	w = api.request("POST", "/api1/login", "email=b%40example.com", nil)
+	if w.Code != 302 || w.Header().Get("Location") != "/#login_mail" {
+		t.Fatal(w.Code, w.Header())
+	}
+}
@@ -1,253 +1,349 @@
-package api1
-
-import (
-	"bytes"
-	"fmt"
-	"html/template"
-	"log"
-	"net/http"
-	"net/smtp"
-	"time"
-
-	"encoding/json"
-
-	"strconv"
-
-	"github.com/Eun/domwatch"
-	"github.com/gorilla/mux"
-	"github.com/jinzhu/gorm"
-	"github.com/miekg/dns"
-)
-
-type API struct {
-	db        *gorm.DB
-	closeChan chan bool
-	config    *Config
-	logger    *log.Logger
-}
-
-func NewApi(config *Config, db *gorm.DB, router *mux.Router, logger *log.Logger) (*API, error) {
-	var api API
-	api.db = db
-
-	err := config.SetDefaults()
-	if err != nil {
-		return nil, err
-	}
-
-	api.config = config
-
-	db.AutoMigrate(&Domain{})
-	db.AutoMigrate(&Email{})
-	db.AutoMigrate(&Watch{})
-
-	router.HandleFunc("/stats", api.statsRoute)
-	router.HandleFunc("/watch", api.watchRoute)
-	router.HandleFunc("/unwatch", api.unwatchRoute)
-
-	api.logger = logger
-
-	return &api, nil
-}
-
-func (api *API) Run() error {
-	api.closeChan = make(chan bool)
-	go api.watchDomainsTask()
-	return nil
-}
-
-func (api *API) Close() {
-	api.closeChan <- true
-}
-
-func (api *API) writeError(w http.ResponseWriter, err string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(400)
-	json.NewEncoder(w).Encode(&struct{ Error string }{err})
-}
-
-func (api *API) writeNotFound(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(404)
-	json.NewEncoder(w).Encode(&struct{ Error string }{"not found"})
-}
-
-func (api *API) writeAccessDenied(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(403)
-	json.NewEncoder(w).Encode(&struct{ Error string }{"access denied"})
-}
-
-func (api *API) writeSuccessResponse(w http.ResponseWriter, v interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	if v != nil {
-		json.NewEncoder(w).Encode(v)
-	}
-}
-
-func (api *API) logError(w http.ResponseWriter, err error) {
-	id, _ := genUUID()
-	api.logger.Printf("Error ID=%s: %s", id, err.Error())
-	api.writeError(w, fmt.Sprintf("A wild error appeard, thankfully it was logged, its id is %s", id))
-}
-
-func (api *API) watchDomainsTask() {
-	api.watchDomains()
-	timer := time.NewTimer(api.config.intervalDuration)
-	for {
-		select {
-		case <-api.closeChan:
-			return
-		case <-timer.C:
-			api.watchDomains()
-			timer = time.NewTimer(api.config.intervalDuration)
-		}
-	}
-}
-
-type devNullWriter struct {
-}
-
-func (*devNullWriter) Write(p []byte) (n int, err error) {
-	return len(p), nil
-}
-
-func (api *API) watchDomains() {
-	api.logger.Println("Checking domains")
-	now := time.Now().UTC().Unix()
-	var domains []Domain
-	err := api.db.Find(&domains).Error
-	if err != nil {
-		api.logger.Printf("Error on watchDomains: %s", err.Error())
-		return
-	}
-
-	db := api.db
-
-	var available bool
-	for _, dom := range domains {
-
-		// are there any watchers for this domain?
-		var watches []Watch
-		err := api.db.Where(&Watch{DomainID: dom.ID}).Find(&watches).Error
-		if err != nil {
-			continue
-		}
-
-		// if not delete it right away
-		if len(watches) == 0 {
-			db = db.Delete(&dom)
-			continue
-		}
-
-		api.logger.Printf("Checking '%s'\n", dom.Domain)
-		available, err = domwatch.IsDomainAvailable(*api.config.DNSServer, dom.Domain, "tcp", []uint16{dns.TypeNS, dns.TypeSOA}, api.logger)
-		if err == nil && available {
-			api.logger.Printf("'%s' is available\n", dom.Domain)
-			err = api.notifyWatchers(watches, &dom)
-			if err != nil {
-				api.logger.Printf("Error on notifyUsers: %s", err.Error())
-				return
-			}
-			db = db.Delete(&dom)
-			db = db.Delete(&watches)
-		} else {
-			dom.LastChecked = now
-			db = db.Save(&dom)
-			if err != nil {
-				api.logger.Printf("Error  for '%s': %s\n", dom.Domain, err.Error())
-			}
-		}
-
-		err = db.Error
-		if err != nil {
-			api.logger.Printf("Error on watchDomains: %s", err.Error())
-			return
-		}
-
-	}
-
-}
-
-func (api *API) notifyWatchers(watches []Watch, domain *Domain) error {
-
-	for _, w := range watches {
-		var email Email
-		err := api.db.Where(&Email{ID: w.EmailID}).Find(&email).Error
-		if err != nil {
-			continue
-		}
-		err = api.notifyUser(&email, domain)
-		if err != nil {
-			api.logger.Printf("Error on notifyUsers: %s", err.Error())
-		}
-	}
-
-	return nil
-}
-
-type smtpTemplateData struct {
-	From         string
-	To           string
-	Domain       string
-	Time         string
-	OtherDomains []string
-}
-
-const emailTemplate = `From: {{.From}}
-To: {{.To}}
-Subject: ⚠️ {{.Domain}} is available!
-Date: {{.Time}}
-
-We just wanted to notify you that the domain
-
-    {{.Domain}}
-
-is now available.
-{{if .OtherDomains}}
-You are also subscribed to following domains: {{range $index, $element := .OtherDomains}}{{if $index}}, {{end}}{{$element}}{{end}}
-{{end}}
-Sincerely,
-
-dom.watch
-`
-
-func (api *API) notifyUser(email *Email, domain *Domain) (err error) {
-	var doc bytes.Buffer
-	context := &smtpTemplateData{
-		*api.config.Mail.Sender,
-		email.Email,
-		domain.Domain,
-		time.Now().UTC().Format(time.RFC1123Z),
-		[]string{},
-	}
-
-	var watches []Watch
-	err = api.db.Where(&Watch{EmailID: email.ID}).Find(&watches).Error
-	if err == nil {
-		for _, w := range watches {
-			var d Domain
-			err = api.db.Where(&Domain{ID: w.DomainID}).Find(&d).Error
-			if err == nil && d.Domain != domain.Domain {
-				context.OtherDomains = append(context.OtherDomains, d.Domain)
-			}
-		}
-	}
-
-	t := template.New("emailTemplate")
-	t, err = t.Parse(emailTemplate)
-	if err != nil {
-		return err
-	}
-	err = t.Execute(&doc, context)
-	if err != nil {
-		return err
-	}
-
-	return smtp.SendMail(*api.config.Mail.Server+":"+strconv.Itoa(*api.config.Mail.Port),
-		api.config.mailAuth,
-		*api.config.Mail.Sender,
-		[]string{email.Email},
-		doc.Bytes())
-}
+package api1
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"encoding/json"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+type API struct {
+	db         *gorm.DB
+	closeChan  chan bool
+	outboxChan chan bool
+	notifiers  map[string]Notifier
+	secret     []byte
+	templates  *mailTemplates
+	transport  Transport
+	dkim       *dkimSigner
+	health     healthCache
+	checks     checkCache
+	limiter    rateLimiter
+	checkSlots chan struct{} // limits the concurrent batch checks
+	tlds       tldCache
+	oidc       *oidcProvider
+	config     *Config
+	logger     *log.Logger
+}
+
+func NewApi(config *Config, db *gorm.DB, router *mux.Router, logger *log.Logger) (*API, error) {
+	var api API
+	api.db = db
+
+	err := config.SetDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	api.config = config
+	api.checkSlots = make(chan struct{}, *config.Check.Concurrency)
+
+	api.transport, err = newTransport(&config.Mail)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Mail.DKIM.KeyFile != nil {
+		api.dkim, err = newDKIMSigner(&config.Mail.DKIM)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.OIDC.Issuer != nil {
+		api.oidc = newOIDCProvider(&config.OIDC)
+	}
+
+	api.templates, err = loadMailTemplates(*config.Mail.Templates, *config.Mail.Locale)
+	if err != nil {
+		return nil, err
+	}
+
+	err = api.setupNotifiers()
+	if err != nil {
+		return nil, err
+	}
+
+	db.AutoMigrate(&Domain{})
+	db.AutoMigrate(&Email{})
+	db.AutoMigrate(&Watch{})
+	db.AutoMigrate(&Message{})
+	db.AutoMigrate(&Delivery{})
+	db.AutoMigrate(&PushSubscription{})
+	db.AutoMigrate(&Setting{})
+	db.AutoMigrate(&Lock{})
+	db.AutoMigrate(&Alert{})
+	db.AutoMigrate(&Account{})
+	db.AutoMigrate(&Session{})
+	db.AutoMigrate(&LoginLink{})
+	db.AutoMigrate(&APIKey{})
+	db.AutoMigrate(&Organisation{})
+	db.AutoMigrate(&Membership{})
+	db.AutoMigrate(&OrgWatch{})
+
+	err = api.loadSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = api.migrateAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	router.HandleFunc("/openapi.json", api.openAPIRoute)
+	router.HandleFunc("/stats", api.statsRoute)
+	router.HandleFunc("/health", api.healthRoute)
+	router.HandleFunc("/watch", api.watchRoute)
+	router.HandleFunc("/watch/resend", api.resendRoute)
+	router.HandleFunc("/check", api.checkRoute)
+	router.HandleFunc("/check/batch", api.batchRoute)
+	router.HandleFunc("/check/matrix", api.matrixRoute)
+	router.HandleFunc("/suggest", api.suggestRoute)
+	router.HandleFunc("/watches", api.watchesRoute)
+	router.HandleFunc("/watches/{domain}", api.watchDetailRoute)
+	router.HandleFunc("/confirm", api.confirmRoute)
+	router.HandleFunc("/unwatch", api.unwatchRoute)
+	router.HandleFunc("/unsubscribe", api.unsubscribeRoute)
+	router.HandleFunc("/preferences", api.preferencesRoute)
+	router.HandleFunc("/ack", api.ackRoute)
+	router.HandleFunc("/login", api.loginRoute)
+	router.HandleFunc("/logout", api.logoutRoute)
+	router.HandleFunc("/account", api.accountRoute)
+	router.HandleFunc("/orgs", api.orgsRoute)
+	router.HandleFunc("/orgs/{id:[0-9]+}", api.orgRoute)
+	router.HandleFunc("/orgs/{id:[0-9]+}/watch", api.orgWatchRoute)
+	router.HandleFunc("/orgs/{id:[0-9]+}/unwatch", api.orgUnwatchRoute)
+	router.HandleFunc("/orgs/{id:[0-9]+}/members", api.orgMembersRoute)
+	router.HandleFunc("/orgs/{id:[0-9]+}/members/{account:[0-9]+}", api.orgMemberRoute)
+	router.HandleFunc("/orgs/{id:[0-9]+}/accept", api.orgAcceptRoute)
+	router.HandleFunc("/orgs/{id:[0-9]+}/preferences", api.orgPreferencesRoute)
+	router.HandleFunc("/oidc", api.oidcRoute)
+	router.HandleFunc("/oidc/login", api.oidcLoginRoute)
+	router.HandleFunc("/oidc/callback", api.oidcCallbackRoute)
+	router.HandleFunc("/keys", api.keysRoute)
+	router.HandleFunc("/keys/{id:[0-9]+}", api.keyRoute)
+	router.HandleFunc("/push/key", api.pushKeyRoute)
+	router.HandleFunc("/push/subscribe", api.pushSubscribeRoute)
+	router.HandleFunc("/push/unsubscribe", api.pushUnsubscribeRoute)
+	router.HandleFunc("/templates/{name}/preview", api.templatePreviewRoute)
+	router.HandleFunc("/outbox", api.outboxRoute)
+	router.HandleFunc("/outbox/{id:[0-9]+}/retry", api.outboxRetryRoute)
+	router.HandleFunc("/outbox/{id:[0-9]+}/deliveries", api.outboxDeliveriesRoute)
+
+	api.logger = logger
+
+	return &api, nil
+}
+
+func (api *API) Run() error {
+	api.closeChan = make(chan bool)
+	api.outboxChan = make(chan bool, 1)
+	go api.watchDomainsTask()
+	go api.dispatchTask()
+	if api.config.Mail.Bounces.Maildir != nil || api.config.Mail.Bounces.IMAP.Server != nil {
+		go api.bounceTask()
+	}
+	return nil
+}
+
+func (api *API) Close() {
+	close(api.closeChan)
+}
+
+func (api *API) writeError(w http.ResponseWriter, err string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(400)
+	json.NewEncoder(w).Encode(&struct{ Error string }{err})
+}
+
+func (api *API) writeNotFound(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(404)
+	json.NewEncoder(w).Encode(&struct{ Error string }{"not found"})
+}
+
+func (api *API) writeAccessDenied(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(403)
+	json.NewEncoder(w).Encode(&struct{ Error string }{"access denied"})
+}
+
+func (api *API) writeSuccessResponse(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	if v != nil {
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+func (api *API) logError(w http.ResponseWriter, err error) {
+	id, _ := genUUID()
+	api.logger.Printf("Error ID=%s: %s", id, err.Error())
+	api.writeError(w, fmt.Sprintf("A wild error appeard, thankfully it was logged, its id is %s", id))
+}
+
+func (api *API) watchDomainsTask() {
+	api.watchDomains()
+	timer := time.NewTimer(api.config.pollDuration)
+	for {
+		select {
+		case <-api.closeChan:
+			return
+		case <-timer.C:
+			api.watchDomains()
+			timer = time.NewTimer(api.config.pollDuration)
+		}
+	}
+}
+
+type devNullWriter struct {
+}
+
+func (*devNullWriter) Write(p []byte) (n int, err error) {
+	return len(p), nil
+}
+
+func (api *API) watchDomains() {
+	err := api.expirePendingWatches()
+	if err != nil {
+		api.logger.Printf("Error on expirePendingWatches: %s", err.Error())
+	}
+	err = api.expireSessions()
+	if err != nil {
+		api.logger.Printf("Error on expireSessions: %s", err.Error())
+	}
+
+	for {
+		domains, err := api.claimDomains(leaseBatchSize)
+		if err != nil {
+			api.logger.Printf("Error on watchDomains: %s", err.Error())
+			return
+		}
+		if len(domains) == 0 {
+			return
+		}
+		api.logger.Printf("Checking %d domains\n", len(domains))
+
+		for i := range domains {
+			err = api.checkDomain(&domains[i])
+			if err != nil {
+				api.logger.Printf("Error on watchDomains: %s", err.Error())
+				return
+			}
+		}
+	}
+}
+
+func (api *API) checkDomain(dom *Domain) error {
+	now := time.Now().UTC().Unix()
+
+	// are there any watchers for this domain?
+	var watches []Watch
+	err := api.db.Where(&Watch{DomainID: dom.ID}).Find(&watches).Error
+	if err != nil {
+		return err
+	}
+
+	var orgWatches int
+	err = api.db.Model(&OrgWatch{}).Where(&OrgWatch{DomainID: dom.ID}).Count(&orgWatches).Error
+	if err != nil {
+		return err
+	}
+
+	// if not delete it right away
+	if len(watches) == 0 && orgWatches == 0 {
+		return api.db.Delete(dom).Error
+	}
+
+	// only confirmed watches get notified
+	confirmed := watches[:0]
+	notified := make(map[uint]bool)
+	for _, w := range watches {
+		if !w.Pending {
+			confirmed = append(confirmed, w)
+			notified[w.EmailID] = true
+		}
+	}
+	watches = confirmed
+
+	// members of organisations are notified once, their own watch takes precedence
+	members, err := orgWatchers(api.db, dom.ID)
+	if err != nil {
+		return err
+	}
+	for _, w := range members {
+		if !notified[w.EmailID] {
+			watches = append(watches, w)
+			notified[w.EmailID] = true
+		}
+	}
+	if len(watches) == 0 {
+		return api.releaseDomain(dom, now, "")
+	}
+
+	api.logger.Printf("Checking '%s'\n", dom.Domain)
+	result, err := api.runCheck(dom.Domain)
+	if err != nil || !result.Available {
+		verdict := VerdictError
+		if err != nil {
+			api.logger.Printf("Error  for '%s': %s\n", dom.Domain, err.Error())
+		} else {
+			verdict = result.Verdict
+		}
+		return api.releaseDomain(dom, now, verdict)
+	}
+
+	api.logger.Printf("'%s' is available\n", dom.Domain)
+
+	// the messages are written in the same transaction that removes the domain,
+	// so an availability event can not get lost
+	tx := api.db.Begin()
+	err = api.enqueueMessages(tx, watches, dom, result)
+	if err == nil {
+		err = tx.Where(&Watch{DomainID: dom.ID}).Delete(&Watch{}).Error
+	}
+	if err == nil {
+		err = tx.Where(&OrgWatch{DomainID: dom.ID}).Delete(&OrgWatch{}).Error
+	}
+	if err == nil {
+		err = tx.Delete(dom).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit().Error
+	if err != nil {
+		return err
+	}
+	api.wakeDispatcher()
+	return nil
+}
+
+type availableTemplateData struct {
+	mailContext
+	Domain      string
+	Reminder    bool
+	Ack         string
+	Preferences string
+	Unsubscribe string
+}
+
+func (api *API) notifyUser(notification *Notification) error {
+	recipient := notification.Recipient
+	context := &availableTemplateData{
+		mailContext: api.mailContext(),
+		Domain:      notification.Domain,
+		Reminder:    notification.Step > 0,
+		Ack:         notification.Ack,
+		Preferences: api.preferencesLink(recipient),
+		Unsubscribe: api.unsubscribeLink(recipient, ""),
+	}
+	return api.sendMail(recipient, TemplateAvailable, context, api.unsubscribeHeaders(recipient)...)
+}
@@ -0,0 +1,91 @@
+// Package api1test prepares the environment of api1 for the tests of api1 and of the packages that build on it.
+// It does not import api1, so the tests inside of api1 can use it as well.
+package api1test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// Env is a temporary directory with a sqlite database and the settings of a maildir transport in it
+type Env struct {
+	Dir    string
+	DB     *gorm.DB
+	Config map[string]interface{} // the settings for api1.NewConfigFromMap
+}
+
+// New creates an environment that is removed when the test finished.
+// The settings are added to the defaults, sections of the defaults like Mail are merged.
+func New(t *testing.T, settings map[string]interface{}) *Env {
+	dir, err := ioutil.TempDir("", "domwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := gorm.Open("sqlite3", filepath.Join(dir, "domwatch.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	config := map[string]interface{}{
+		"BaseURL": "http://domwatch.test",
+		"Mail": map[string]interface{}{
+			"Transport": "maildir",
+			"Maildir":   filepath.Join(dir, "mail"),
+			"Sender":    "domwatch@example.com",
+		},
+	}
+	for key, value := range settings {
+		if section, ok := config[key].(map[string]interface{}); ok {
+			if values, ok := value.(map[string]interface{}); ok {
+				for name, value := range values {
+					section[name] = value
+				}
+				continue
+			}
+		}
+		config[key] = value
+	}
+	// round trip through JSON so the settings look like a parsed config file
+	raw, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = nil
+	if err = json.Unmarshal(raw, &config); err != nil {
+		t.Fatal(err)
+	}
+	return &Env{Dir: dir, DB: db, Config: config}
+}
+
+// Logger logs to the test, the output is only shown if the test fails
+func Logger(t *testing.T) *log.Logger {
+	return log.New(writer{t}, "", 0)
+}
+
+type writer struct {
+	t *testing.T
+}
+
+func (w writer) Write(p []byte) (int, error) {
+	w.t.Log(string(bytes.TrimRight(p, "\n")))
+	return len(p), nil
+}
+
+// HashKey returns the stored hash of an API key
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,153 @@
+package api1
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Eun/domwatch/fcgi/api1/api1test"
+	"github.com/gorilla/mux"
+)
+
+// testAPI is an API with a sqlite database and a maildir transport in a temporary directory
+type testAPI struct {
+	*API
+	t      *testing.T
+	router *mux.Router
+	dir    string
+}
+
+func newTestAPI(t *testing.T, settings map[string]interface{}) *testAPI {
+	env := api1test.New(t, settings)
+	config, err := NewConfigFromMap(env.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	api, err := NewApi(config, env.DB, router.PathPrefix("/api1").Subrouter(), api1test.Logger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testAPI{API: api, t: t, router: router, dir: env.Dir}
+}
+
+// request serves a request, a body that is not a string or a reader is sent as JSON
+func (api *testAPI) request(method string, path string, body interface{}, header http.Header) *httptest.ResponseRecorder {
+	var reader io.Reader
+	contentType := ""
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewReader([]byte(b))
+		contentType = "application/x-www-form-urlencoded"
+	case io.Reader:
+		reader = b
+	default:
+		raw, err := json.Marshal(b)
+		if err != nil {
+			api.t.Fatal(err)
+		}
+		reader = bytes.NewReader(raw)
+		contentType = "application/json"
+	}
+	r := httptest.NewRequest(method, path, reader)
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	api.router.ServeHTTP(w, r)
+	return w
+}
+
+// login creates an account for email and returns the header with its session cookie
+func (api *testAPI) login(email string) http.Header {
+	var address Email
+	if err := api.db.Where(&Email{Email: email}).FirstOrCreate(&address).Error; err != nil {
+		api.t.Fatal(err)
+	}
+	account, err := ensureAccount(api.db, &address)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	if err = api.createSession(w, account); err != nil {
+		api.t.Fatal(err)
+	}
+	header := http.Header{}
+	for _, cookie := range w.Result().Cookies() {
+		header.Add("Cookie", cookie.Name+"="+cookie.Value)
+	}
+	return header
+}
+
+// hasAccount tells whether there is an account for email
+func (api *testAPI) hasAccount(email string) bool {
+	var count int
+	if err := api.db.Model(&Account{}).Where(&Account{Email: email}).Count(&count).Error; err != nil {
+		api.t.Fatal(err)
+	}
+	return count > 0
+}
+
+// mails returns the text parts of the mails in the maildir, the oldest first
+func (api *testAPI) mails() []string {
+	dir := filepath.Join(api.dir, "mail", "new")
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		api.t.Fatal(err)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+	var texts []string
+	for _, entry := range entries {
+		raw, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			api.t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			api.t.Fatal(err)
+		}
+		_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		if err != nil {
+			api.t.Fatal(err)
+		}
+		parts := multipart.NewReader(msg.Body, params["boundary"])
+		for {
+			part, err := parts.NextPart()
+			if err != nil {
+				break
+			}
+			if strings.HasPrefix(part.Header.Get("Content-Type"), "text/plain") {
+				text, _ := ioutil.ReadAll(part)
+				texts = append(texts, string(text))
+			}
+		}
+	}
+	return texts
+}
+
+// link returns the first link of a mail text that starts with prefix
+func (api *testAPI) link(text string, prefix string) string {
+	start := strings.Index(text, prefix)
+	if start < 0 {
+		api.t.Fatalf("no link to %s in %s", prefix, text)
+	}
+	return strings.Fields(text[start:])[0]
+}
@@ -0,0 +1,309 @@
+package api1
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	ScopeWatchRead  = "watch:read"
+	ScopeWatchWrite = "watch:write"
+	ScopeCheck      = "check"
+	ScopeKeys       = "keys" // manage the API keys of the account
+)
+
+var allScopes = []string{ScopeWatchRead, ScopeWatchWrite, ScopeCheck, ScopeKeys}
+
+// apiKeyPrefix marks API keys, so they can not be confused with the admin token
+const apiKeyPrefix = "dw_"
+
+// last used is only written once per apiKeyTouchInterval to keep requests cheap
+const apiKeyTouchInterval = time.Minute
+
+// APIKey grants programmatic access to an account, only the hash of the key is stored
+type APIKey struct {
+	ID        uint   `gorm:"primary_key;not null"`
+	AccountID uint   `gorm:"not null;index"`
+	Name      string `gorm:"type:char(64);not null"`
+	Prefix    string `gorm:"type:char(16);not null"` // the beginning of the key to recognize it
+	Hash      string `gorm:"type:char(64);unique;not null"`
+	Scopes    string `gorm:"type:char(255);not null"`
+	ExpiresAt *time.Time
+	LastUsed  *time.Time
+	CreatedAt time.Time
+}
+
+type apiKeyInfo struct {
+	ID        uint
+	Name      string
+	Prefix    string
+	Scopes    []string
+	ExpiresAt *time.Time
+	LastUsed  *time.Time
+	CreatedAt time.Time
+}
+
+func (key *APIKey) info() *apiKeyInfo {
+	return &apiKeyInfo{key.ID, key.Name, key.Prefix, strings.Split(key.Scopes, ","), key.ExpiresAt, key.LastUsed, key.CreatedAt}
+}
+
+func (key *APIKey) allows(scope string) bool {
+	for _, s := range strings.Split(key.Scopes, ",") {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// parseScopes validates a list of scopes and returns them in the stored form
+func parseScopes(scopes []string) (string, bool) {
+	var valid []string
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		found := false
+		for _, s := range allScopes {
+			if s == scope {
+				found = true
+			}
+		}
+		if !found {
+			return "", false
+		}
+		valid = append(valid, scope)
+	}
+	return strings.Join(valid, ","), len(valid) > 0
+}
+
+// lookupAPIKey returns the key for a bearer token, nil if it is unknown or expired
+func (api *API) lookupAPIKey(token string) (*APIKey, error) {
+	var key APIKey
+	err := api.db.Where(&APIKey{Hash: hashSecret(token)}).First(&key).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if key.ExpiresAt != nil && key.ExpiresAt.Before(now) {
+		return nil, nil
+	}
+	if key.LastUsed == nil || now.Sub(*key.LastUsed) > apiKeyTouchInterval {
+		key.LastUsed = &now
+		err = api.db.Model(&APIKey{}).Where("id = ?", key.ID).Update("last_used", &now).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &key, nil
+}
+
+var (
+	ErrInvalidKey = errors.New("invalid API key")                // unknown or expired
+	ErrScope      = errors.New("API key lacks the needed scope") // the key is valid but may not do this
+)
+
+// Authenticate returns the account a request is authenticated as, either by an API key
+// with scope or by the session cookie, nil for anonymous requests.
+// A key that is invalid or lacks the scope returns ErrInvalidKey or ErrScope.
+func (api *API) Authenticate(r *http.Request, scope string) (*Account, error) {
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer "+apiKeyPrefix) {
+		key, err := api.lookupAPIKey(strings.TrimPrefix(authorization, "Bearer "))
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return nil, ErrInvalidKey
+		}
+		if !key.allows(scope) {
+			return nil, ErrScope
+		}
+		var account Account
+		err = api.db.Where(&Account{ID: key.AccountID}).First(&account).Error
+		if err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return nil, ErrInvalidKey
+			}
+			return nil, err
+		}
+		return &account, nil
+	}
+	return api.currentAccount(r)
+}
+
+// requestAccount is Authenticate for the routes of api1,
+// if it returns false an error response was written.
+func (api *API) requestAccount(w http.ResponseWriter, r *http.Request, scope string) (*Account, bool) {
+	account, err := api.Authenticate(r, scope)
+	if err != nil {
+		if err == ErrInvalidKey || err == ErrScope {
+			api.writeAccessDenied(w)
+		} else {
+			api.logError(w, err)
+		}
+		return nil, false
+	}
+	return account, true
+}
+
+// requestKey returns the API key of a request, nil if it uses a session
+func (api *API) requestKey(r *http.Request) (*APIKey, error) {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer "+apiKeyPrefix) {
+		return nil, nil
+	}
+	return api.lookupAPIKey(strings.TrimPrefix(authorization, "Bearer "))
+}
+
+// keysRoute lists (GET) and creates (POST) the API keys of the account
+func (api *API) keysRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false && strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a GET or POST request")
+		return
+	}
+	account, ok := api.requestAccount(w, r, ScopeKeys)
+	if !ok {
+		return
+	}
+	if account == nil {
+		api.writeAccessDenied(w)
+		return
+	}
+
+	if strings.EqualFold(r.Method, "GET") {
+		var keys []APIKey
+		err := api.db.Where(&APIKey{AccountID: account.ID}).Order("id").Find(&keys).Error
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+		infos := []*apiKeyInfo{}
+		for i := range keys {
+			infos = append(infos, keys[i].info())
+		}
+		api.writeSuccessResponse(w, infos)
+		return
+	}
+
+	apiRequest := struct {
+		Name    string
+		Scopes  []string
+		Expires string // duration, the key does not expire if empty
+	}{}
+	if !strings.EqualFold(r.Header.Get("Content-Type"), "application/json") {
+		api.writeError(w, "invalid request")
+		return
+	}
+	err := json.NewDecoder(r.Body).Decode(&apiRequest)
+	if err != nil {
+		api.writeError(w, "invalid request")
+		return
+	}
+
+	apiRequest.Name = strings.TrimSpace(apiRequest.Name)
+	if apiRequest.Name == "" || len(apiRequest.Name) > 64 {
+		api.writeError(w, "invalid name")
+		return
+	}
+	scopes, ok := parseScopes(apiRequest.Scopes)
+	if !ok {
+		api.writeError(w, "invalid scopes")
+		return
+	}
+
+	// a key can only create keys with its own scopes
+	current, err := api.requestKey(r)
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	if current != nil {
+		for _, scope := range strings.Split(scopes, ",") {
+			if !current.allows(scope) {
+				api.writeAccessDenied(w)
+				return
+			}
+		}
+	}
+
+	var expiresAt *time.Time
+	if apiRequest.Expires != "" {
+		d, err := time.ParseDuration(apiRequest.Expires)
+		if err != nil || d <= 0 {
+			api.writeError(w, "invalid expires")
+			return
+		}
+		t := time.Now().UTC().Add(d)
+		expiresAt = &t
+	}
+
+	random := make([]byte, 32)
+	if _, err = rand.Read(random); err != nil {
+		api.logError(w, err)
+		return
+	}
+	secret := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(random)
+	key := APIKey{
+		AccountID: account.ID,
+		Name:      apiRequest.Name,
+		Prefix:    secret[:len(apiKeyPrefix)+8],
+		Hash:      hashSecret(secret),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	err = api.db.Create(&key).Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	// the key is only shown once
+	api.writeSuccessResponse(w, &struct {
+		*apiKeyInfo
+		Key string
+	}{key.info(), secret})
+}
+
+// keyRoute revokes an API key of the account
+func (api *API) keyRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "DELETE") == false {
+		api.writeError(w, "Must be a DELETE request")
+		return
+	}
+	account, ok := api.requestAccount(w, r, ScopeKeys)
+	if !ok {
+		return
+	}
+	if account == nil {
+		api.writeAccessDenied(w)
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		api.writeNotFound(w)
+		return
+	}
+
+	db := api.db.Where(&APIKey{ID: uint(id), AccountID: account.ID}).Delete(&APIKey{})
+	if db.Error != nil {
+		api.logError(w, db.Error)
+		return
+	}
+	if db.RowsAffected == 0 {
+		api.writeNotFound(w)
+		return
+	}
+	api.writeSuccessResponse(w, nil)
+}
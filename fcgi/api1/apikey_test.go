@@ -0,0 +1,144 @@
+package api1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// createKey creates an API key and returns its id and the header that authenticates with it
+func (api *testAPI) createKey(header http.Header, body map[string]interface{}) (uint, http.Header) {
+	w := api.request("POST", "/api1/keys", body, header)
+	if w.Code != 200 {
+		api.t.Fatalf("%d %s", w.Code, w.Body.String())
+	}
+	var key struct {
+		ID  uint
+		Key string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &key); err != nil {
+		api.t.Fatal(err)
+	}
+	return key.ID, http.Header{"Authorization": {"Bearer " + key.Key}}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	api := newTestAPI(t, nil)
+	session := api.login("a@example.com")
+
+	for _, scopes := range [][]string{nil, {}, {"admin"}, {ScopeWatchRead, ""}} {
+		w := api.request("POST", "/api1/keys", map[string]interface{}{"Name": "ci", "Scopes": scopes}, session)
+		if w.Code != 400 {
+			t.Fatalf("%q: %d %s", scopes, w.Code, w.Body.String())
+		}
+	}
+	w := api.request("POST", "/api1/keys", map[string]interface{}{"Name": "ci", "Scopes": allScopes}, nil)
+	if w.Code != 403 {
+		t.Fatalf("%d %s", w.Code, w.Body.String())
+	}
+
+	_, read := api.createKey(session, map[string]interface{}{"Name": "read", "Scopes": []string{ScopeWatchRead, " Keys "}})
+	tests := []struct {
+		method string
+		path   string
+		body   interface{}
+		header http.Header
+		code   int
+	}{
+		{"GET", "/api1/watches", nil, read, 200},
+		{"POST", "/api1/watch", map[string]interface{}{"Domains": []string{"example.net"}}, read, 403},
+		{"GET", "/api1/check?domain=example.net", nil, read, 403},
+		{"GET", "/api1/keys", nil, read, 200},
+		// a key can only create keys with its own scopes
+		{"POST", "/api1/keys", map[string]interface{}{"Name": "write", "Scopes": []string{ScopeWatchWrite}}, read, 403},
+		{"POST", "/api1/keys", map[string]interface{}{"Name": "all", "Scopes": allScopes}, read, 403},
+		{"POST", "/api1/keys", map[string]interface{}{"Name": "read", "Scopes": []string{ScopeWatchRead}}, read, 200},
+		// unknown keys are denied and not treated as anonymous requests
+		{"GET", "/api1/watches", nil, http.Header{"Authorization": {"Bearer " + apiKeyPrefix + "unknown"}}, 403},
+	}
+	for _, test := range tests {
+		w := api.request(test.method, test.path, test.body, test.header)
+		if w.Code != test.code {
+			t.Fatalf("%s %s: %d %s", test.method, test.path, w.Code, w.Body.String())
+		}
+	}
+
+	var keys []*apiKeyInfo
+	w = api.request("GET", "/api1/keys", nil, session)
+	if err := json.Unmarshal(w.Body.Bytes(), &keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || fmt.Sprint(keys[0].Scopes) != fmt.Sprint([]string{ScopeWatchRead, ScopeKeys}) || keys[0].LastUsed == nil || keys[1].LastUsed != nil {
+		t.Fatal(w.Body.String())
+	}
+}
+
+func TestAPIKeyExpiry(t *testing.T) {
+	api := newTestAPI(t, nil)
+	session := api.login("a@example.com")
+
+	for _, expires := range []string{"-1h", "0s", "tomorrow"} {
+		w := api.request("POST", "/api1/keys", map[string]interface{}{"Name": "ci", "Scopes": []string{ScopeKeys}, "Expires": expires}, session)
+		if w.Code != 400 {
+			t.Fatalf("%s: %d %s", expires, w.Code, w.Body.String())
+		}
+	}
+
+	id, header := api.createKey(session, map[string]interface{}{"Name": "ci", "Scopes": []string{ScopeKeys}, "Expires": "1h"})
+	w := api.request("GET", "/api1/keys", nil, header)
+	if w.Code != 200 {
+		t.Fatal(w.Body.String())
+	}
+	expired := time.Now().UTC().Add(-time.Second)
+	if err := api.db.Model(&APIKey{}).Where("id = ?", id).Update("expires_at", &expired).Error; err != nil {
+		t.Fatal(err)
+	}
+	w = api.request("GET", "/api1/keys", nil, header)
+	if w.Code != 403 {
+		t.Fatalf("%d %s", w.Code, w.Body.String())
+	}
+	// the session still works
+	w = api.request("GET", "/api1/keys", nil, session)
+	if w.Code != 200 {
+		t.Fatal(w.Body.String())
+	}
+}
+
+func TestAPIKeyRevoke(t *testing.T) {
+	api := newTestAPI(t, nil)
+	a := api.login("a@example.com")
+	b := api.login("b@example.com")
+
+	id, header := api.createKey(a, map[string]interface{}{"Name": "ci", "Scopes": []string{ScopeKeys}})
+	path := fmt.Sprintf("/api1/keys/%d", id)
+
+	// another account can neither see nor revoke the key
+	w := api.request("DELETE", path, nil, b)
+	if w.Code != 404 {
+		t.Fatalf("%d %s", w.Code, w.Body.String())
+	}
+	_, other := api.createKey(b, map[string]interface{}{"Name": "ci", "Scopes": []string{ScopeKeys}})
+	w = api.request("DELETE", path, nil, other)
+	if w.Code != 404 {
+		t.Fatalf("%d %s", w.Code, w.Body.String())
+	}
+	w = api.request("GET", "/api1/keys", nil, header)
+	if w.Code != 200 {
+		t.Fatal(w.Body.String())
+	}
+
+	w = api.request("DELETE", path, nil, header)
+	if w.Code != 200 {
+		t.Fatal(w.Body.String())
+	}
+	w = api.request("GET", "/api1/keys", nil, header)
+	if w.Code != 403 {
+		t.Fatalf("%d %s", w.Code, w.Body.String())
+	}
+	w = api.request("DELETE", path, nil, a)
+	if w.Code != 404 {
+		t.Fatalf("%d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,181 @@
+package api1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type batchResult struct {
+	Index int // position of the name in the request
+	*CheckResult
+	Domain string
+	Error  string `json:",omitempty"`
+}
+
+// splitNames splits a text body into names, one per line or separated by spaces, commas or semicolons
+func splitNames(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	})
+}
+
+// batchName trims a name of a batch, names without a tld get tld
+func batchName(name string, tld string) string {
+	name = strings.TrimSpace(name)
+	if tld != "" && !strings.Contains(strings.Trim(name, "."), ".") {
+		name = strings.Trim(name, ".") + "." + tld
+	}
+	return name
+}
+
+// batchDomains returns the valid domains of a batch
+func batchDomains(names []string, tld string) []string {
+	var domains []string
+	for _, name := range names {
+		if domain, ok := NormalizeDomain(batchName(name, tld)); ok {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// checkBatch checks the domains with at most Check.Concurrency checks at once for all requests
+// and calls emit with every result as soon as it is ready. Every check is charged to budget,
+// it waits for the rate limit and stops when done is closed.
+func (api *API) checkBatch(domains []string, tld string, budget *checkBudget, done <-chan struct{}, emit func(*batchResult)) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	send := func(result *batchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		emit(result)
+	}
+
+	for i, name := range domains {
+		name = batchName(name, tld)
+		domain, ok := NormalizeDomain(name)
+		if !ok {
+			send(&batchResult{Index: i, Domain: name, Error: "invalid domain"})
+			continue
+		}
+
+		if !budget.take(domain, done) {
+			wg.Wait()
+			return
+		}
+		select {
+		case api.checkSlots <- struct{}{}:
+		case <-done:
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(i int, domain string) {
+			defer wg.Done()
+			defer func() { <-api.checkSlots }()
+			result, err := api.cachedCheck(domain)
+			if err != nil {
+				api.logger.Printf("Error on check of '%s': %s\n", domain, err.Error())
+				send(&batchResult{Index: i, Domain: domain, Error: errCheckFailed})
+				return
+			}
+			send(&batchResult{Index: i, CheckResult: result, Domain: domain})
+		}(i, domain)
+	}
+	wg.Wait()
+}
+
+// batchRoute checks many domains and streams the results as NDJSON or as Server-Sent Events.
+// The body is JSON ({"Domains": [...], "TLD": "com"}) or text with one name per line,
+// names without a tld get the TLD of the request.
+func (api *API) batchRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST request")
+		return
+	}
+	// batches are only available to accounts, every domain that is not cached counts as a check for the rate limit
+	account, ok := api.requestAccount(w, r, ScopeCheck)
+	if !ok {
+		return
+	}
+	if account == nil {
+		api.writeAccessDenied(w)
+		return
+	}
+
+	apiRequest := struct {
+		Domains []string
+		TLD     string
+	}{TLD: r.URL.Query().Get("tld")}
+	if strings.HasPrefix(strings.ToLower(r.Header.Get("Content-Type")), "application/json") {
+		err := json.NewDecoder(r.Body).Decode(&apiRequest)
+		if err != nil {
+			api.writeError(w, "invalid request")
+			return
+		}
+	} else {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			api.writeError(w, "invalid request")
+			return
+		}
+		apiRequest.Domains = splitNames(string(body))
+	}
+	apiRequest.TLD = strings.ToLower(strings.Trim(apiRequest.TLD, ". "))
+
+	if len(apiRequest.Domains) == 0 {
+		api.writeError(w, "no domains")
+		return
+	}
+	if len(apiRequest.Domains) > *api.config.Check.MaxBatch {
+		api.writeError(w, fmt.Sprintf("at most %d domains per request", *api.config.Check.MaxBatch))
+		return
+	}
+	budget, ok := api.allowChecks(w, Client(account, r), batchDomains(apiRequest.Domains, apiRequest.TLD))
+	if !ok {
+		return
+	}
+
+	api.streamBatch(w, r, budget, apiRequest.Domains, apiRequest.TLD)
+}
+
+// streamBatch checks the domains with checkBatch and writes the results as NDJSON,
+// or as Server-Sent Events with format=sse or Accept: text/event-stream
+func (api *API) streamBatch(w http.ResponseWriter, r *http.Request, budget *checkBudget, domains []string, tld string) {
+	sse := r.URL.Query().Get("format") == "sse" || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+	flusher, _ := w.(http.Flusher)
+
+	// no new checks are started once the client went away
+	api.checkBatch(domains, tld, budget, r.Context().Done(), func(result *batchResult) {
+		line, err := json.Marshal(result)
+		if err != nil {
+			return
+		}
+		if sse {
+			fmt.Fprintf(w, "event: result\ndata: %s\n\n", line)
+		} else {
+			w.Write(append(line, '\n'))
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+	if sse {
+		fmt.Fprintf(w, "event: done\ndata: {\"Total\":%d}\n\n", len(domains))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package api1
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Eun/domwatch"
+)
+
+// cacheResult stores a result in the check cache as if the domain was just checked
+func (api *testAPI) cacheResult(domain string, verdict string) {
+	entry := &checkEntry{
+		done:      make(chan struct{}),
+		result:    &domwatch.Result{Verdict: verdict},
+		checkedAt: time.Now().UTC(),
+	}
+	close(entry.done)
+	api.checks.mu.Lock()
+	if api.checks.entries == nil {
+		api.checks.entries = make(map[string]*checkEntry)
+	}
+	api.checks.entries[domain] = entry
+	api.checks.mu.Unlock()
+}
+
+func TestBatchRateLimit(t *testing.T) {
+	// nothing answers on the DNS server, so checks fail right away and are not cached
+	api := newTestAPI(t, map[string]interface{}{
+		"DNSServer": "127.0.0.1",
+		"Check":     map[string]interface{}{"Rate": 600, "Burst": 2},
+	})
+	header := api.login("a@example.com")
+
+	// cached domains are free
+	for _, domain := range []string{"a-example.com", "b-example.com", "c-example.com"} {
+		api.cacheResult(domain, domwatch.VerdictRegistered)
+	}
+	w := api.request("POST", "/api1/check/batch?tld=com", "a-example\nb-example\nc-example", header)
+	if w.Code != 200 || strings.Count(w.Body.String(), "\n") != 3 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	// a batch larger than the burst waits for the rate limit instead of being rejected
+	start := time.Now()
+	w = api.request("POST", "/api1/check/batch?tld=com", "d-example\ne-example\nf-example\ng-example\nh-example", header)
+	if w.Code != 200 || strings.Count(w.Body.String(), "\n") != 5 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatal("the batch did not wait for the rate limit", elapsed)
+	}
+	// the errors of the checker are only logged
+	if strings.Count(w.Body.String(), `"Error":"check failed"`) != 5 {
+		t.Fatal(w.Body.String())
+	}
+
+	// a client without a token gets 429 before the stream starts
+	*api.config.Check.Rate = 1
+	w = api.request("POST", "/api1/check/batch?tld=com", "i-example", header)
+	if w.Code != 429 || w.Header().Get("Retry-After") == "" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	w = api.request("POST", "/api1/check/batch?tld=com", "a-example", header)
+	if w.Code != 200 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	// every uncached domain takes a token once, duplicates and cached domains are free
+	budget, ok := api.allowChecks(httptest.NewRecorder(), "ip:192.0.2.1", []string{"a-example.com", "d-example.com"})
+	if !ok {
+		t.Fatal("the first check was not allowed")
+	}
+	done := make(chan struct{})
+	for _, domain := range []string{"d-example.com", "d-example.com", "a-example.com", "e-example.com"} {
+		if !budget.take(domain, done) {
+			t.Fatal(domain)
+		}
+	}
+	close(done)
+	if budget.take("f-example.com", done) {
+		t.Fatal("the third check did not wait")
+	}
+}
@@ -0,0 +1,277 @@
+package api1
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// verpTag authenticates the recipient of a VERP address, so a forged bounce can not suspend an address
+func (api *API) verpTag(recipient string) string {
+	mac := hmac.New(sha256.New, api.secret)
+	mac.Write([]byte("verp\x00" + strings.ToLower(recipient)))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// verpSender returns the envelope sender for a mail to recipient,
+// bounces@example.com becomes bounces+you=example.net=tag@example.com
+func (api *API) verpSender(recipient string) string {
+	if api.config.Mail.Bounces.Address == nil {
+		return *api.config.Mail.Sender
+	}
+	address := *api.config.Mail.Bounces.Address
+	at := strings.LastIndex(address, "@")
+	return address[:at] + "+" + strings.Replace(recipient, "@", "=", 1) + "=" + api.verpTag(recipient) + address[at:]
+}
+
+// verpRecipient decodes the recipient from a VERP address, addresses without a valid tag yield ""
+func (api *API) verpRecipient(address string) string {
+	if api.config.Mail.Bounces.Address == nil {
+		return ""
+	}
+	bounce := strings.ToLower(*api.config.Mail.Bounces.Address)
+	at := strings.LastIndex(bounce, "@")
+	prefix, suffix := bounce[:at]+"+", bounce[at:]
+	address = strings.ToLower(strings.Trim(strings.TrimSpace(address), "<>"))
+	if !strings.HasPrefix(address, prefix) || !strings.HasSuffix(address, suffix) {
+		return ""
+	}
+	encoded := strings.TrimSuffix(strings.TrimPrefix(address, prefix), suffix)
+	i := strings.LastIndex(encoded, "=")
+	if i < 0 {
+		return ""
+	}
+	encoded, tag := encoded[:i], encoded[i+1:]
+	if i = strings.LastIndex(encoded, "="); i < 0 {
+		return ""
+	}
+	recipient := encoded[:i] + "@" + encoded[i+1:]
+	if !hmac.Equal([]byte(tag), []byte(api.verpTag(recipient))) {
+		return ""
+	}
+	return recipient
+}
+
+type bounceInfo struct {
+	Recipient string
+	Hard      bool
+}
+
+// parseBounce extracts the recipient and the kind of a bounce from a DSN (RFC 3464),
+// it returns nil if the mail is not a bounce for one of our mails
+func (api *API) parseBounce(raw []byte) *bounceInfo {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil
+	}
+
+	var info bounceInfo
+	for _, h := range []string{"Delivered-To", "X-Original-To", "To"} {
+		for _, v := range msg.Header[h] {
+			if info.Recipient = api.verpRecipient(v); info.Recipient != "" {
+				break
+			}
+		}
+		if info.Recipient != "" {
+			break
+		}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" {
+		return nil
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		if !strings.HasPrefix(part.Header.Get("Content-Type"), "message/delivery-status") {
+			continue
+		}
+		status, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil
+		}
+		// the first block describes the message, the following ones the recipients
+		r := textproto.NewReader(bufio.NewReader(bytes.NewReader(status)))
+		for {
+			fields, err := r.ReadMIMEHeader()
+			if len(fields) > 0 {
+				if strings.HasPrefix(strings.TrimSpace(fields.Get("Status")), "5") {
+					info.Hard = true
+				}
+				// without VERP the report names the recipient, with VERP only the signed address counts
+				if info.Recipient == "" && api.config.Mail.Bounces.Address == nil {
+					if final := fields.Get("Final-Recipient"); strings.HasPrefix(strings.ToLower(final), "rfc822;") {
+						info.Recipient = strings.ToLower(strings.TrimSpace(final[7:]))
+					}
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	if info.Recipient == "" {
+		return nil
+	}
+	return &info
+}
+
+// recordBounce counts a hard bounce and suspends the email when the threshold is reached
+func (api *API) recordBounce(info *bounceInfo) error {
+	if !info.Hard {
+		return nil
+	}
+	var email Email
+	db := api.db.Where(&Email{Email: info.Recipient}).First(&email)
+	if db.Error != nil {
+		if db.RecordNotFound() {
+			return nil
+		}
+		return db.Error
+	}
+	now := time.Now().UTC()
+	email.Bounces++
+	email.LastBounce = &now
+	if email.Bounces >= *api.config.Mail.Bounces.Threshold && !email.Suspended {
+		api.logger.Printf("Suspending '%s' after %d bounces\n", email.Email, email.Bounces)
+		email.Suspended = true
+	}
+	return api.db.Save(&email).Error
+}
+
+func (api *API) bounceTask() {
+	api.processBounces()
+	ticker := time.NewTicker(api.config.Mail.Bounces.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-api.closeChan:
+			return
+		case <-ticker.C:
+			api.processBounces()
+		}
+	}
+}
+
+func (api *API) processBounces() {
+	ok, err := api.tryLock("bounces", api.config.leaseDuration)
+	if err != nil {
+		api.logger.Printf("Error on processBounces: %s", err.Error())
+		return
+	}
+	if !ok {
+		return
+	}
+
+	if api.config.Mail.Bounces.Maildir != nil {
+		err = api.processMaildirBounces(*api.config.Mail.Bounces.Maildir)
+	} else if api.config.Mail.Bounces.IMAP.Server != nil {
+		err = api.processIMAPBounces()
+	}
+	if err != nil {
+		api.logger.Printf("Error on processBounces: %s", err.Error())
+	}
+}
+
+// handleBounce records the bounce in raw, it returns false if raw is not a bounce
+func (api *API) handleBounce(raw []byte) (bool, error) {
+	info := api.parseBounce(raw)
+	if info == nil {
+		return false, nil
+	}
+	return true, api.recordBounce(info)
+}
+
+// processMaildirBounces reads the new mails of a maildir and moves them to cur,
+// bounces are marked as seen
+func (api *API) processMaildirBounces(dir string) error {
+	files, err := ioutil.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		src := filepath.Join(dir, "new", f.Name())
+		raw, err := ioutil.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		bounce, err := api.handleBounce(raw)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, "cur", f.Name()+":2,")
+		if bounce {
+			dst += "S"
+		}
+		if err = os.MkdirAll(filepath.Join(dir, "cur"), 0700); err != nil {
+			return err
+		}
+		if err = os.Rename(src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processIMAPBounces reads all mails of the mailbox and deletes the bounces afterwards,
+// other mails are left untouched
+func (api *API) processIMAPBounces() error {
+	config := &api.config.Mail.Bounces.IMAP
+	client, err := dialIMAP(*config.Server, *config.Port, *config.TLS)
+	if err != nil {
+		return err
+	}
+	defer client.logout()
+
+	if err = client.login(*config.Username, *config.Password); err != nil {
+		return err
+	}
+	if err = client.selectMailbox(*config.Mailbox); err != nil {
+		return err
+	}
+	uids, err := client.searchUndeleted()
+	if err != nil {
+		return err
+	}
+	var bounces []string
+	for _, uid := range uids {
+		raw, err := client.fetch(uid)
+		if err != nil {
+			return err
+		}
+		bounce, err := api.handleBounce(raw)
+		if err != nil {
+			return err
+		}
+		if !bounce {
+			continue
+		}
+		if err = client.delete(uid); err != nil {
+			return err
+		}
+		bounces = append(bounces, uid)
+	}
+	if len(bounces) > 0 {
+		return client.expunge(bounces)
+	}
+	return nil
+}
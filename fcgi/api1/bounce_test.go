@@ -0,0 +1,370 @@
+package api1
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newBounceAPI(t *testing.T, imap map[string]interface{}) *testAPI {
+	return newTestAPI(t, map[string]interface{}{
+		"Mail": map[string]interface{}{
+			"Bounces": map[string]interface{}{
+				"Address":   "bounces@example.com",
+				"Threshold": 2,
+				"IMAP":      imap,
+			},
+		},
+	})
+}
+
+// dsn returns a delivery status notification for a mail to recipient
+func (api *testAPI) dsn(recipient string, status string) []byte {
+	return dsnTo(api.verpSender(recipient), recipient, status)
+}
+
+// dsnTo returns a delivery status notification that was sent to the envelope sender to
+func dsnTo(to string, recipient string, status string) []byte {
+	return []byte(strings.Replace(`From: MAILER-DAEMON@mx.example.net
+To: `+to+`
+Subject: Undelivered Mail Returned to Sender
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="b"
+
+--b
+Content-Type: text/plain
+
+The mail could not be delivered.
+
+--b
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.net
+
+Final-Recipient: rfc822; `+recipient+`
+Action: failed
+Status: `+status+`
+
+--b--
+`, "\n", "\r\n", -1))
+}
+
+func (api *testAPI) email(address string) Email {
+	var email Email
+	if err := api.db.Where(&Email{Email: address}).First(&email).Error; err != nil {
+		api.t.Fatal(err)
+	}
+	return email
+}
+
+func TestVERP(t *testing.T) {
+	api := newBounceAPI(t, nil)
+	for _, recipient := range []string{"a@example.net", "first.last+tag@sub.example.org", "a=b@example.net"} {
+		sender := api.verpSender(recipient)
+		if !strings.HasPrefix(sender, "bounces+") || !strings.HasSuffix(sender, "@example.com") {
+			t.Fatal(sender)
+		}
+		if decoded := api.verpRecipient("<" + strings.ToUpper(sender) + ">"); decoded != strings.ToLower(recipient) {
+			t.Fatalf("%s: %s", recipient, decoded)
+		}
+	}
+	tag := api.verpTag("a@example.net")
+	for _, address := range []string{
+		"bounces@example.com",
+		"other+a=example.net=" + tag + "@example.com",
+		"bounces+a=example.net=" + tag + "@example.org",
+		"bounces+nothing@example.com",
+		"bounces+a=example.net@example.com",                  // without a tag
+		"bounces+b=example.net=" + tag + "@example.com",      // the tag of another recipient
+		"bounces+a=example.net=0123456789abcdef@example.com", // a forged tag
+	} {
+		if decoded := api.verpRecipient(address); decoded != "" {
+			t.Fatalf("%s: %s", address, decoded)
+		}
+	}
+}
+
+func TestParseBounce(t *testing.T) {
+	api := newBounceAPI(t, nil)
+	tests := []struct {
+		status string
+		hard   bool
+	}{
+		{"5.1.1", true},
+		{"4.2.2", false},
+	}
+	for _, test := range tests {
+		info := api.parseBounce(api.dsn("a@example.net", test.status))
+		if info == nil || info.Recipient != "a@example.net" || info.Hard != test.hard {
+			t.Fatalf("%s: %+v", test.status, info)
+		}
+	}
+
+	if info := api.parseBounce([]byte("From: a@example.net\r\nSubject: Hello\r\n\r\nHello\r\n")); info != nil {
+		t.Fatalf("%+v", info)
+	}
+
+	// with VERP the recipient that the report names is ignored
+	for _, to := range []string{"bounces@example.com", "bounces+b=example.net@example.com"} {
+		if info := api.parseBounce(dsnTo(to, "a@example.net", "5.1.1")); info != nil {
+			t.Fatalf("%s: %+v", to, info)
+		}
+	}
+	// without VERP it is the only hint
+	api = newTestAPI(t, nil)
+	if info := api.parseBounce(dsnTo("domwatch@example.com", "a@example.net", "5.1.1")); info == nil || info.Recipient != "a@example.net" || !info.Hard {
+		t.Fatalf("%+v", info)
+	}
+}
+
+func TestBounceThreshold(t *testing.T) {
+	api := newBounceAPI(t, nil)
+	api.login("a@example.net")
+
+	bounce := func(status string) {
+		if bounce, err := api.handleBounce(api.dsn("a@example.net", status)); err != nil || !bounce {
+			t.Fatal(bounce, err)
+		}
+	}
+
+	// soft bounces are not counted
+	bounce("4.2.2")
+	if email := api.email("a@example.net"); email.Bounces != 0 || email.Suspended {
+		t.Fatalf("%+v", email)
+	}
+	bounce("5.1.1")
+	if email := api.email("a@example.net"); email.Bounces != 1 || email.Suspended {
+		t.Fatalf("%+v", email)
+	}
+	bounce("5.1.1")
+	if email := api.email("a@example.net"); email.Bounces != 2 || !email.Suspended || email.LastBounce == nil {
+		t.Fatalf("%+v", email)
+	}
+}
+
+func TestMaildirBounces(t *testing.T) {
+	api := newBounceAPI(t, nil)
+	api.login("a@example.net")
+	dir := filepath.Join(api.dir, "bounces")
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(name string, raw []byte) {
+		if err := ioutil.WriteFile(filepath.Join(dir, "new", name), raw, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("1.bounce", api.dsn("a@example.net", "5.1.1"))
+	write("2.mail", []byte("From: a@example.net\r\nSubject: Hello\r\n\r\nHello\r\n"))
+
+	if err := api.processMaildirBounces(dir); err != nil {
+		t.Fatal(err)
+	}
+	if email := api.email("a@example.net"); email.Bounces != 1 {
+		t.Fatalf("%+v", email)
+	}
+	entries, _ := ioutil.ReadDir(filepath.Join(dir, "new"))
+	if len(entries) != 0 {
+		t.Fatal(entries)
+	}
+	for _, name := range []string{"1.bounce:2,S", "2.mail:2,"} {
+		if _, err := os.Stat(filepath.Join(dir, "cur", name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// mails in cur are not read again
+	if err := api.processMaildirBounces(dir); err != nil {
+		t.Fatal(err)
+	}
+	if email := api.email("a@example.net"); email.Bounces != 1 {
+		t.Fatalf("%+v", email)
+	}
+}
+
+// imapServer serves the mails of one mailbox to one client and records the commands
+type imapServer struct {
+	listener     net.Listener
+	capabilities string
+	mails        map[string][]byte
+	deleted      map[string]bool
+	commands     []string
+	done         chan struct{}
+}
+
+func newIMAPServer(t *testing.T, capabilities string, mails map[string][]byte) *imapServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	s := &imapServer{listener: listener, capabilities: capabilities, mails: mails, deleted: make(map[string]bool), done: make(chan struct{})}
+	go s.serve()
+	return s
+}
+
+func (s *imapServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *imapServer) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "* OK IMAP4rev1 ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		tag, command := fields[0], strings.Join(fields[1:], " ")
+		s.commands = append(s.commands, command)
+		switch {
+		case strings.HasPrefix(command, "CAPABILITY"):
+			fmt.Fprintf(conn, "* CAPABILITY IMAP4rev1 %s\r\n", s.capabilities)
+		case strings.HasPrefix(command, "UID SEARCH UNDELETED"):
+			var uids []string
+			for _, uid := range []string{"1", "2", "3"} {
+				if _, ok := s.mails[uid]; ok && !s.deleted[uid] {
+					uids = append(uids, uid)
+				}
+			}
+			fmt.Fprintf(conn, "* SEARCH %s\r\n", strings.Join(uids, " "))
+		case strings.HasPrefix(command, "UID FETCH "):
+			uid := fields[3]
+			fmt.Fprintf(conn, "* %s FETCH (UID %s BODY[] {%d}\r\n%s)\r\n", uid, uid, len(s.mails[uid]), s.mails[uid])
+		case strings.HasPrefix(command, "UID STORE "):
+			s.deleted[fields[3]] = true
+		case strings.HasPrefix(command, "LOGOUT"):
+			fmt.Fprintf(conn, "* BYE\r\n%s OK LOGOUT completed\r\n", tag)
+			return
+		}
+		fmt.Fprintf(conn, "%s OK done\r\n", tag)
+	}
+}
+
+func TestIMAPBounces(t *testing.T) {
+	for _, capabilities := range []string{"UIDPLUS", ""} {
+		// the bounces are signed with the secret of the API, so the mails are added once it exists
+		server := newIMAPServer(t, capabilities, nil)
+		api := newBounceAPI(t, map[string]interface{}{
+			"Server":   "127.0.0.1",
+			"Port":     server.port(),
+			"TLS":      false,
+			"Username": "bounces",
+			"Password": "secret",
+		})
+		api.login("a@example.net")
+		server.mails = map[string][]byte{
+			"1": api.dsn("a@example.net", "5.1.1"),
+			"2": []byte("From: a@example.net\r\nSubject: Hello\r\n\r\nHello\r\n"),
+			"3": api.dsn("a@example.net", "4.2.2"),
+		}
+
+		if err := api.processIMAPBounces(); err != nil {
+			t.Fatal(err)
+		}
+		<-server.done
+		if email := api.email("a@example.net"); email.Bounces != 1 {
+			t.Fatalf("%+v", email)
+		}
+
+		// only the bounces are deleted, the other mail stays in the mailbox
+		if !server.deleted["1"] || server.deleted["2"] || !server.deleted["3"] {
+			t.Fatalf("%s: %v", capabilities, server.deleted)
+		}
+		expunge := "EXPUNGE"
+		if capabilities == "UIDPLUS" {
+			expunge = "UID EXPUNGE 1,3"
+		}
+		var expunged []string
+		for _, command := range server.commands {
+			if strings.Contains(command, "EXPUNGE") {
+				expunged = append(expunged, command)
+			}
+		}
+		if len(expunged) != 1 || expunged[0] != expunge {
+			t.Fatalf("%s: %v", capabilities, server.commands)
+		}
+	}
+}
+
+func TestBounceUnsuspend(t *testing.T) {
+	api := newBounceAPI(t, nil)
+	api.login("a@example.net")
+	for i := 0; i < 2; i++ {
+		if _, err := api.handleBounce(api.dsn("a@example.net", "5.1.1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if email := api.email("a@example.net"); !email.Suspended {
+		t.Fatalf("%+v", email)
+	}
+
+	// notifications are dropped, the confirmation that someone asked for is sent
+	if err := api.sendMail("a@example.net", TemplateAvailable, nil); err != nil {
+		t.Fatal(err)
+	}
+	if mails := api.mails(); len(mails) != 0 {
+		t.Fatal(mails)
+	}
+	w := api.request("POST", "/api1/watch", map[string]interface{}{"Email": "a@example.net", "Domains": []string{"a-example.com"}}, nil)
+	if w.Code != 200 {
+		t.Fatal(w.Body.String())
+	}
+	if err := api.sendConfirmation("a@example.net"); err != nil {
+		t.Fatal(err)
+	}
+	mails := api.mails()
+	if len(mails) != 1 {
+		t.Fatal(mails)
+	}
+	link, err := url.Parse(api.link(mails[0], "http://domwatch.test/api1/confirm?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = api.request("POST", "/api1/confirm", "token="+url.QueryEscape(link.Query().Get("token")), nil)
+	if w.Code != 302 || w.Header().Get("Location") != "/#confirmed" {
+		t.Fatal(w.Code, w.Header())
+	}
+	if email := api.email("a@example.net"); email.Suspended || email.Bounces != 0 {
+		t.Fatalf("%+v", email)
+	}
+
+	// a login link lifts the suspension as well
+	api.db.Model(&Email{}).Where(&Email{Email: "a@example.net"}).Updates(map[string]interface{}{"bounces": 2, "suspended": true})
+	if err = api.sendLoginLink("a@example.net"); err != nil {
+		t.Fatal(err)
+	}
+	mails = api.mails()
+	if len(mails) != 2 {
+		t.Fatal(mails)
+	}
+	var token string
+	for _, text := range mails {
+		if strings.Contains(text, "http://domwatch.test/api1/login?") {
+			link, _ = url.Parse(api.link(text, "http://domwatch.test/api1/login?"))
+			token = link.Query().Get("token")
+		}
+	}
+	w = api.request("POST", "/api1/login", "token="+url.QueryEscape(token), nil)
+	if w.Code != 302 || w.Header().Get("Location") != "/account.html" {
+		t.Fatal(w.Code, w.Header())
+	}
+	if email := api.email("a@example.net"); email.Suspended || email.Bounces != 0 {
+		t.Fatalf("%+v", email)
+	}
+}
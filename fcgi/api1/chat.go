@@ -0,0 +1,137 @@
+package api1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// chatText renders the message that is posted to a chat, markdown is used for emphasis
+func chatText(notification *Notification, bold string) string {
+	var buf bytes.Buffer
+	if notification.Step > 0 {
+		buf.WriteString("Reminder: ")
+	}
+	fmt.Fprintf(&buf, "⚠️ %s%s%s is %s!", bold, notification.Domain, bold, notification.Verdict)
+	for _, e := range notification.Evidence {
+		fmt.Fprintf(&buf, "\n> %s", e)
+	}
+	if notification.Ack != "" {
+		fmt.Fprintf(&buf, "\nAcknowledge: %s", notification.Ack)
+	}
+	return buf.String()
+}
+
+// chatWebhookNotifier posts to incoming webhooks of Slack, Mattermost and Discord
+type chatWebhookNotifier struct {
+	kind   string
+	url    string
+	client *http.Client
+}
+
+func newChatWebhookNotifier(kind string, channel *ChannelConfig) (Notifier, error) {
+	return &chatWebhookNotifier{
+		kind:   kind,
+		url:    *channel.URL,
+		client: &http.Client{Timeout: channel.timeout},
+	}, nil
+}
+
+func (n *chatWebhookNotifier) Notify(notification *Notification) error {
+	var payload interface{}
+	switch n.kind {
+	case "slack":
+		payload = &struct {
+			Text string `json:"text"`
+		}{chatText(notification, "*")}
+	case "mattermost":
+		payload = &struct {
+			Text     string `json:"text"`
+			Username string `json:"username"`
+		}{chatText(notification, "**"), "domwatch"}
+	case "discord":
+		payload = &struct {
+			Content  string `json:"content"`
+			Username string `json:"username"`
+		}{chatText(notification, "**"), "domwatch"}
+	default:
+		return fmt.Errorf("unknown chat '%s'", n.kind)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doWebhookRequest(n.client, req)
+}
+
+// matrixNotifier sends a message into a room using the client-server api
+type matrixNotifier struct {
+	homeserver string
+	room       string
+	token      string
+	client     *http.Client
+}
+
+func newMatrixNotifier(name string, channel *ChannelConfig) (Notifier, error) {
+	if channel.Room == nil || channel.Token == nil {
+		return nil, fmt.Errorf("Channel '%s' needs a Room and a Token", name)
+	}
+	return &matrixNotifier{
+		homeserver: strings.TrimRight(*channel.URL, "/"),
+		room:       *channel.Room,
+		token:      *channel.Token,
+		client:     &http.Client{Timeout: channel.timeout},
+	}, nil
+}
+
+func (n *matrixNotifier) Notify(notification *Notification) error {
+	var formatted bytes.Buffer
+	if notification.Step > 0 {
+		formatted.WriteString("Reminder: ")
+	}
+	fmt.Fprintf(&formatted, "⚠️ <strong>%s</strong> is %s!", html.EscapeString(notification.Domain), html.EscapeString(notification.Verdict))
+	if len(notification.Evidence) > 0 {
+		formatted.WriteString("<blockquote>")
+		for i, e := range notification.Evidence {
+			if i > 0 {
+				formatted.WriteString("<br>")
+			}
+			formatted.WriteString(html.EscapeString(e))
+		}
+		formatted.WriteString("</blockquote>")
+	}
+	if notification.Ack != "" {
+		fmt.Fprintf(&formatted, "<a href=\"%s\">Acknowledge</a>", html.EscapeString(notification.Ack))
+	}
+
+	body, err := json.Marshal(&struct {
+		MsgType       string `json:"msgtype"`
+		Body          string `json:"body"`
+		Format        string `json:"format"`
+		FormattedBody string `json:"formatted_body"`
+	}{"m.text", chatText(notification, ""), "org.matrix.custom.html", formatted.String()})
+	if err != nil {
+		return err
+	}
+
+	// the transaction id is derived from the message, so a retry does not post twice
+	txnID := fmt.Sprintf("domwatch-%d", notification.MessageID)
+	u := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s", n.homeserver, url.PathEscape(n.room), txnID)
+	req, err := http.NewRequest("PUT", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+n.token)
+	return doWebhookRequest(n.client, req)
+}
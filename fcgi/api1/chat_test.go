@@ -0,0 +1,117 @@
+package api1
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// chatServer records the last request of a notifier and answers with status
+type chatServer struct {
+	*httptest.Server
+	status int
+	method string
+	path   string
+	header http.Header
+	body   map[string]interface{}
+}
+
+func newChatServer(t *testing.T) *chatServer {
+	s := &chatServer{status: 200}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.method = r.Method
+		s.path = r.URL.EscapedPath()
+		s.header = r.Header
+		s.body = nil
+		raw, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(raw, &s.body); err != nil {
+			t.Errorf("invalid payload %q: %s", raw, err)
+		}
+		w.WriteHeader(s.status)
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestChatNotifiers(t *testing.T) {
+	slack, mattermost, discord, matrix := newChatServer(t), newChatServer(t), newChatServer(t), newChatServer(t)
+	api := newTestAPI(t, map[string]interface{}{
+		"Channels": map[string]interface{}{
+			"slack":      map[string]interface{}{"Type": "slack", "URL": slack.URL + "/services/T0/B0/X"},
+			"mattermost": map[string]interface{}{"Type": "mattermost", "URL": mattermost.URL + "/hooks/abc"},
+			"discord":    map[string]interface{}{"Type": "discord", "URL": discord.URL + "/api/webhooks/1/abc"},
+			"matrix":     map[string]interface{}{"Type": "matrix", "URL": matrix.URL + "/", "Room": "!room:example.com", "Token": "syt_secret"},
+		},
+	})
+	notification := &Notification{
+		MessageID: 42,
+		Kind:      KindAvailable,
+		Domain:    "example.com",
+		Verdict:   "available",
+		Evidence:  []string{"example.com. NS: no records"},
+		Ack:       "http://domwatch.test/api1/ack?token=a&b",
+	}
+
+	tests := []struct {
+		channel string
+		server  *chatServer
+		method  string
+		path    string
+		auth    string
+		fields  map[string]string // field of the payload and a part of its value
+	}{
+		{"slack", slack, "POST", "/services/T0/B0/X", "", map[string]string{
+			"text": "⚠️ *example.com* is available!\n> example.com. NS: no records\nAcknowledge: http://domwatch.test/api1/ack?token=a&b",
+		}},
+		{"mattermost", mattermost, "POST", "/hooks/abc", "", map[string]string{
+			"text":     "**example.com**",
+			"username": "domwatch",
+		}},
+		{"discord", discord, "POST", "/api/webhooks/1/abc", "", map[string]string{
+			"content":  "**example.com**",
+			"username": "domwatch",
+		}},
+		{"matrix", matrix, "PUT", "/_matrix/client/v3/rooms/%21room:example.com/send/m.room.message/domwatch-42", "Bearer syt_secret", map[string]string{
+			"msgtype":        "m.text",
+			"body":           "example.com is available!",
+			"format":         "org.matrix.custom.html",
+			"formatted_body": `<strong>example.com</strong> is available!<blockquote>example.com. NS: no records</blockquote><a href="http://domwatch.test/api1/ack?token=a&amp;b">`,
+		}},
+	}
+	for _, test := range tests {
+		notifier := api.notifiers[test.channel]
+		if err := notifier.Notify(notification); err != nil {
+			t.Fatalf("%s: %s", test.channel, err)
+		}
+		s := test.server
+		if s.method != test.method || s.path != test.path {
+			t.Fatalf("%s: %s %s", test.channel, s.method, s.path)
+		}
+		if s.header.Get("Content-Type") != "application/json" || s.header.Get("Authorization") != test.auth {
+			t.Fatalf("%s: %v", test.channel, s.header)
+		}
+		if len(s.body) != len(test.fields) {
+			t.Fatalf("%s: %v", test.channel, s.body)
+		}
+		for field, part := range test.fields {
+			value, _ := s.body[field].(string)
+			if !strings.Contains(value, part) {
+				t.Fatalf("%s: %s is %q", test.channel, field, value)
+			}
+		}
+
+		// every response that is not a 2xx is a failure, so the outbox retries the message
+		s.status = 500
+		if err := notifier.Notify(notification); err == nil {
+			t.Fatalf("%s: no error for status 500", test.channel)
+		}
+		s.status = 401
+		if err := notifier.Notify(notification); err == nil {
+			t.Fatalf("%s: no error for status 401", test.channel)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package api1
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Eun/domwatch"
+	"github.com/asaskevich/govalidator"
+	"github.com/miekg/dns"
+)
+
+// the cache is swept when it grows beyond checkCacheSweep entries
+const checkCacheSweep = 1000
+
+type checkEntry struct {
+	done      chan struct{} // closed when the check finished
+	result    *domwatch.Result
+	err       error
+	checkedAt time.Time
+}
+
+// checkCache keeps the results of on-demand checks for Check.CacheTTL,
+// concurrent requests for the same domain share one check
+type checkCache struct {
+	mu      sync.Mutex
+	entries map[string]*checkEntry
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per client, it refills with Check.Rate tokens per minute up to Check.Burst
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type CheckResult struct {
+	*domwatch.Result
+	CheckedAt time.Time
+	Cached    bool
+}
+
+// runCheck checks a domain with the configuration of the daemon
+func (api *API) runCheck(domain string) (*domwatch.Result, error) {
+	return domwatch.CheckDomain(*api.config.DNSServer, domain, "tcp", []uint16{dns.TypeNS, dns.TypeSOA}, api.logger)
+}
+
+// cachedCheck returns the cached result for a domain or checks it,
+// failed checks are not cached
+func (api *API) cachedCheck(domain string) (*CheckResult, error) {
+	cache := &api.checks
+	now := time.Now()
+
+	cache.mu.Lock()
+	if cache.entries == nil {
+		cache.entries = make(map[string]*checkEntry)
+	}
+	entry, ok := cache.entries[domain]
+	if ok {
+		select {
+		case <-entry.done:
+			if now.Sub(entry.checkedAt) > api.config.Check.cacheTTL {
+				ok = false
+			}
+		default:
+		}
+	}
+	if ok {
+		cache.mu.Unlock()
+		<-entry.done
+		if entry.err != nil {
+			return nil, entry.err
+		}
+		return &CheckResult{entry.result, entry.checkedAt, true}, nil
+	}
+
+	if len(cache.entries) >= checkCacheSweep {
+		for d, e := range cache.entries {
+			select {
+			case <-e.done:
+				if now.Sub(e.checkedAt) > api.config.Check.cacheTTL {
+					delete(cache.entries, d)
+				}
+			default:
+			}
+		}
+	}
+	entry = &checkEntry{done: make(chan struct{})}
+	cache.entries[domain] = entry
+	cache.mu.Unlock()
+
+	entry.result, entry.err = api.runCheck(domain)
+	entry.checkedAt = time.Now().UTC()
+	if entry.err != nil {
+		cache.mu.Lock()
+		if cache.entries[domain] == entry {
+			delete(cache.entries, domain)
+		}
+		cache.mu.Unlock()
+	}
+	close(entry.done)
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	return &CheckResult{entry.result, entry.checkedAt, false}, nil
+}
+
+// allow takes n tokens from the bucket of client,
+// if there are not enough it returns how long the client has to wait
+func (api *API) allow(client string, n int) (bool, time.Duration) {
+	limiter := &api.limiter
+	rate := float64(*api.config.Check.Rate) / 60 // tokens per second
+	burst := float64(*api.config.Check.Burst)
+	now := time.Now()
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if limiter.buckets == nil {
+		limiter.buckets = make(map[string]*bucket)
+	}
+	b, ok := limiter.buckets[client]
+	if !ok {
+		// forget the clients with a full bucket
+		for c, other := range limiter.buckets {
+			if other.tokens+now.Sub(other.last).Seconds()*rate >= burst {
+				delete(limiter.buckets, c)
+			}
+		}
+		b = &bucket{tokens: burst, last: now}
+		limiter.buckets[client] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < float64(n) {
+		if float64(n) > burst || rate <= 0 {
+			return false, time.Minute
+		}
+		return false, time.Duration((float64(n) - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens -= float64(n)
+	return true, 0
+}
+
+// cached reports whether a domain has a fresh result in the cache,
+// a domain that is being checked shares that check and counts as cached
+func (api *API) cached(domain string) bool {
+	cache := &api.checks
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	entry, ok := cache.entries[domain]
+	if !ok {
+		return false
+	}
+	select {
+	case <-entry.done:
+		return entry.err == nil && time.Since(entry.checkedAt) <= api.config.Check.cacheTTL
+	default:
+		return true
+	}
+}
+
+// checkBudget charges the checks of one request to the bucket of a client,
+// every domain that is not cached when its check starts takes one token
+type checkBudget struct {
+	api    *API
+	client string
+	mu     sync.Mutex
+	credit int // tokens that were taken before the checks started
+	seen   map[string]bool
+}
+
+// allowChecks returns the budget for the checks of domains.
+// If one of them needs a check the first token is taken right away, the client gets 429 if there is none.
+func (api *API) allowChecks(w http.ResponseWriter, client string, domains []string) (*checkBudget, bool) {
+	budget := &checkBudget{api: api, client: client, seen: make(map[string]bool)}
+	for _, domain := range domains {
+		if api.cached(domain) {
+			continue
+		}
+		if ok, retryAfter := api.allow(client, 1); !ok {
+			api.writeTooManyRequests(w, retryAfter)
+			return nil, false
+		}
+		budget.credit = 1
+		break
+	}
+	return budget, true
+}
+
+// take charges the check of domain unless it is cached or was charged before,
+// while the bucket is empty it waits for the next token. It returns false if done was closed.
+func (b *checkBudget) take(domain string, done <-chan struct{}) bool {
+	b.mu.Lock()
+	if b.seen[domain] || b.api.cached(domain) {
+		b.mu.Unlock()
+		return true
+	}
+	b.seen[domain] = true
+	if b.credit > 0 {
+		b.credit--
+		b.mu.Unlock()
+		return true
+	}
+	b.mu.Unlock()
+
+	for {
+		ok, retryAfter := b.api.allow(b.client, 1)
+		if ok {
+			return true
+		}
+		timer := time.NewTimer(retryAfter)
+		select {
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+			return false
+		}
+	}
+}
+
+// Client identifies a request for the rate limit,
+// by its account if it is authenticated (account is not nil) and by its address otherwise
+func Client(account *Account, r *http.Request) string {
+	if account != nil {
+		return "account:" + strconv.FormatUint(uint64(account.ID), 10)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// checkClient identifies the client of a check request for the rate limit
+func (api *API) checkClient(w http.ResponseWriter, r *http.Request) (string, bool) {
+	account, ok := api.requestAccount(w, r, ScopeCheck)
+	if !ok {
+		return "", false
+	}
+	return Client(account, r), true
+}
+
+// SetRetryAfter tells a client in whole seconds how long it has to wait for the rate limit
+func SetRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)+1))
+}
+
+func (api *API) writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	SetRetryAfter(w, retryAfter)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(429)
+	json.NewEncoder(w).Encode(&struct{ Error string }{"too many requests"})
+}
+
+// NormalizeDomain lower cases a domain and returns false if it is not a valid name with a tld
+func NormalizeDomain(domain string) (string, bool) {
+	domain = strings.ToLower(strings.Trim(strings.TrimSpace(domain), "."))
+	if !govalidator.IsDNSName(domain) || strings.Index(domain, ".") <= 0 {
+		return "", false
+	}
+	return domain, true
+}
+
+// errCheckFailed is the error a client gets for a failed check, the details are only logged
+const errCheckFailed = "check failed"
+
+// checkRoute checks a domain right away without watching it
+func (api *API) checkRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false {
+		api.writeError(w, "Must be a GET request")
+		return
+	}
+	domain, ok := NormalizeDomain(r.URL.Query().Get("domain"))
+	if !ok {
+		api.writeError(w, "invalid domain")
+		return
+	}
+	client, ok := api.checkClient(w, r)
+	if !ok {
+		return
+	}
+	// like in batches only a domain that is not cached costs a token
+	if !api.cached(domain) {
+		if ok, retryAfter := api.allow(client, 1); !ok {
+			api.writeTooManyRequests(w, retryAfter)
+			return
+		}
+	}
+
+	result, err := api.cachedCheck(domain)
+	if err != nil {
+		// the error of the checker can contain the addresses of the resolvers
+		api.logger.Printf("Error on check of '%s': %s\n", domain, err.Error())
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(502)
+		json.NewEncoder(w).Encode(&struct{ Error string }{errCheckFailed})
+		return
+	}
+	api.writeSuccessResponse(w, result)
+}
@@ -0,0 +1,44 @@
+package api1
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Eun/domwatch"
+)
+
+func TestCheckRateLimit(t *testing.T) {
+	// nothing answers on the DNS server, so checks fail right away and are not cached
+	api := newTestAPI(t, map[string]interface{}{
+		"DNSServer": "127.0.0.1",
+		"Check":     map[string]interface{}{"Rate": 1, "Burst": 1},
+	})
+	api.cacheResult("a-example.com", domwatch.VerdictRegistered)
+
+	// cached results do not cost a token
+	for i := 0; i < 3; i++ {
+		w := api.request("GET", "/api1/check?domain=a-example.com", nil, nil)
+		var result CheckResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != 200 || !result.Cached || result.Verdict != domwatch.VerdictRegistered {
+			t.Fatal(w.Code, w.Body.String())
+		}
+	}
+
+	// the error of the checker is only logged
+	w := api.request("GET", "/api1/check?domain=b-example.com", nil, nil)
+	if w.Code != 502 || strings.TrimSpace(w.Body.String()) != `{"Error":"check failed"}` {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	w = api.request("GET", "/api1/check?domain=c-example.com", nil, nil)
+	if w.Code != 429 || w.Header().Get("Retry-After") == "" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	w = api.request("GET", "/api1/check?domain=a-example.com", nil, nil)
+	if w.Code != 200 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
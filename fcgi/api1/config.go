@@ -1,116 +1,546 @@
-package api1
-
-import (
-	"crypto/tls"
-	"errors"
-	"io/ioutil"
-	"net/smtp"
-	"strconv"
-	"strings"
-	"time"
-
-	hjson "github.com/hjson/hjson-go"
-	"github.com/mitchellh/mapstructure"
-)
-
-type MailConfig struct {
-	Sender   *string
-	Server   *string
-	Port     *int
-	Username *string
-	Password *string
-	Auth     *string
-}
-
-type Config struct {
-	Mail             MailConfig
-	mailAuth         smtp.Auth
-	CheckInterval    *string
-	intervalDuration time.Duration
-	DNSServer        *string
-	LogFile          *string
-}
-
-// NewConfigFromMap creates a new config instance from a map
-func NewConfigFromMap(dat map[string]interface{}) (*Config, error) {
-	var config Config
-	err := mapstructure.Decode(dat, &config)
-	if err != nil {
-		return nil, err
-	}
-	err = config.SetDefaults()
-	return &config, err
-}
-
-// NewConfigFromFile returns a Config instance for a file
-func NewConfigFromFile(configFile string) (*Config, error) {
-	bytes, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		return nil, err
-	}
-
-	var dat map[string]interface{}
-	if err := hjson.Unmarshal(bytes, &dat); err != nil {
-		return nil, err
-	}
-
-	return NewConfigFromMap(dat)
-}
-
-func (config *Config) SetDefaults() (err error) {
-	if config.Mail.Server == nil {
-		return errors.New("No Mail server defined")
-	}
-	if config.Mail.Sender == nil {
-		return errors.New("No Mail sender defined")
-	}
-	if config.Mail.Password == nil {
-		config.Mail.Password = new(string)
-	}
-	if config.Mail.Username == nil {
-		config.Mail.Username = new(string)
-	}
-	if config.Mail.Port == nil {
-		config.Mail.Port = new(int)
-		*config.Mail.Port = 25
-	}
-
-	if config.DNSServer == nil {
-		config.DNSServer = new(string)
-		*config.DNSServer = "8.8.8.8"
-	}
-
-	if config.Mail.Auth != nil && strings.ToLower(*config.Mail.Auth) == "cram-md5" {
-		config.mailAuth = smtp.CRAMMD5Auth(*config.Mail.Username, *config.Mail.Password)
-	} else {
-		config.mailAuth = smtp.PlainAuth("", *config.Mail.Username, *config.Mail.Password, *config.Mail.Server)
-	}
-
-	// test auth
-	var client *smtp.Client
-	client, err = smtp.Dial(*config.Mail.Server + ":" + strconv.Itoa(*config.Mail.Port))
-	if err != nil {
-		return err
-	}
-	defer client.Close()
-	client.Hello("localhost")
-
-	if ok, _ := client.Extension("STARTTLS"); ok {
-		config := &tls.Config{ServerName: *config.Mail.Server}
-		if err = client.StartTLS(config); err != nil {
-			return err
-		}
-	}
-	if ok, _ := client.Extension("AUTH"); ok {
-		if err = client.Auth(config.mailAuth); err != nil {
-			return err
-		}
-	}
-
-	if config.CheckInterval == nil {
-		config.intervalDuration, _ = time.ParseDuration("6h")
-	} else {
-		config.intervalDuration, err = time.ParseDuration(*config.CheckInterval)
-	}
-	return err
-}
+package api1
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	hjson "github.com/hjson/hjson-go"
+	"github.com/mitchellh/mapstructure"
+)
+
+// DKIMConfig enables DKIM signing if a KeyFile is set
+type DKIMConfig struct {
+	Domain   *string
+	Selector *string
+	KeyFile  *string
+}
+
+// IMAPConfig is the mailbox that receives the bounces
+type IMAPConfig struct {
+	Server   *string
+	Port     *int
+	Username *string
+	Password *string
+	Mailbox  *string
+	TLS      *bool
+}
+
+// BouncesConfig enables VERP if an Address is set, bounces are read from a Maildir or via IMAP
+type BouncesConfig struct {
+	Address   *string
+	Threshold *int
+	Interval  *string
+	interval  time.Duration
+	Maildir   *string
+	IMAP      IMAPConfig
+}
+
+type MailConfig struct {
+	Transport *string
+	Sender    *string
+	Sendmail  *string
+	Socket    *string
+	Maildir   *string
+	Server    *string
+	Port      *int
+	Username  *string
+	Password  *string
+	Auth      *string
+	TLS       *string
+	Hostname  *string
+	Templates *string
+	Locale    *string
+	DKIM      DKIMConfig
+	Bounces   BouncesConfig
+}
+
+type OutboxConfig struct {
+	MaxAttempts   *int
+	RetryDelay    *string
+	retryDelay    time.Duration
+	MaxRetryDelay *string
+	maxRetryDelay time.Duration
+}
+
+// ChannelConfig configures a notification channel
+type ChannelConfig struct {
+	Type    *string
+	URL     *string
+	Secret  *string
+	Room    *string
+	Token   *string
+	Timeout *string
+	timeout time.Duration
+}
+
+// EscalationStep is sent if an alert was not acknowledged After its start
+type EscalationStep struct {
+	After      *string
+	after      time.Duration
+	Resend     *bool    // send the notification again on the channels of the watch
+	Channels   []string // additional channels
+	Recipients []string // additional email addresses
+}
+
+type EscalationConfig struct {
+	Steps []*EscalationStep
+}
+
+// OIDCConfig enables the single sign-on with an OpenID Connect provider if an Issuer is set
+type OIDCConfig struct {
+	Issuer       *string
+	ClientID     *string
+	ClientSecret *string
+	Name         *string // label of the login button
+	Scopes       []string
+	GroupsClaim  *string  // claim of the ID token that lists the groups of the user
+	AdminGroups  []string // members of these groups are administrators
+}
+
+// CheckConfig limits the on-demand checks
+type CheckConfig struct {
+	CacheTTL    *string
+	cacheTTL    time.Duration
+	Rate        *int // checks per minute and client
+	Burst       *int
+	MaxBatch    *int // domains per batch request
+	Concurrency *int // concurrent checks of all batch requests
+}
+
+// WebPushConfig holds the VAPID keys, use GenerateVAPIDKeys to create them
+type WebPushConfig struct {
+	PrivateKey *string
+	Subject    *string
+	key        *vapidKey
+}
+
+type Config struct {
+	Mail             MailConfig
+	Outbox           OutboxConfig
+	WebPush          WebPushConfig
+	Escalation       EscalationConfig
+	OIDC             OIDCConfig
+	Check            CheckConfig
+	Channels         map[string]*ChannelConfig
+	AdminToken       *string
+	BaseURL          *string
+	Secret           *string
+	ConfirmationTTL  *string
+	confirmationTTL  time.Duration
+	LoginTTL         *string
+	loginTTL         time.Duration
+	SessionTTL       *string
+	sessionTTL       time.Duration
+	CheckInterval    *string
+	intervalDuration time.Duration
+	PollInterval     *string
+	pollDuration     time.Duration
+	LeaseDuration    *string
+	leaseDuration    time.Duration
+	InstanceID       *string
+	DNSServer        *string
+	LogFile          *string
+}
+
+// NewConfigFromMap creates a new config instance from a map
+func NewConfigFromMap(dat map[string]interface{}) (*Config, error) {
+	var config Config
+	err := mapstructure.Decode(dat, &config)
+	if err != nil {
+		return nil, err
+	}
+	err = config.SetDefaults()
+	return &config, err
+}
+
+// NewConfigFromFile returns a Config instance for a file
+func NewConfigFromFile(configFile string) (*Config, error) {
+	bytes, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var dat map[string]interface{}
+	if err := hjson.Unmarshal(bytes, &dat); err != nil {
+		return nil, err
+	}
+
+	return NewConfigFromMap(dat)
+}
+
+func (config *Config) SetDefaults() (err error) {
+	if config.Mail.Transport == nil {
+		config.Mail.Transport = new(string)
+		*config.Mail.Transport = "smtp"
+	} else {
+		*config.Mail.Transport = strings.ToLower(*config.Mail.Transport)
+	}
+	switch *config.Mail.Transport {
+	case "smtp":
+		if config.Mail.Server == nil {
+			return errors.New("No Mail server defined")
+		}
+	case "sendmail":
+		if config.Mail.Sendmail == nil {
+			config.Mail.Sendmail = new(string)
+			*config.Mail.Sendmail = "/usr/sbin/sendmail"
+		}
+	case "lmtp":
+		if config.Mail.Socket == nil {
+			return errors.New("No Mail socket defined")
+		}
+	case "maildir":
+		if config.Mail.Maildir == nil {
+			return errors.New("No Maildir defined")
+		}
+	default:
+		return fmt.Errorf("Unknown Mail.Transport '%s'", *config.Mail.Transport)
+	}
+	if config.Mail.Server == nil {
+		config.Mail.Server = new(string)
+	}
+	if config.Mail.Sender == nil {
+		return errors.New("No Mail sender defined")
+	}
+	if config.Mail.Password == nil {
+		config.Mail.Password = new(string)
+	}
+	if config.Mail.Username == nil {
+		config.Mail.Username = new(string)
+	}
+	if config.Mail.TLS == nil {
+		config.Mail.TLS = new(string)
+		if config.Mail.Port != nil && *config.Mail.Port == 465 {
+			*config.Mail.TLS = TLSImplicit
+		} else {
+			*config.Mail.TLS = TLSStartTLSRequired
+		}
+	} else {
+		*config.Mail.TLS = strings.ToLower(*config.Mail.TLS)
+	}
+	switch *config.Mail.TLS {
+	case TLSNone, TLSStartTLSRequired, TLSImplicit:
+	default:
+		return fmt.Errorf("Unknown Mail.TLS '%s'", *config.Mail.TLS)
+	}
+	if config.Mail.Port == nil {
+		config.Mail.Port = new(int)
+		if *config.Mail.TLS == TLSImplicit {
+			*config.Mail.Port = 465
+		} else {
+			*config.Mail.Port = 25
+		}
+	}
+	if config.Mail.Hostname == nil {
+		config.Mail.Hostname = new(string)
+		*config.Mail.Hostname, err = os.Hostname()
+		if err != nil {
+			*config.Mail.Hostname = "localhost"
+		}
+	}
+	if config.Mail.Auth == nil {
+		config.Mail.Auth = new(string)
+		if *config.Mail.Username == "" {
+			*config.Mail.Auth = "none"
+		} else {
+			*config.Mail.Auth = "plain"
+		}
+	} else {
+		*config.Mail.Auth = strings.ToLower(*config.Mail.Auth)
+	}
+	// LOGIN and XOAUTH2 send the secret as it is, net/smtp refuses PLAIN on such a connection by itself
+	if *config.Mail.Transport == "smtp" && *config.Mail.TLS == TLSNone && (*config.Mail.Auth == "login" || *config.Mail.Auth == "xoauth2") {
+		return fmt.Errorf("Mail.Auth '%s' needs a Mail.TLS other than '%s'", *config.Mail.Auth, TLSNone)
+	}
+
+	if config.Mail.DKIM.KeyFile != nil {
+		if config.Mail.DKIM.Selector == nil {
+			return errors.New("No Mail.DKIM.Selector defined")
+		}
+		if config.Mail.DKIM.Domain == nil {
+			config.Mail.DKIM.Domain = new(string)
+			*config.Mail.DKIM.Domain = (*config.Mail.Sender)[strings.LastIndex(*config.Mail.Sender, "@")+1:]
+		}
+	}
+
+	if config.Mail.Bounces.Address != nil && !strings.Contains(*config.Mail.Bounces.Address, "@") {
+		return fmt.Errorf("Invalid Mail.Bounces.Address '%s'", *config.Mail.Bounces.Address)
+	}
+	if config.Mail.Bounces.Threshold == nil {
+		config.Mail.Bounces.Threshold = new(int)
+		*config.Mail.Bounces.Threshold = 3
+	}
+	if config.Mail.Bounces.Interval == nil {
+		config.Mail.Bounces.interval, _ = time.ParseDuration("10m")
+	} else {
+		config.Mail.Bounces.interval, err = time.ParseDuration(*config.Mail.Bounces.Interval)
+		if err != nil {
+			return err
+		}
+	}
+	if imap := &config.Mail.Bounces.IMAP; imap.Server != nil {
+		if imap.TLS == nil {
+			imap.TLS = new(bool)
+			*imap.TLS = true
+		}
+		if imap.Port == nil {
+			imap.Port = new(int)
+			if *imap.TLS {
+				*imap.Port = 993
+			} else {
+				*imap.Port = 143
+			}
+		}
+		if imap.Username == nil {
+			imap.Username = new(string)
+		}
+		if imap.Password == nil {
+			imap.Password = new(string)
+		}
+		if imap.Mailbox == nil {
+			imap.Mailbox = new(string)
+			*imap.Mailbox = "INBOX"
+		}
+	}
+
+	if config.Mail.Templates == nil {
+		config.Mail.Templates = new(string)
+	}
+	if config.Mail.Locale == nil {
+		config.Mail.Locale = new(string)
+		*config.Mail.Locale = "en"
+	} else {
+		*config.Mail.Locale = strings.ToLower(*config.Mail.Locale)
+	}
+
+	if config.DNSServer == nil {
+		config.DNSServer = new(string)
+		*config.DNSServer = "8.8.8.8"
+	}
+
+	if config.CheckInterval == nil {
+		config.intervalDuration, _ = time.ParseDuration("6h")
+	} else {
+		config.intervalDuration, err = time.ParseDuration(*config.CheckInterval)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.PollInterval == nil {
+		config.pollDuration, _ = time.ParseDuration("1m")
+	} else {
+		config.pollDuration, err = time.ParseDuration(*config.PollInterval)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.LeaseDuration == nil {
+		config.leaseDuration, _ = time.ParseDuration("10m")
+	} else {
+		config.leaseDuration, err = time.ParseDuration(*config.LeaseDuration)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.Check.CacheTTL == nil {
+		config.Check.cacheTTL, _ = time.ParseDuration("10m")
+	} else {
+		config.Check.cacheTTL, err = time.ParseDuration(*config.Check.CacheTTL)
+		if err != nil {
+			return err
+		}
+	}
+	if config.Check.Rate == nil {
+		config.Check.Rate = new(int)
+		*config.Check.Rate = 60
+	}
+	if config.Check.Burst == nil {
+		config.Check.Burst = new(int)
+		*config.Check.Burst = 100
+	} else if *config.Check.Burst < 1 {
+		return errors.New("Check.Burst must be at least 1")
+	}
+	if config.Check.MaxBatch == nil {
+		config.Check.MaxBatch = new(int)
+		*config.Check.MaxBatch = 500
+	}
+	if config.Check.Concurrency == nil {
+		config.Check.Concurrency = new(int)
+		*config.Check.Concurrency = 8
+	} else if *config.Check.Concurrency < 1 {
+		return errors.New("Check.Concurrency must be at least 1")
+	}
+
+	if config.Outbox.MaxAttempts == nil {
+		config.Outbox.MaxAttempts = new(int)
+		*config.Outbox.MaxAttempts = 10
+	}
+
+	if config.Outbox.RetryDelay == nil {
+		config.Outbox.retryDelay, _ = time.ParseDuration("1m")
+	} else {
+		config.Outbox.retryDelay, err = time.ParseDuration(*config.Outbox.RetryDelay)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.Outbox.MaxRetryDelay == nil {
+		config.Outbox.maxRetryDelay, _ = time.ParseDuration("6h")
+	} else {
+		config.Outbox.maxRetryDelay, err = time.ParseDuration(*config.Outbox.MaxRetryDelay)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.WebPush.PrivateKey != nil {
+		config.WebPush.key, err = parseVAPIDKey(*config.WebPush.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("Invalid WebPush.PrivateKey: %s", err.Error())
+		}
+		if config.WebPush.Subject == nil {
+			config.WebPush.Subject = new(string)
+			*config.WebPush.Subject = "mailto:" + *config.Mail.Sender
+		}
+	}
+
+	for name, channel := range config.Channels {
+		err = channel.setDefaults(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	var last time.Duration
+	for i, step := range config.Escalation.Steps {
+		if step.After == nil {
+			return fmt.Errorf("No After defined for escalation step %d", i+1)
+		}
+		step.after, err = time.ParseDuration(*step.After)
+		if err != nil {
+			return err
+		}
+		if step.after <= last {
+			return fmt.Errorf("Escalation step %d must come After step %d", i+1, i)
+		}
+		last = step.after
+		if step.Resend == nil {
+			step.Resend = new(bool)
+		}
+		for _, channel := range step.Channels {
+			if _, ok := config.Channels[channel]; !ok && channel != EmailChannel {
+				return fmt.Errorf("Unknown channel '%s' in escalation step %d", channel, i+1)
+			}
+		}
+		for _, recipient := range step.Recipients {
+			if !strings.Contains(recipient, "@") {
+				return fmt.Errorf("Invalid recipient '%s' in escalation step %d", recipient, i+1)
+			}
+		}
+	}
+
+	if config.BaseURL == nil {
+		config.BaseURL = new(string)
+		*config.BaseURL = "https://" + (*config.Mail.Sender)[strings.LastIndex(*config.Mail.Sender, "@")+1:]
+	}
+
+	if config.OIDC.Issuer != nil {
+		if config.OIDC.ClientID == nil {
+			return errors.New("No OIDC.ClientID defined")
+		}
+		if config.OIDC.ClientSecret == nil {
+			config.OIDC.ClientSecret = new(string)
+		}
+		if config.OIDC.Name == nil {
+			config.OIDC.Name = new(string)
+			*config.OIDC.Name = "Single Sign-On"
+		}
+		if len(config.OIDC.Scopes) == 0 {
+			config.OIDC.Scopes = []string{"openid", "email", "profile"}
+		}
+		openid := false
+		for _, scope := range config.OIDC.Scopes {
+			openid = openid || scope == "openid"
+		}
+		if !openid {
+			return errors.New("OIDC.Scopes must contain openid")
+		}
+		if config.OIDC.GroupsClaim == nil {
+			config.OIDC.GroupsClaim = new(string)
+			*config.OIDC.GroupsClaim = "groups"
+		}
+	}
+
+	if config.ConfirmationTTL == nil {
+		config.confirmationTTL, _ = time.ParseDuration("48h")
+	} else {
+		config.confirmationTTL, err = time.ParseDuration(*config.ConfirmationTTL)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.LoginTTL == nil {
+		config.loginTTL, _ = time.ParseDuration("15m")
+	} else {
+		config.loginTTL, err = time.ParseDuration(*config.LoginTTL)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.SessionTTL == nil {
+		config.sessionTTL, _ = time.ParseDuration("720h")
+	} else {
+		config.sessionTTL, err = time.ParseDuration(*config.SessionTTL)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.InstanceID == nil {
+		config.InstanceID = new(string)
+		*config.InstanceID = defaultInstanceID()
+	}
+	return err
+}
+
+// retryDelay returns the delay before the next delivery attempt,
+// it doubles with every failed attempt
+func (config *Config) retryDelay(attempts int) time.Duration {
+	delay := config.Outbox.retryDelay
+	for i := 1; i < attempts && delay < config.Outbox.maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > config.Outbox.maxRetryDelay {
+		delay = config.Outbox.maxRetryDelay
+	}
+	return delay
+}
+
+func (channel *ChannelConfig) setDefaults(name string) (err error) {
+	if name == EmailChannel || name == WebPushChannel || strings.Contains(name, ",") {
+		return fmt.Errorf("Invalid channel name '%s'", name)
+	}
+	if channel.Type == nil {
+		return fmt.Errorf("No type defined for channel '%s'", name)
+	}
+	*channel.Type = strings.ToLower(*channel.Type)
+	if channel.URL == nil {
+		return fmt.Errorf("No URL defined for channel '%s'", name)
+	}
+	if channel.Timeout == nil {
+		channel.timeout, _ = time.ParseDuration("30s")
+	} else {
+		channel.timeout, err = time.ParseDuration(*channel.Timeout)
+	}
+	return err
+}
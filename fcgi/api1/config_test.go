@@ -0,0 +1,32 @@
+package api1
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Eun/domwatch/fcgi/api1/api1test"
+)
+
+func TestMailAuthNeedsTLS(t *testing.T) {
+	tests := []struct {
+		auth string
+		tls  string
+		ok   bool
+	}{
+		{"login", TLSNone, false},
+		{"XOAUTH2", TLSNone, false},
+		{"login", TLSStartTLSRequired, true},
+		{"xoauth2", TLSImplicit, true},
+		{"plain", TLSNone, true}, // net/smtp only sends it over TLS or to localhost
+		{"none", TLSNone, true},
+	}
+	for _, test := range tests {
+		env := api1test.New(t, map[string]interface{}{
+			"Mail": map[string]interface{}{"Transport": "smtp", "Server": "mail.example.com", "Auth": test.auth, "TLS": test.tls, "Username": "a", "Password": "b"},
+		})
+		_, err := NewConfigFromMap(env.Config)
+		if (err == nil) != test.ok || (err != nil && !strings.Contains(err.Error(), "Mail.TLS")) {
+			t.Fatalf("%s %s: %v", test.auth, test.tls, err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package api1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/jinzhu/gorm"
+)
+
+// resendInterval is the minimum time between two mails of the same kind to the same address
+const resendInterval = 5 * time.Minute
+
+type confirmTemplateData struct {
+	mailContext
+	Domains  []string
+	Browsers int // pending push subscriptions
+	Link     string
+	Expires  string
+}
+
+// enqueueMail queues a mail of kind for an email, unless one was queued recently
+func (api *API) enqueueMail(db *gorm.DB, kind string, email *Email) error {
+	var count int
+	err := db.Model(&Message{}).
+		Where("kind = ? AND recipient = ? AND created_at > ?", kind, email.Email, time.Now().Add(-resendInterval)).
+		Count(&count).Error
+	if err != nil || count > 0 {
+		return err
+	}
+	err = db.Create(&Message{
+		Kind:        kind,
+		Channel:     EmailChannel,
+		Recipient:   email.Email,
+		Status:      MessagePending,
+		NextAttempt: time.Now().UTC().Unix(),
+	}).Error
+	if err != nil {
+		return err
+	}
+	api.wakeDispatcher()
+	return nil
+}
+
+// sendConfirmation sends the mail with the signed confirmation link.
+// The token carries the time of the mail, so it only confirms the watches and subscriptions listed in it.
+func (api *API) sendConfirmation(recipient string) error {
+	var email Email
+	err := api.db.Where(&Email{Email: recipient}).First(&email).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+
+	// rounded up to a second, so the watch that triggered the mail is always included
+	until := time.Unix(time.Now().Unix()+1, 0)
+	domains, err := api.pendingDomains(&email, until)
+	if err != nil {
+		return err
+	}
+	var browsers int
+	err = api.db.Model(&PushSubscription{}).
+		Where("email_id = ? AND pending = ? AND created_at <= ?", email.ID, true, until).
+		Count(&browsers).Error
+	if err != nil || len(domains)+browsers == 0 {
+		return err
+	}
+
+	expires := time.Now().Add(api.config.confirmationTTL)
+	token := api.signToken("confirm", expires, email.Email, strconv.FormatInt(until.Unix(), 10))
+	return api.sendMail(recipient, TemplateConfirm, &confirmTemplateData{
+		mailContext: api.mailContext(),
+		Domains:     domains,
+		Browsers:    browsers,
+		Link:        api.link("/api1/confirm?token=" + url.QueryEscape(token)),
+		Expires:     expires.UTC().Format(time.RFC1123Z),
+	})
+}
+
+// pendingDomains returns the domains of the pending watches of email that were created until then
+func (api *API) pendingDomains(email *Email, until time.Time) ([]string, error) {
+	var domains []string
+	err := api.db.Table("domains").
+		Joins("JOIN watches ON watches.domain_id = domains.id").
+		Where("watches.email_id = ? AND watches.pending = ? AND watches.created_at <= ?", email.ID, true, until).
+		Order("domains.domain").
+		Pluck("domains.domain", &domains).Error
+	return domains, err
+}
+
+// expirePendingWatches removes watches and push subscriptions that were not confirmed in time
+func (api *API) expirePendingWatches() error {
+	expired := time.Now().Add(-api.config.confirmationTTL)
+	err := api.db.Where("pending = ? AND created_at < ?", true, expired).Delete(&Watch{}).Error
+	if err != nil {
+		return err
+	}
+	return api.db.Where("pending = ? AND created_at < ?", true, expired).Delete(&PushSubscription{}).Error
+}
+
+// confirmRoute is the target of the link in the confirmation mail.
+// A GET only shows a confirmation page, so link scanners can not confirm watches.
+func (api *API) confirmRoute(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if strings.EqualFold(r.Method, "GET") {
+		w.Header().Set("Location", "/confirm.html?token="+url.QueryEscape(token))
+		w.WriteHeader(302)
+		return
+	}
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST request")
+		return
+	}
+	if token == "" {
+		token = r.PostFormValue("token")
+	}
+
+	fields, err := api.verifyToken("confirm", token)
+	var until int64
+	if err == nil && len(fields) == 2 {
+		until, err = strconv.ParseInt(fields[1], 10, 64)
+	}
+	if err != nil || len(fields) != 2 {
+		w.Header().Set("Location", "/#invalid_token")
+		w.WriteHeader(302)
+		return
+	}
+
+	var email Email
+	db := api.db.Where(&Email{Email: fields[0]}).First(&email)
+	if db.Error != nil {
+		if db.RecordNotFound() {
+			w.Header().Set("Location", "/#invalid_token")
+			w.WriteHeader(302)
+		} else {
+			api.logError(w, db.Error)
+		}
+		return
+	}
+
+	// watches and subscriptions that were added after the mail was sent need a mail of their own
+	created := time.Unix(until, 0)
+	err = api.db.Model(&Watch{}).
+		Where("email_id = ? AND pending = ? AND created_at <= ?", email.ID, true, created).
+		Updates(map[string]interface{}{"pending": false}).Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	err = api.db.Model(&PushSubscription{}).
+		Where("email_id = ? AND pending = ? AND created_at <= ?", email.ID, true, created).
+		Updates(map[string]interface{}{"pending": false}).Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	// a confirmed address receives mails again and gets its account
+	err = api.db.Model(&email).Updates(map[string]interface{}{"bounces": 0, "suspended": false}).Error
+	if err == nil {
+		_, err = ensureAccount(api.db, &email)
+	}
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/#confirmed")
+	w.WriteHeader(302)
+}
+
+func (api *API) resendRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST request")
+		return
+	}
+
+	apiRequest := struct {
+		Email string
+	}{}
+	redirect := false
+	contentType := r.Header.Get("Content-Type")
+	if strings.EqualFold(contentType, "application/json") {
+		err := json.NewDecoder(r.Body).Decode(&apiRequest)
+		if err != nil {
+			api.writeError(w, "invalid request")
+			return
+		}
+	} else if strings.EqualFold(contentType, "application/x-www-form-urlencoded") {
+		apiRequest.Email = r.FormValue("email")
+		redirect = true
+	} else {
+		api.writeError(w, "invalid request")
+		return
+	}
+
+	apiRequest.Email = strings.ToLower(apiRequest.Email)
+	if !govalidator.IsEmail(apiRequest.Email) {
+		if redirect {
+			w.Header().Set("Location", "/#invalid_email")
+			w.WriteHeader(302)
+		} else {
+			api.writeError(w, "invalid email")
+		}
+		return
+	}
+
+	// the response does not tell whether the address has pending watches
+	var email Email
+	err := api.db.Where(&Email{Email: apiRequest.Email}).First(&email).Error
+	if err == nil {
+		err = api.enqueueMail(api.db, KindConfirm, &email)
+	}
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		api.logError(w, err)
+		return
+	}
+
+	if redirect {
+		w.Header().Set("Location", "/#success")
+		w.WriteHeader(302)
+	} else {
+		api.writeSuccessResponse(w, nil)
+	}
+}
@@ -0,0 +1,79 @@
+package api1
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfirm(t *testing.T) {
+	api := newTestAPI(t, nil)
+	pending := func(domain string) bool {
+		var watch Watch
+		err := api.db.Table("watches").
+			Joins("JOIN domains ON domains.id = watches.domain_id").
+			Where("domains.domain = ?", domain).
+			First(&watch).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		return watch.Pending
+	}
+
+	w := api.request("POST", "/api1/watch", map[string]interface{}{"Email": "a@example.com", "Domains": []string{"a-example.com"}}, nil)
+	if w.Code != 200 || !pending("a-example.com") {
+		t.Fatal(w.Body.String())
+	}
+	// the address gets an account once it proved to be its owner
+	if api.hasAccount("a@example.com") {
+		t.Fatal("an unconfirmed address has an account")
+	}
+	if err := api.sendConfirmation("a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	mails := api.mails()
+	if len(mails) != 1 || !strings.Contains(mails[0], "a-example.com") {
+		t.Fatal(mails)
+	}
+	link, err := url.Parse(api.link(mails[0], "http://domwatch.test/api1/confirm?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := link.Query().Get("token")
+
+	// a watch that someone else adds after the mail was sent is not confirmed by its link,
+	// it is moved past the time of the mail which is rounded up to a second
+	w = api.request("POST", "/api1/watch", map[string]interface{}{"Email": "a@example.com", "Domains": []string{"b-example.com"}}, nil)
+	if w.Code != 200 {
+		t.Fatal(w.Body.String())
+	}
+	var domain Domain
+	api.db.Where(&Domain{Domain: "b-example.com"}).First(&domain)
+	api.db.Model(&Watch{}).Where("domain_id = ?", domain.ID).Update("created_at", time.Now().Add(time.Minute))
+
+	// a GET only shows the confirmation page
+	w = api.request("GET", link.RequestURI(), nil, nil)
+	if w.Code != 302 || w.Header().Get("Location") != "/confirm.html?token="+url.QueryEscape(token) {
+		t.Fatal(w.Code, w.Header())
+	}
+	if !pending("a-example.com") {
+		t.Fatal("GET confirmed the watch")
+	}
+
+	w = api.request("POST", "/api1/confirm", "token="+url.QueryEscape(token), nil)
+	if w.Code != 302 || w.Header().Get("Location") != "/#confirmed" {
+		t.Fatal(w.Code, w.Header())
+	}
+	if pending("a-example.com") || !pending("b-example.com") {
+		t.Fatal("confirmed the wrong watches")
+	}
+	if !api.hasAccount("a@example.com") {
+		t.Fatal("no account")
+	}
+
+	w = api.request("POST", "/api1/confirm", "token=invalid", nil)
+	if w.Code != 302 || w.Header().Get("Location") != "/#invalid_token" {
+		t.Fatal(w.Code, w.Header())
+	}
+}
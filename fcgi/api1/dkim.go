@@ -0,0 +1,160 @@
+package api1
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dkimHeaders are signed if they are present in the mail
+var dkimHeaders = []string{"from", "to", "subject", "date", "message-id", "mime-version", "content-type", "list-unsubscribe", "list-unsubscribe-post"}
+
+var dkimWSP = regexp.MustCompile(`[ \t]+`)
+
+// dkimSigner signs mails with relaxed/relaxed canonicalization (RFC 6376),
+// using rsa-sha256 or ed25519-sha256 (RFC 8463)
+type dkimSigner struct {
+	domain    string
+	selector  string
+	algorithm string
+	key       crypto.Signer
+}
+
+func newDKIMSigner(config *DKIMConfig) (*dkimSigner, error) {
+	raw, err := ioutil.ReadFile(*config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM key", *config.KeyFile)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block '%s'", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer := &dkimSigner{
+		domain:   *config.Domain,
+		selector: *config.Selector,
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signer.algorithm = "rsa-sha256"
+		signer.key = k
+	case ed25519.PrivateKey:
+		signer.algorithm = "ed25519-sha256"
+		signer.key = k
+	default:
+		return nil, errors.New("DKIM key must be a RSA or an Ed25519 key")
+	}
+	return signer, nil
+}
+
+// splitMail returns the unfolded header lines and the body of a mail
+func splitMail(msg []byte) ([]string, []byte, error) {
+	i := bytes.Index(msg, []byte("\r\n\r\n"))
+	if i < 0 {
+		return nil, nil, errors.New("mail has no body")
+	}
+	var headers []string
+	for _, line := range strings.Split(string(msg[:i]), "\r\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(headers) > 0 {
+			headers[len(headers)-1] += "\r\n" + line
+			continue
+		}
+		headers = append(headers, line)
+	}
+	return headers, msg[i+4:], nil
+}
+
+func relaxedHeader(header string) string {
+	parts := strings.SplitN(header, ":", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	value := strings.Replace(parts[1], "\r\n", "", -1)
+	value = strings.TrimSpace(dkimWSP.ReplaceAllString(value, " "))
+	return strings.ToLower(strings.TrimSpace(parts[0])) + ":" + value
+}
+
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(dkimWSP.ReplaceAllString(line, " "), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// Sign returns the mail with a DKIM-Signature header prepended
+func (s *dkimSigner) Sign(msg []byte) ([]byte, error) {
+	headers, body, err := splitMail(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	var signed []string
+	var canonical bytes.Buffer
+	for _, name := range dkimHeaders {
+		// sign the last occurrence, as verifiers pick headers from the bottom
+		for i := len(headers) - 1; i >= 0; i-- {
+			if strings.EqualFold(strings.TrimSpace(strings.SplitN(headers[i], ":", 2)[0]), name) {
+				canonical.WriteString(relaxedHeader(headers[i]) + "\r\n")
+				signed = append(signed, name)
+				break
+			}
+		}
+	}
+
+	value := fmt.Sprintf("v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%s; h=%s; bh=%s; b=",
+		s.algorithm, s.domain, s.selector, strconv.FormatInt(time.Now().Unix(), 10),
+		strings.Join(signed, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+	canonical.WriteString(relaxedHeader("DKIM-Signature: " + value))
+
+	digest := sha256.Sum256(canonical.Bytes())
+	var signature []byte
+	if s.algorithm == "rsa-sha256" {
+		signature, err = s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	} else {
+		// ed25519-sha256 signs the SHA-256 hash with plain Ed25519
+		signature, err = s.key.Sign(rand.Reader, digest[:], crypto.Hash(0))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString("DKIM-Signature: " + value + base64.StdEncoding.EncodeToString(signature) + "\r\n")
+	out.Write(msg)
+	return out.Bytes(), nil
+}
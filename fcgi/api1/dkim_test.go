@@ -0,0 +1,141 @@
+package api1
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// TestDKIMCanonicalization uses the example of RFC 6376, section 3.4.5
+func TestDKIMCanonicalization(t *testing.T) {
+	headers, body, err := splitMail([]byte("A: X\r\nB : Y\t\r\n\tZ  \r\n\r\n C \r\nD \t E\r\n\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var canonical []string
+	for _, header := range headers {
+		canonical = append(canonical, relaxedHeader(header))
+	}
+	if strings.Join(canonical, "\r\n") != "a:X\r\nb:Y Z" {
+		t.Fatalf("%q", canonical)
+	}
+	if string(relaxedBody(body)) != " C\r\nD E\r\n" {
+		t.Fatalf("%q", relaxedBody(body))
+	}
+	// an empty body stays empty
+	if relaxedBody([]byte("\r\n\r\n")) != nil {
+		t.Fatalf("%q", relaxedBody([]byte("\r\n\r\n")))
+	}
+}
+
+// newDKIMKey writes a private key in PEM to a file and returns a signer for it and the TXT record of its public key
+func newDKIMKey(t *testing.T, key interface{}) (*dkimSigner, string) {
+	var block *pem.Block
+	var record string
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+		public, err := x509.MarshalPKIXPublicKey(&k.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record = "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(public)
+	case ed25519.PrivateKey:
+		raw, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: raw}
+		record = "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(k.Public().(ed25519.PublicKey))
+	}
+	file := filepath.Join(t.TempDir(), "dkim.pem")
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	domain, selector := "example.com", "mail"
+	signer, err := newDKIMSigner(&DKIMConfig{Domain: &domain, Selector: &selector, KeyFile: &file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, record
+}
+
+// verifyDKIM checks the signatures of a mail with an independent verifier
+func verifyDKIM(msg []byte, record string) error {
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(msg), &dkim.VerifyOptions{
+		LookupTXT: func(domain string) ([]string, error) {
+			if domain != "mail._domainkey.example.com" {
+				return nil, nil
+			}
+			return []string{record}, nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if len(verifications) != 1 {
+		return fmt.Errorf("%d signatures", len(verifications))
+	}
+	return verifications[0].Err
+}
+
+func TestDKIMSign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &mailMessage{
+		From:    "domwatch@example.com",
+		To:      "a@example.net",
+		Subject: "example.com is available",
+		Headers: []mailHeader{{"List-Unsubscribe", "<http://domwatch.test/api1/unsubscribe?token=a>"}},
+		Text:    "example.com is available!\n",
+		HTML:    "<p>example.com is available!</p>\n",
+	}
+	for _, key := range []interface{}{rsaKey, edKey} {
+		signer, record := newDKIMKey(t, key)
+		signed, err := signer.Sign(msg.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(signed, []byte("a="+signer.algorithm+"; c=relaxed/relaxed; d=example.com; s=mail;")) {
+			t.Fatalf("%s", signed)
+		}
+		if err = verifyDKIM(signed, record); err != nil {
+			t.Fatalf("%s: %s", signer.algorithm, err)
+		}
+
+		// relaxed canonicalization allows changes of whitespace and of the case of header names
+		relaxed := bytes.Replace(signed, []byte("\r\nSubject: "), []byte("\r\nSUBJECT:   "), 1)
+		relaxed = append(relaxed, "\r\n\r\n"...)
+		if err = verifyDKIM(relaxed, record); err != nil {
+			t.Fatalf("%s: %s", signer.algorithm, err)
+		}
+
+		// other changes break the signature
+		for _, tampered := range [][]byte{
+			bytes.Replace(signed, []byte("Subject: example.com"), []byte("Subject: example.org"), 1),
+			bytes.Replace(signed, []byte("is available!"), []byte("is registered"), 1),
+		} {
+			if err = verifyDKIM(tampered, record); err == nil {
+				t.Fatalf("%s: a changed mail verified", signer.algorithm)
+			}
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package api1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ack links have to work as long as an alert can be escalated
+const ackTokenTTL = 30 * 24 * time.Hour
+
+// Alert is the availability notification of a watcher, api2 serves the alerts as events.
+// Every Escalation.Steps entry is sent After the start of the alert unless it was acknowledged before.
+type Alert struct {
+	ID        uint   `gorm:"primary_key;not null"`
+	Domain    string `gorm:"type:char(255);not null"`
+	Recipient string `gorm:"type:char(255);not null"` // email of the watcher
+	Channels  string `gorm:"type:char(255);not null"` // channels of the watch
+	Verdict   string `gorm:"type:char(32)"`
+	Evidence  string `gorm:"type:text"`
+	Start     int64  `gorm:"not null"`                 // when the first notification was scheduled
+	Step      int    `gorm:"not null;default:0"`       // number of escalation steps that were sent
+	NextStep  int64  `gorm:"not null;default:0;index"` // 0 if there is no step left
+	AckedBy   string `gorm:"type:char(255);not null;default:''"`
+	AckedAt   *time.Time
+	CreatedAt time.Time
+}
+
+func (api *API) ackToken(alertID uint, recipient string) string {
+	return api.signToken("ack", time.Now().Add(ackTokenTTL), strconv.FormatUint(uint64(alertID), 10), recipient)
+}
+
+// ackLink returns the link to acknowledge an alert, empty if there is no escalation it could stop
+func (api *API) ackLink(alertID uint, recipient string) string {
+	if alertID == 0 || len(api.config.Escalation.Steps) == 0 {
+		return ""
+	}
+	return api.link("/api1/ack?token=" + url.QueryEscape(api.ackToken(alertID, recipient)))
+}
+
+// createAlert records the notification of a watcher and starts its escalation,
+// start is the time of its first notification
+func (api *API) createAlert(tx *gorm.DB, alert *Alert) error {
+	if steps := api.config.Escalation.Steps; len(steps) > 0 {
+		alert.NextStep = alert.Start + int64(steps[0].after/time.Second)
+	}
+	return tx.Create(alert).Error
+}
+
+func (api *API) escalateAlerts() {
+	now := time.Now().UTC()
+	var alerts []Alert
+	err := api.db.Where("acked_at IS NULL AND next_step > 0 AND next_step <= ?", now.Unix()).Order("next_step").Limit(leaseBatchSize).Find(&alerts).Error
+	if err != nil {
+		api.logger.Printf("Error on escalateAlerts: %s", err.Error())
+		return
+	}
+	for i := range alerts {
+		err = api.escalate(&alerts[i], now)
+		if err != nil {
+			api.logger.Printf("Error on escalateAlerts: %s", err.Error())
+			return
+		}
+	}
+}
+
+// escalate enqueues the next step of an alert,
+// the step counter makes sure that only one instance sends it
+func (api *API) escalate(alert *Alert, now time.Time) error {
+	steps := api.config.Escalation.Steps
+	if alert.Step >= len(steps) {
+		// the steps were removed from the config
+		return api.db.Model(&Alert{}).Where("id = ?", alert.ID).Update("next_step", 0).Error
+	}
+	next := int64(0)
+	if alert.Step+1 < len(steps) {
+		next = alert.Start + int64(steps[alert.Step+1].after/time.Second)
+	}
+
+	tx := api.db.Begin()
+	db := tx.Model(&Alert{}).
+		Where("id = ? AND step = ? AND acked_at IS NULL", alert.ID, alert.Step).
+		Updates(map[string]interface{}{"step": alert.Step + 1, "next_step": next})
+	if db.Error != nil || db.RowsAffected != 1 {
+		tx.Rollback()
+		return db.Error
+	}
+
+	step := steps[alert.Step]
+	message := func(channel string, recipient string, nextAttempt time.Time) error {
+		return tx.Create(&Message{
+			Kind:        KindAvailable,
+			Channel:     channel,
+			Recipient:   recipient,
+			Domain:      alert.Domain,
+			Verdict:     alert.Verdict,
+			Evidence:    alert.Evidence,
+			AlertID:     alert.ID,
+			Step:        alert.Step + 1,
+			Status:      MessagePending,
+			NextAttempt: nextAttempt.Unix(),
+		}).Error
+	}
+
+	var err error
+	if *step.Resend {
+		// the watcher is not disturbed during the quiet hours
+		email := api.lookupEmail(alert.Recipient)
+		for _, channel := range strings.Split(alert.Channels, ",") {
+			if err == nil {
+				err = message(channel, alert.Recipient, nextDelivery(&email, now, false))
+			}
+		}
+	}
+	for _, channel := range step.Channels {
+		if err == nil {
+			err = message(channel, alert.Recipient, now)
+		}
+	}
+	for _, recipient := range step.Recipients {
+		if err == nil {
+			err = message(EmailChannel, recipient, now)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit().Error
+	if err != nil {
+		return err
+	}
+	api.wakeDispatcher()
+	return nil
+}
+
+// acknowledge stops the escalation of an alert and cancels its messages that were not sent yet
+func (api *API) acknowledge(alert *Alert, by string) error {
+	now := time.Now().UTC()
+	tx := api.db.Begin()
+	db := tx.Model(&Alert{}).Where("id = ? AND acked_at IS NULL", alert.ID).
+		Updates(map[string]interface{}{"acked_at": now, "acked_by": by, "next_step": 0})
+	if db.Error != nil || db.RowsAffected != 1 {
+		tx.Rollback()
+		return db.Error
+	}
+	err := tx.Model(&Message{}).
+		Where("alert_id = ? AND status = ? AND lease_until < ?", alert.ID, MessagePending, now.Unix()).
+		Update("status", MessageCancelled).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit().Error
+	if err != nil {
+		return err
+	}
+	alert.AckedAt = &now
+	alert.AckedBy = by
+	return nil
+}
+
+// ackRoute is the target of the ack links.
+// A GET only shows a confirmation page, so link scanners can not acknowledge alerts.
+func (api *API) ackRoute(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if strings.EqualFold(r.Method, "GET") {
+		w.Header().Set("Location", "/ack.html?token="+url.QueryEscape(token))
+		w.WriteHeader(302)
+		return
+	}
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST request")
+		return
+	}
+
+	redirect := false
+	if strings.EqualFold(r.Header.Get("Content-Type"), "application/json") {
+		apiRequest := struct {
+			Token string
+		}{}
+		err := json.NewDecoder(r.Body).Decode(&apiRequest)
+		if err != nil {
+			api.writeError(w, "invalid request")
+			return
+		}
+		if apiRequest.Token != "" {
+			token = apiRequest.Token
+		}
+	} else {
+		if token == "" {
+			token = r.PostFormValue("token")
+		}
+		redirect = true
+	}
+
+	fields, err := api.verifyToken("ack", token)
+	var id uint64
+	if err == nil && len(fields) == 2 {
+		id, err = strconv.ParseUint(fields[0], 10, 32)
+	}
+	if err != nil || len(fields) != 2 {
+		if redirect {
+			w.Header().Set("Location", "/#invalid_token")
+			w.WriteHeader(302)
+		} else {
+			api.writeAccessDenied(w)
+		}
+		return
+	}
+
+	var alert Alert
+	db := api.db.Where(&Alert{ID: uint(id)}).First(&alert)
+	if db.Error != nil {
+		if db.RecordNotFound() {
+			api.writeNotFound(w)
+		} else {
+			api.logError(w, db.Error)
+		}
+		return
+	}
+
+	if alert.AckedAt == nil {
+		err = api.acknowledge(&alert, fields[1])
+		if err == nil && alert.AckedAt == nil {
+			// acknowledged by someone else in the meantime
+			err = api.db.Where(&Alert{ID: alert.ID}).First(&alert).Error
+		}
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+	}
+
+	if redirect {
+		w.Header().Set("Location", "/#acknowledged")
+		w.WriteHeader(302)
+		return
+	}
+	api.writeSuccessResponse(w, &struct {
+		Domain  string
+		AckedBy string
+		AckedAt *time.Time
+	}{alert.Domain, alert.AckedBy, alert.AckedAt})
+}
@@ -0,0 +1,152 @@
+package api1
+
+import (
+	"testing"
+	"time"
+)
+
+func newEscalationAPI(t *testing.T) *testAPI {
+	return newTestAPI(t, map[string]interface{}{
+		"Escalation": map[string]interface{}{"Steps": []interface{}{
+			map[string]interface{}{"After": "10m", "Resend": true},
+			map[string]interface{}{"After": "1h", "Recipients": []string{"oncall@example.com"}},
+		}},
+	})
+}
+
+// alertMessages returns the messages of an alert by step
+func (api *testAPI) alertMessages(alertID uint) map[int][]Message {
+	var messages []Message
+	if err := api.db.Where(&Message{AlertID: alertID}).Order("id").Find(&messages).Error; err != nil {
+		api.t.Fatal(err)
+	}
+	steps := map[int][]Message{}
+	for _, msg := range messages {
+		steps[msg.Step] = append(steps[msg.Step], msg)
+	}
+	return steps
+}
+
+func (api *testAPI) alert(id uint) Alert {
+	var alert Alert
+	if err := api.db.Where(&Alert{ID: id}).First(&alert).Error; err != nil {
+		api.t.Fatal(err)
+	}
+	return alert
+}
+
+func TestEscalationSteps(t *testing.T) {
+	api := newEscalationAPI(t)
+	start := time.Now().UTC().Add(-2 * time.Hour).Unix()
+	alert := Alert{Domain: "example.net", Recipient: "a@example.com", Channels: EmailChannel + ",slack", Start: start}
+	if err := api.createAlert(api.db, &alert); err != nil {
+		t.Fatal(err)
+	}
+	if alert.NextStep != start+600 || alert.Step != 0 {
+		t.Fatalf("%+v", alert)
+	}
+
+	// every run sends the steps that are due, one per alert
+	api.escalateAlerts()
+	if alert := api.alert(alert.ID); alert.Step != 1 || alert.NextStep != start+3600 {
+		t.Fatalf("%+v", alert)
+	}
+	steps := api.alertMessages(alert.ID)
+	if len(steps) != 1 || len(steps[1]) != 2 || steps[1][0].Channel != EmailChannel || steps[1][1].Channel != "slack" || steps[1][0].Recipient != "a@example.com" {
+		t.Fatalf("%+v", steps)
+	}
+
+	api.escalateAlerts()
+	if alert := api.alert(alert.ID); alert.Step != 2 || alert.NextStep != 0 {
+		t.Fatalf("%+v", alert)
+	}
+	steps = api.alertMessages(alert.ID)
+	if len(steps) != 2 || len(steps[2]) != 1 || steps[2][0].Recipient != "oncall@example.com" || steps[2][0].Channel != EmailChannel {
+		t.Fatalf("%+v", steps)
+	}
+
+	// there is no step left
+	api.escalateAlerts()
+	if steps = api.alertMessages(alert.ID); len(steps[1])+len(steps[2]) != 3 {
+		t.Fatalf("%+v", steps)
+	}
+}
+
+func TestEscalationSingleSender(t *testing.T) {
+	api := newEscalationAPI(t)
+	now := time.Now().UTC()
+	alert := Alert{Domain: "example.net", Recipient: "a@example.com", Channels: EmailChannel, Start: now.Add(-15 * time.Minute).Unix()}
+	if err := api.createAlert(api.db, &alert); err != nil {
+		t.Fatal(err)
+	}
+
+	// two instances loaded the same alert, only the first one sends the step
+	stale := alert
+	if err := api.escalate(&alert, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.escalate(&stale, now); err != nil {
+		t.Fatal(err)
+	}
+	if steps := api.alertMessages(alert.ID); len(steps) != 1 || len(steps[1]) != 1 {
+		t.Fatalf("%+v", steps)
+	}
+	if alert := api.alert(alert.ID); alert.Step != 1 {
+		t.Fatalf("%+v", alert)
+	}
+}
+
+func TestEscalationAck(t *testing.T) {
+	api := newEscalationAPI(t)
+	now := time.Now().UTC()
+	alert := Alert{Domain: "example.net", Recipient: "a@example.com", Channels: EmailChannel + ",slack", Start: now.Add(-15 * time.Minute).Unix()}
+	if err := api.createAlert(api.db, &alert); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.escalate(&alert, now); err != nil {
+		t.Fatal(err)
+	}
+	// one of the messages is being delivered
+	messages := api.alertMessages(alert.ID)[1]
+	if err := api.db.Model(&Message{}).Where("id = ?", messages[1].ID).Update("lease_until", now.Add(time.Minute).Unix()).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	token := api.ackToken(alert.ID, "a@example.com")
+	// link scanners only get the confirmation page
+	w := api.request("GET", "/api1/ack?token="+token, nil, nil)
+	if w.Code != 302 || api.alert(alert.ID).AckedAt != nil {
+		t.Fatal(w.Code, w.Header())
+	}
+	w = api.request("POST", "/api1/ack", map[string]interface{}{"Token": token + "x"}, nil)
+	if w.Code != 403 || api.alert(alert.ID).AckedAt != nil {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	w = api.request("POST", "/api1/ack", map[string]interface{}{"Token": token}, nil)
+	if w.Code != 200 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	acked := api.alert(alert.ID)
+	if acked.AckedAt == nil || acked.AckedBy != "a@example.com" || acked.NextStep != 0 {
+		t.Fatalf("%+v", acked)
+	}
+	messages = api.alertMessages(alert.ID)[1]
+	if messages[0].Status != MessageCancelled || messages[1].Status != MessagePending {
+		t.Fatalf("%+v", messages)
+	}
+
+	// an acknowledged alert is not escalated any further
+	if err := api.escalate(&acked, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if steps := api.alertMessages(alert.ID); len(steps) != 1 {
+		t.Fatalf("%+v", steps)
+	}
+
+	// a second ack keeps the first one
+	w = api.request("POST", "/api1/ack", "token="+api.ackToken(alert.ID, "oncall@example.com"), nil)
+	if w.Code != 302 || w.Header().Get("Location") != "/#acknowledged" || api.alert(alert.ID).AckedBy != "a@example.com" {
+		t.Fatal(w.Code, w.Header())
+	}
+}
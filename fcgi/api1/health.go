@@ -0,0 +1,65 @@
+package api1
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the mail server is contacted at most once per healthCacheDuration
+const healthCacheDuration = time.Minute
+
+type healthCache struct {
+	mu      sync.Mutex
+	checked time.Time
+	mail    error
+}
+
+func (api *API) checkMail() error {
+	api.health.mu.Lock()
+	defer api.health.mu.Unlock()
+	if time.Since(api.health.checked) > healthCacheDuration {
+		api.health.mail = api.transport.Check()
+		api.health.checked = time.Now()
+	}
+	return api.health.mail
+}
+
+// healthStatus hides the error, it may contain host names or credentials
+// and the route is public
+func healthStatus(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+func (api *API) healthRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false {
+		api.writeError(w, "Must be a GET request")
+		return
+	}
+
+	dbErr := api.db.DB().Ping()
+	if dbErr != nil {
+		api.logger.Printf("Health check of the database failed: %s\n", dbErr.Error())
+	}
+	mailErr := api.checkMail()
+	if mailErr != nil {
+		api.logger.Printf("Health check of the mail server failed: %s\n", mailErr.Error())
+	}
+	result := struct {
+		Database string
+		Mail     string
+	}{healthStatus(dbErr), healthStatus(mailErr)}
+
+	if dbErr != nil || mailErr != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(503)
+		json.NewEncoder(w).Encode(&result)
+		return
+	}
+	api.writeSuccessResponse(w, &result)
+}
@@ -0,0 +1,22 @@
+package api1
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHealthHidesErrors(t *testing.T) {
+	api := newTestAPI(t, map[string]interface{}{
+		"Mail": map[string]interface{}{"Transport": "smtp", "Server": "127.0.0.1", "Port": 1, "TLS": "none"},
+	})
+	w := api.request("GET", "/api1/health", nil, nil)
+	if w.Code != 503 || strings.TrimSpace(w.Body.String()) != `{"Database":"ok","Mail":"error"}` {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	api.db.DB().Close()
+	w = api.request("GET", "/api1/health", nil, nil)
+	if w.Code != 503 || strings.TrimSpace(w.Body.String()) != `{"Database":"error","Mail":"error"}` {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,181 @@
+package api1
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapClient is a minimal IMAP4rev1 client, it knows just enough to fetch and delete mails
+type imapClient struct {
+	conn         net.Conn
+	r            *bufio.Reader
+	tag          int
+	capabilities []string
+}
+
+func dialIMAP(server string, port int, useTLS bool) (*imapClient, error) {
+	addr := net.JoinHostPort(server, strconv.Itoa(port))
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: server})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Minute))
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn)}
+	// greeting
+	if _, err = c.readLine(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func imapQuote(s string) string {
+	return `"` + strings.Replace(strings.Replace(s, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+}
+
+func (c *imapClient) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// imapResponse is an untagged response, the line keeps the {n} markers of its literals
+type imapResponse struct {
+	line     string
+	literals [][]byte
+}
+
+// cmd sends a command and returns the untagged responses
+func (c *imapClient) cmd(format string, args ...interface{}) ([]imapResponse, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+	if _, err := fmt.Fprintf(c.conn, tag+" "+format+"\r\n", args...); err != nil {
+		return nil, err
+	}
+
+	var untagged []imapResponse
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		response := imapResponse{line: line}
+		// a literal {n} is followed by n bytes
+		for strings.HasSuffix(line, "}") && strings.LastIndex(line, "{") >= 0 {
+			n, err := strconv.Atoi(line[strings.LastIndex(line, "{")+1 : len(line)-1])
+			if err != nil {
+				break
+			}
+			literal := make([]byte, n)
+			if _, err = io.ReadFull(c.r, literal); err != nil {
+				return nil, err
+			}
+			response.literals = append(response.literals, literal)
+			line, err = c.readLine()
+			if err != nil {
+				return nil, err
+			}
+			response.line += line
+		}
+
+		if strings.HasPrefix(line, tag+" ") {
+			status := strings.TrimPrefix(line, tag+" ")
+			if !strings.HasPrefix(status, "OK") {
+				return untagged, fmt.Errorf("imap: %s", status)
+			}
+			return untagged, nil
+		}
+		untagged = append(untagged, response)
+	}
+}
+
+func (c *imapClient) login(username, password string) error {
+	if _, err := c.cmd("LOGIN %s %s", imapQuote(username), imapQuote(password)); err != nil {
+		return err
+	}
+	lines, err := c.cmd("CAPABILITY")
+	if err != nil {
+		return err
+	}
+	for _, l := range lines {
+		if strings.HasPrefix(l.line, "* CAPABILITY ") {
+			c.capabilities = strings.Fields(strings.TrimPrefix(l.line, "* CAPABILITY "))
+		}
+	}
+	return nil
+}
+
+func (c *imapClient) selectMailbox(mailbox string) error {
+	_, err := c.cmd("SELECT %s", imapQuote(mailbox))
+	return err
+}
+
+// capable reports whether the server announced capability, the list is requested after the login
+func (c *imapClient) capable(capability string) bool {
+	for _, name := range c.capabilities {
+		if strings.EqualFold(name, capability) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *imapClient) searchUndeleted() ([]string, error) {
+	lines, err := c.cmd("UID SEARCH UNDELETED")
+	if err != nil {
+		return nil, err
+	}
+	var uids []string
+	for _, l := range lines {
+		if strings.HasPrefix(l.line, "* SEARCH") {
+			uids = append(uids, strings.Fields(strings.TrimPrefix(l.line, "* SEARCH"))...)
+		}
+	}
+	return uids, nil
+}
+
+func (c *imapClient) fetch(uid string) ([]byte, error) {
+	lines, err := c.cmd("UID FETCH %s BODY.PEEK[]", uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range lines {
+		if strings.Contains(l.line, "FETCH") && len(l.literals) > 0 {
+			return l.literals[0], nil
+		}
+	}
+	return nil, fmt.Errorf("imap: no body for uid %s", uid)
+}
+
+func (c *imapClient) delete(uid string) error {
+	_, err := c.cmd("UID STORE %s +FLAGS.SILENT (\\Deleted)", uid)
+	return err
+}
+
+// expunge removes the deleted mails, without UIDPLUS (RFC 4315) a plain EXPUNGE
+// also removes mails that other clients marked as deleted
+func (c *imapClient) expunge(uids []string) error {
+	if c.capable("UIDPLUS") {
+		_, err := c.cmd("UID EXPUNGE %s", strings.Join(uids, ","))
+		return err
+	}
+	_, err := c.cmd("EXPUNGE")
+	return err
+}
+
+func (c *imapClient) logout() error {
+	c.cmd("LOGOUT")
+	return c.conn.Close()
+}
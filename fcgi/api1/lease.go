@@ -0,0 +1,87 @@
+package api1
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// leaseBatchSize is the number of domains an instance claims at once
+const leaseBatchSize = 50
+
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	id, _ := genUUID()
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), id)
+}
+
+// claimDomains leases up to limit domains that are due for a check.
+// A domain is claimed with a conditional update, so only one instance wins
+// even if several instances share the same database.
+func (api *API) claimDomains(limit int) ([]Domain, error) {
+	now := time.Now().UTC().Unix()
+	due := now - int64(api.config.intervalDuration/time.Second)
+	leaseUntil := now + int64(api.config.leaseDuration/time.Second)
+
+	var candidates []Domain
+	err := api.db.Where("last_checked <= ? AND lease_until < ?", due, now).Order("last_checked").Limit(limit).Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []Domain
+	for _, dom := range candidates {
+		db := api.db.Model(&Domain{}).
+			Where("id = ? AND last_checked <= ? AND lease_until < ?", dom.ID, due, now).
+			Updates(map[string]interface{}{"lease_owner": *api.config.InstanceID, "lease_until": leaseUntil})
+		if db.Error != nil {
+			return claimed, db.Error
+		}
+		if db.RowsAffected == 1 {
+			dom.LeaseOwner = *api.config.InstanceID
+			dom.LeaseUntil = leaseUntil
+			claimed = append(claimed, dom)
+		}
+	}
+	return claimed, nil
+}
+
+// releaseDomain stores the check time and the verdict and gives the lease back,
+// the verdict is kept if it is empty
+func (api *API) releaseDomain(dom *Domain, lastChecked int64, verdict string) error {
+	updates := map[string]interface{}{"last_checked": lastChecked, "lease_owner": "", "lease_until": 0}
+	if verdict != "" {
+		updates["verdict"] = verdict
+	}
+	return api.db.Model(&Domain{}).
+		Where("id = ? AND lease_owner = ?", dom.ID, *api.config.InstanceID).
+		Updates(updates).Error
+}
+
+// Lock is a named lock that is shared by all instances
+type Lock struct {
+	Name       string `gorm:"type:char(64);primary_key;not null"`
+	LeaseOwner string `gorm:"type:char(255);not null;default:''"`
+	LeaseUntil int64  `gorm:"not null;default:0"`
+}
+
+// tryLock acquires or extends the named lock for d,
+// it returns false if another instance holds it
+func (api *API) tryLock(name string, d time.Duration) (bool, error) {
+	now := time.Now().UTC().Unix()
+
+	// creating the row fails if another instance was faster, the update below sorts that out
+	var lock Lock
+	api.db.FirstOrCreate(&lock, &Lock{Name: name})
+
+	db := api.db.Model(&Lock{}).
+		Where("name = ? AND (lease_until < ? OR lease_owner = ?)", name, now, *api.config.InstanceID).
+		Updates(map[string]interface{}{"lease_owner": *api.config.InstanceID, "lease_until": now + int64(d/time.Second)})
+	if db.Error != nil {
+		return false, db.Error
+	}
+	return db.RowsAffected == 1, nil
+}
@@ -0,0 +1,158 @@
+package api1
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// mailHeader is a single header line, the order of the headers is kept
+type mailHeader struct {
+	Name  string
+	Value string
+}
+
+// mailMessage is a multipart/alternative mail with a text and a html part
+type mailMessage struct {
+	From    string
+	To      string
+	Subject string
+	Headers []mailHeader
+	Text    string
+	HTML    string
+}
+
+// mailContext is available in every template
+type mailContext struct {
+	Site    string
+	BaseURL string
+}
+
+// renderedMail is the result of rendering a template
+type renderedMail struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+func (api *API) mailContext() mailContext {
+	site := *api.config.BaseURL
+	if u, err := url.Parse(site); err == nil && u.Host != "" {
+		site = u.Host
+	}
+	return mailContext{Site: site, BaseURL: *api.config.BaseURL}
+}
+
+func (api *API) renderMail(name string, locale string, data interface{}) (*renderedMail, error) {
+	t := api.templates.get(name, locale)
+	if t == nil {
+		return nil, fmt.Errorf("unknown template '%s'", name)
+	}
+	var subject, text, html bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return nil, err
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := t.html.Execute(&html, data); err != nil {
+		return nil, err
+	}
+	return &renderedMail{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+func writeQuotedPrintable(buf *bytes.Buffer, s string) {
+	w := quotedprintable.NewWriter(buf)
+	w.Write([]byte(s))
+	w.Close()
+	buf.WriteString("\r\n")
+}
+
+// Bytes renders the message with CRLF line endings
+func (m *mailMessage) Bytes() []byte {
+	boundary, _ := genUUID()
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", m.From)
+	header("To", m.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", time.Now().UTC().Format(time.RFC1123Z))
+	header("Message-ID", "<"+boundary+"@"+m.From[strings.LastIndex(m.From, "@")+1:]+">")
+	header("MIME-Version", "1.0")
+	for _, h := range m.Headers {
+		header(h.Name, h.Value)
+	}
+	header("Content-Type", `multipart/alternative; boundary="`+boundary+`"`)
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{{"text/plain", m.Text}, {"text/html", m.HTML}} {
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		header("Content-Type", part.contentType+"; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		writeQuotedPrintable(&buf, part.body)
+	}
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes()
+}
+
+// lookupEmail returns the stored email record, an unknown address yields an empty record
+func (api *API) lookupEmail(recipient string) Email {
+	var email Email
+	api.db.Where(&Email{Email: recipient}).First(&email)
+	return email
+}
+
+// sendMail renders the template name in the locale of the recipient and sends it.
+// Suspended addresses only get the confirmation and login mails that someone asked for,
+// using one of their links lifts the suspension.
+func (api *API) sendMail(recipient string, name string, data interface{}, headers ...mailHeader) error {
+	email := api.lookupEmail(recipient)
+	if email.Suspended && name != TemplateConfirm && name != TemplateLogin {
+		api.logger.Printf("Not sending '%s' to suspended '%s'\n", name, recipient)
+		return nil
+	}
+	rendered, err := api.renderMail(name, email.Locale, data)
+	if err != nil {
+		return err
+	}
+	msg := &mailMessage{
+		From:    *api.config.Mail.Sender,
+		To:      recipient,
+		Subject: rendered.Subject,
+		Headers: headers,
+		Text:    rendered.Text,
+		HTML:    rendered.HTML,
+	}
+
+	raw := msg.Bytes()
+	if api.dkim != nil {
+		raw, err = api.dkim.Sign(raw)
+		if err != nil {
+			return err
+		}
+	}
+
+	return api.transport.Send(api.verpSender(recipient), []string{recipient}, raw)
+}
+
+// unsubscribeHeaders are the RFC 8058 headers for a mail to recipient
+func (api *API) unsubscribeHeaders(recipient string) []mailHeader {
+	return []mailHeader{
+		{"List-Unsubscribe", "<" + api.unsubscribeLink(recipient, "") + ">"},
+		{"List-Unsubscribe-Post", "List-Unsubscribe=One-Click"},
+	}
+}
@@ -0,0 +1,103 @@
+package api1
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EmailChannel is the name of the built in email channel
+const EmailChannel = "email"
+
+// Notification is the event a Notifier delivers
+type Notification struct {
+	MessageID uint
+	Kind      string
+	Recipient string
+	Domain    string
+	Verdict   string
+	Evidence  []string
+	Step      int    // escalation step, 0 for the first notification
+	Ack       string // link to acknowledge the alert, empty without escalation
+	Time      time.Time
+}
+
+// Notifier delivers notifications over one channel
+type Notifier interface {
+	Notify(n *Notification) error
+}
+
+// newNotifier creates the notifier for a configured channel
+func newNotifier(name string, channel *ChannelConfig) (Notifier, error) {
+	switch *channel.Type {
+	case "webhook":
+		return newWebhookNotifier(channel)
+	case "slack", "mattermost", "discord":
+		return newChatWebhookNotifier(*channel.Type, channel)
+	case "matrix":
+		return newMatrixNotifier(name, channel)
+	case "ntfy":
+		return newNtfyNotifier(channel)
+	case "gotify":
+		return newGotifyNotifier(name, channel)
+	}
+	return nil, fmt.Errorf("Unknown type '%s' for channel '%s'", *channel.Type, name)
+}
+
+func (api *API) setupNotifiers() error {
+	api.notifiers = map[string]Notifier{
+		EmailChannel: &emailNotifier{api: api},
+	}
+	if api.config.WebPush.key != nil {
+		api.notifiers[WebPushChannel] = &webPushNotifier{
+			api:     api,
+			key:     api.config.WebPush.key,
+			subject: *api.config.WebPush.Subject,
+			client:  &http.Client{Timeout: 30 * time.Second},
+		}
+	}
+	for name, channel := range api.config.Channels {
+		notifier, err := newNotifier(name, channel)
+		if err != nil {
+			return err
+		}
+		api.notifiers[name] = notifier
+	}
+	return nil
+}
+
+// parseChannels validates a list of channel names and returns them
+// in the form that is stored in a Watch
+func (api *API) parseChannels(channels []string) (string, error) {
+	if len(channels) == 0 {
+		return EmailChannel, nil
+	}
+	var names []string
+	for _, c := range channels {
+		c = strings.TrimSpace(c)
+		if _, ok := api.notifiers[c]; !ok {
+			return "", fmt.Errorf("unknown channel '%s'", c)
+		}
+		names = append(names, c)
+	}
+	return strings.Join(names, ","), nil
+}
+
+type emailNotifier struct {
+	api *API
+}
+
+func (n *emailNotifier) Notify(notification *Notification) error {
+	switch notification.Kind {
+	case KindConfirm:
+		return n.api.sendConfirmation(notification.Recipient)
+	case KindUnwatch:
+		return n.api.sendUnwatchLinks(notification.Recipient)
+	case KindLogin:
+		return n.api.sendLoginLink(notification.Recipient)
+	case KindInvite:
+		return n.api.sendInvitation(notification.Recipient)
+	}
+	return n.api.notifyUser(notification)
+}
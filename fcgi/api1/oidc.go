@@ -0,0 +1,560 @@
+package api1
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const oidcCookie = "domwatch_oidc"
+
+// the login at the identity provider has to be finished within oidcLoginTTL
+const oidcLoginTTL = 10 * time.Minute
+
+// clock skew that is tolerated when validating ID tokens
+const oidcLeeway = time.Minute
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// oidcProvider discovers the endpoints and the keys of the issuer on first use
+type oidcProvider struct {
+	config    *OIDCConfig
+	client    *http.Client
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey
+	fetched   time.Time
+}
+
+func newOIDCProvider(config *OIDCConfig) *oidcProvider {
+	return &oidcProvider{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *oidcProvider) getJSON(u string, v interface{}) error {
+	resp, err := p.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s returned %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *oidcProvider) discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var discovery oidcDiscovery
+	err := p.getJSON(strings.TrimRight(*p.config.Issuer, "/")+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != *p.config.Issuer {
+		return nil, fmt.Errorf("issuer mismatch: '%s'", discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("incomplete discovery document")
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+}
+
+// key returns the signing key with kid, the key set is fetched again
+// for unknown ids, so keys can be rotated at the provider
+func (p *oidcProvider) key(kid string) (crypto.PublicKey, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.fetched) < time.Minute {
+		return nil, fmt.Errorf("unknown key '%s'", kid)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err = p.getJSON(discovery.JWKSURI, &set)
+	if err != nil {
+		return nil, err
+	}
+	p.fetched = time.Now()
+	p.keys = make(map[string]crypto.PublicKey)
+	for i := range set.Keys {
+		if set.Keys[i].Use != "" && set.Keys[i].Use != "sig" {
+			continue
+		}
+		key, err := set.Keys[i].publicKey()
+		if err != nil {
+			continue
+		}
+		p.keys[set.Keys[i].Kid] = key
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key '%s'", kid)
+}
+
+// audience is a string or a list of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = audience{s}
+		return nil
+	}
+	var list []string
+	err := json.Unmarshal(b, &list)
+	*a = audience(list)
+	return err
+}
+
+type idTokenClaims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	Expiry          float64  `json:"exp"`
+	IssuedAt        float64  `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	Email           string   `json:"email"`
+	EmailVerified   *bool    `json:"email_verified"`
+	groups          []string
+}
+
+// verifyIDToken checks the signature (RS256 or ES256) and the claims of an ID token
+func (p *oidcProvider) verifyIDToken(token string, nonce string) (*idTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id token")
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err = json.Unmarshal(rawHeader, &header)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" {
+			return nil, fmt.Errorf("unexpected algorithm '%s'", header.Alg)
+		}
+		err = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature)
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(signature) != 64 {
+			return nil, fmt.Errorf("unexpected algorithm '%s'", header.Alg)
+		}
+		if !ecdsa.Verify(k, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+			err = errors.New("invalid signature")
+		}
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var claims idTokenClaims
+	err = json.Unmarshal(rawClaims, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != *p.config.Issuer:
+		return nil, errors.New("wrong issuer")
+	case !claims.Audience.contains(*p.config.ClientID):
+		return nil, errors.New("wrong audience")
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != *p.config.ClientID:
+		return nil, errors.New("wrong authorized party")
+	case time.Unix(int64(claims.Expiry), 0).Add(oidcLeeway).Before(now):
+		return nil, errors.New("id token expired")
+	case time.Unix(int64(claims.IssuedAt), 0).Add(-oidcLeeway).After(now):
+		return nil, errors.New("id token issued in the future")
+	case claims.Nonce != nonce:
+		return nil, errors.New("wrong nonce")
+	case claims.Subject == "":
+		return nil, errors.New("no subject")
+	}
+
+	if groupsClaim := *p.config.GroupsClaim; groupsClaim != "" {
+		var all map[string]interface{}
+		if json.Unmarshal(rawClaims, &all) == nil {
+			if list, ok := all[groupsClaim].([]interface{}); ok {
+				for _, g := range list {
+					if s, ok := g.(string); ok {
+						claims.groups = append(claims.groups, s)
+					}
+				}
+			}
+		}
+	}
+	return &claims, nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// exchange redeems the authorization code and returns the ID token
+func (p *oidcProvider) exchange(code string, verifier string, redirectURI string) (string, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {*p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if *p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(*p.config.ClientID), url.QueryEscape(*p.config.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tokens)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != 200 || tokens.Error != "" {
+		return "", fmt.Errorf("token endpoint: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("token endpoint returned no id token")
+	}
+	return tokens.IDToken, nil
+}
+
+func randomString() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+func (api *API) oidcRedirectURI() string {
+	return api.link("/api1/oidc/callback")
+}
+
+// oidcRoute tells the web page whether single sign-on is available
+func (api *API) oidcRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false {
+		api.writeError(w, "Must be a GET request")
+		return
+	}
+	if api.oidc == nil {
+		api.writeNotFound(w)
+		return
+	}
+	api.writeSuccessResponse(w, &struct{ Name string }{*api.config.OIDC.Name})
+}
+
+// oidcLoginRoute redirects to the identity provider using the authorization code flow with PKCE,
+// state, nonce and code verifier are kept in a signed cookie
+func (api *API) oidcLoginRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false {
+		api.writeError(w, "Must be a GET request")
+		return
+	}
+	if api.oidc == nil {
+		api.writeNotFound(w)
+		return
+	}
+	discovery, err := api.oidc.discover()
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		values[i], err = randomString()
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+	challenge := sha256.Sum256([]byte(verifier))
+
+	expires := time.Now().Add(oidcLoginTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    api.signToken("oidc", expires, state, nonce, verifier),
+		Path:     "/api1/oidc",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(*api.config.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {*api.config.OIDC.ClientID},
+		"redirect_uri":          {api.oidcRedirectURI()},
+		"scope":                 {strings.Join(api.config.OIDC.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	w.Header().Set("Location", discovery.AuthorizationEndpoint+separator+query.Encode())
+	w.WriteHeader(302)
+}
+
+// oidcCallbackRoute validates the response of the identity provider and logs the user in,
+// the account is found by the subject or, on the first login, by the email
+func (api *API) oidcCallbackRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false {
+		api.writeError(w, "Must be a GET request")
+		return
+	}
+	if api.oidc == nil {
+		api.writeNotFound(w)
+		return
+	}
+
+	fail := func(reason string) {
+		api.logger.Printf("OIDC login failed: %s\n", reason)
+		w.Header().Set("Location", "/#login_failed")
+		w.WriteHeader(302)
+	}
+
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		fail("no login cookie")
+		return
+	}
+	fields, err := api.verifyToken("oidc", cookie.Value)
+	if err != nil || len(fields) != 3 {
+		fail("invalid login cookie")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Value: "", Path: "/api1/oidc", Expires: time.Unix(0, 0)})
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		fail(e + " " + query.Get("error_description"))
+		return
+	}
+	if query.Get("state") != fields[0] {
+		fail("state mismatch")
+		return
+	}
+
+	idToken, err := api.oidc.exchange(query.Get("code"), fields[2], api.oidcRedirectURI())
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	claims, err := api.oidc.verifyIDToken(idToken, fields[1])
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+
+	account, err := api.oidcAccount(claims)
+	if err != nil {
+		if err == errNoVerifiedEmail || err == errOtherIdentity {
+			fail(err.Error())
+		} else {
+			api.logError(w, err)
+		}
+		return
+	}
+	err = api.createSession(w, account)
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/account.html")
+	w.WriteHeader(302)
+}
+
+var (
+	errNoVerifiedEmail = errors.New("the id token has no verified email")
+	errOtherIdentity   = errors.New("the account belongs to another identity")
+)
+
+// oidcAccount maps the claims to an account and updates its admin role from the groups
+func (api *API) oidcAccount(claims *idTokenClaims) (*Account, error) {
+	subject := *api.config.OIDC.Issuer + " " + claims.Subject
+
+	var account Account
+	err := api.db.Where(&Account{Subject: subject}).First(&account).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return nil, err
+	}
+	if err != nil {
+		email := strings.ToLower(claims.Email)
+		if email == "" || claims.EmailVerified == nil || !*claims.EmailVerified {
+			return nil, errNoVerifiedEmail
+		}
+		var record Email
+		err = api.db.Where(&Email{Email: email}).FirstOrCreate(&record).Error
+		if err != nil {
+			return nil, err
+		}
+		a, err := ensureAccount(api.db, &record)
+		if err != nil {
+			return nil, err
+		}
+		if a.Subject != "" {
+			return nil, errOtherIdentity
+		}
+		account = *a
+	}
+
+	admin := false
+	for _, g := range claims.groups {
+		for _, adminGroup := range api.config.OIDC.AdminGroups {
+			if g == adminGroup {
+				admin = true
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	account.Subject = subject
+	account.Admin = admin
+	account.LastLogin = &now
+	err = api.db.Model(&account).Updates(map[string]interface{}{"subject": subject, "admin": admin, "last_login": &now}).Error
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
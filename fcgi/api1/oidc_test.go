@@ -0,0 +1,268 @@
+package api1
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// oidcServer is an identity provider that issues ID tokens for the client "domwatch"
+type oidcServer struct {
+	*httptest.Server
+	rsaKey   *rsa.PrivateKey
+	ecKey    *ecdsa.PrivateKey
+	useEC    bool
+	requests map[string]url.Values     // authorization requests by code
+	claims   map[string]interface{}    // overrides the default claims, nil removes a claim
+	tamper   func(token string) string // modifies the signed token
+}
+
+func encodeBase64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func newOIDCServer(t *testing.T) *oidcServer {
+	s := &oidcServer{requests: make(map[string]url.Values)}
+	var err error
+	if s.rsaKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatal(err)
+	}
+	if s.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 s.URL,
+			"authorization_endpoint": s.URL + "/auth",
+			"token_endpoint":         s.URL + "/token",
+			"jwks_uri":               s.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encodeBase64(s.rsaKey.N.Bytes()), "e": encodeBase64(big.NewInt(int64(s.rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encodeBase64(s.ecKey.X.Bytes()), "y": encodeBase64(s.ecKey.Y.Bytes())},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		user, password, _ := r.BasicAuth()
+		request, ok := s.requests[r.PostForm.Get("code")]
+		challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || user != "domwatch" || password != "secret" ||
+			encodeBase64(challenge[:]) != request.Get("code_challenge") ||
+			r.PostForm.Get("redirect_uri") != request.Get("redirect_uri") {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := map[string]interface{}{
+			"iss":            s.URL,
+			"sub":            "user1",
+			"aud":            "domwatch",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          request.Get("nonce"),
+			"email":          "SSO@example.com",
+			"email_verified": true,
+			"groups":         []string{"admins"},
+		}
+		for name, value := range s.claims {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		token := s.sign(claims)
+		if s.tamper != nil {
+			token = s.tamper(token)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": token, "access_token": "x", "token_type": "Bearer"})
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *oidcServer) sign(claims map[string]interface{}) string {
+	alg, kid := "RS256", "rsa"
+	if s.useEC {
+		alg, kid = "ES256", "ec"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := encodeBase64(header) + "." + encodeBase64(payload)
+	digest := sha256.Sum256([]byte(input))
+	var signature []byte
+	if s.useEC {
+		r, v, _ := ecdsa.Sign(rand.Reader, s.ecKey, digest[:])
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		v.FillBytes(signature[32:])
+	} else {
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, s.rsaKey, crypto.SHA256, digest[:])
+	}
+	return input + "." + encodeBase64(signature)
+}
+
+// login runs the browser part of the flow and returns the response of the callback
+func (s *oidcServer) login(api *testAPI, callback func(query url.Values)) *httptest.ResponseRecorder {
+	w := api.request("GET", "/api1/oidc/login", nil, nil)
+	if w.Code != 302 {
+		api.t.Fatal(w.Code, w.Body.String())
+	}
+	location, _ := url.Parse(w.Header().Get("Location"))
+	request := location.Query()
+	if request.Get("code_challenge_method") != "S256" || request.Get("scope") != "openid email profile" {
+		api.t.Fatal(location)
+	}
+	header := http.Header{}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == oidcCookie {
+			header.Set("Cookie", cookie.Name+"="+cookie.Value)
+		}
+	}
+	s.requests["code1"] = request
+	query := url.Values{"code": {"code1"}, "state": {request.Get("state")}}
+	if callback != nil {
+		callback(query)
+	}
+	return api.request("GET", "/api1/oidc/callback?"+query.Encode(), nil, header)
+}
+
+func sessionHeader(w *httptest.ResponseRecorder) http.Header {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == sessionCookie && cookie.Value != "" {
+			return http.Header{"Cookie": {cookie.Name + "=" + cookie.Value}}
+		}
+	}
+	return nil
+}
+
+func TestOIDC(t *testing.T) {
+	server := newOIDCServer(t)
+	api := newTestAPI(t, map[string]interface{}{
+		"OIDC": map[string]interface{}{
+			"Issuer":       server.URL,
+			"ClientID":     "domwatch",
+			"ClientSecret": "secret",
+			"Name":         "Example SSO",
+			"AdminGroups":  []string{"admins"},
+		},
+	})
+
+	w := api.request("GET", "/api1/oidc", nil, nil)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "Example SSO") {
+		t.Fatal(w.Body.String())
+	}
+
+	w = server.login(api, nil)
+	if w.Header().Get("Location") != "/account.html" {
+		t.Fatal(w.Header(), w.Body.String())
+	}
+	header := sessionHeader(w)
+	w = api.request("GET", "/api1/account", nil, header)
+	if !strings.Contains(w.Body.String(), `"Email":"sso@example.com","Admin":true`) {
+		t.Fatal(w.Body.String())
+	}
+	if w = api.request("GET", "/api1/outbox", nil, header); w.Code != 200 {
+		t.Fatal(w.Code)
+	}
+
+	// the subject finds the account, a new email is ignored and the admin role follows the groups
+	server.useEC = true
+	server.claims = map[string]interface{}{"email": "other@example.com", "groups": []string{"users"}}
+	header = sessionHeader(server.login(api, nil))
+	w = api.request("GET", "/api1/account", nil, header)
+	if !strings.Contains(w.Body.String(), `"Email":"sso@example.com","Admin":false`) {
+		t.Fatal(w.Body.String())
+	}
+	if w = api.request("GET", "/api1/outbox", nil, header); w.Code != 403 {
+		t.Fatal(w.Code)
+	}
+	server.useEC = false
+
+	failures := map[string]map[string]interface{}{
+		"nonce":          {"nonce": "other"},
+		"audience":       {"aud": "other"},
+		"issuer":         {"iss": "https://sso.example.org"},
+		"expired":        {"exp": time.Now().Add(-time.Hour).Unix()},
+		"unverified":     {"sub": "user2", "email": "user2@example.com", "email_verified": false},
+		"no verified":    {"sub": "user2", "email": "user2@example.com", "email_verified": nil},
+		"verified text":  {"sub": "user2", "email": "user2@example.com", "email_verified": "false"},
+		"no subject":     {"sub": nil},
+		"issued in 2099": {"iat": time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC).Unix()},
+	}
+	for name, claims := range failures {
+		server.claims = claims
+		w = server.login(api, nil)
+		if w.Header().Get("Location") != "/#login_failed" || sessionHeader(w) != nil {
+			t.Fatal(name, w.Header())
+		}
+	}
+	server.claims = nil
+
+	server.tamper = func(token string) string {
+		parts := strings.Split(token, ".")
+		claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		claims = []byte(strings.Replace(string(claims), "user1", "user9", 1))
+		return parts[0] + "." + encodeBase64(claims) + "." + parts[2]
+	}
+	if w = server.login(api, nil); w.Header().Get("Location") != "/#login_failed" {
+		t.Fatal("signature", w.Header())
+	}
+	server.tamper = nil
+
+	if w = server.login(api, func(query url.Values) { query.Set("state", "other") }); w.Header().Get("Location") != "/#login_failed" {
+		t.Fatal("state", w.Header())
+	}
+	if w = api.request("GET", "/api1/oidc/callback?code=code1&state=x", nil, nil); w.Header().Get("Location") != "/#login_failed" {
+		t.Fatal("cookie", w.Header())
+	}
+}
+
+func TestOIDCKeyType(t *testing.T) {
+	server := newOIDCServer(t)
+	api := newTestAPI(t, map[string]interface{}{
+		"OIDC": map[string]interface{}{"Issuer": server.URL, "ClientID": "domwatch"},
+	})
+	token := server.sign(map[string]interface{}{"iss": server.URL, "sub": "user1", "aud": "domwatch", "exp": time.Now().Add(time.Hour).Unix()})
+	if _, err := api.oidc.verifyIDToken(token, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// a key that is neither RSA nor EC never verifies a token
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.oidc.mu.Lock()
+	api.oidc.keys["rsa"] = public
+	api.oidc.mu.Unlock()
+	if _, err = api.oidc.verifyIDToken(token, ""); err == nil || err.Error() != "unsupported key type" {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCDisabled(t *testing.T) {
+	api := newTestAPI(t, nil)
+	if w := api.request("GET", "/api1/oidc", nil, nil); w.Code != 404 {
+		t.Fatal(w.Code)
+	}
+}
@@ -0,0 +1,31 @@
+package api1
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// openAPIDocument describes the routes of api1 for services and generated clients,
+// the client package follows it
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// openAPIRoute serves the OpenAPI 3 document with the server of this daemon
+func (api *API) openAPIRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false {
+		api.writeError(w, "Must be a GET request")
+		return
+	}
+	var document map[string]interface{}
+	err := json.Unmarshal(openAPIDocument, &document)
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	document["servers"] = []map[string]string{{"url": api.link("/api1")}}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	api.writeSuccessResponse(w, document)
+}
@@ -0,0 +1,690 @@
+package api1
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	RoleOwner  = "owner"  // manages everything, deletes the organisation
+	RoleAdmin  = "admin"  // invites and removes members
+	RoleMember = "member" // adds and removes domains
+)
+
+var roleRanks = map[string]int{RoleMember: 1, RoleAdmin: 2, RoleOwner: 3}
+
+// Organisation shares a watch list between accounts
+type Organisation struct {
+	ID        uint   `gorm:"primary_key;not null"`
+	Name      string `gorm:"type:char(64);not null"`
+	CreatedAt time.Time
+}
+
+// Membership links an account to an organisation,
+// Channels and Muted are the notification preferences of the member for the watches of the organisation.
+// An invitation to an address without an account keeps the address in Invitee until its first login.
+type Membership struct {
+	OrganisationID uint   `gorm:"not null;unique_index:idx_membership"`
+	AccountID      uint   `gorm:"not null;index;unique_index:idx_membership"`
+	Invitee        string `gorm:"type:char(255);not null;default:'';unique_index:idx_membership"`
+	Role           string `gorm:"type:char(16);not null;default:'member'"`
+	Channels       string `gorm:"type:char(255);not null;default:'email'"`
+	Muted          bool   `gorm:"not null;default:false"`
+	Pending        bool   `gorm:"not null;default:true"` // the invitation was not accepted yet
+	CreatedAt      time.Time
+}
+
+// OrgWatch is a domain on the watch list of an organisation, every member is notified
+type OrgWatch struct {
+	OrganisationID uint `gorm:"not null;index"`
+	DomainID       uint `gorm:"not null;index"`
+	AddedBy        uint `gorm:"not null;default:0"` // account id
+	CreatedAt      time.Time
+}
+
+type inviteTemplateData struct {
+	mailContext
+	Organisations []string
+	Link          string
+}
+
+type orgInfo struct {
+	ID      uint
+	Name    string
+	Role    string
+	Pending bool
+}
+
+type orgMember struct {
+	AccountID uint
+	Email     string
+	Role      string
+	Channels  []string
+	Muted     bool
+	Pending   bool
+}
+
+type orgWatch struct {
+	Domain    string
+	AddedBy   string
+	CreatedAt time.Time
+}
+
+// orgWatchers returns the watches of the members of all organisations that watch a domain,
+// members that are muted or did not accept the invitation are left out
+func orgWatchers(db *gorm.DB, domainID uint) ([]Watch, error) {
+	rows, err := db.Table("org_watches").
+		Select("emails.id, memberships.channels").
+		Joins("JOIN memberships ON memberships.organisation_id = org_watches.organisation_id").
+		Joins("JOIN accounts ON accounts.id = memberships.account_id").
+		Joins("JOIN emails ON emails.email = accounts.email").
+		Where("org_watches.domain_id = ? AND memberships.pending = ? AND memberships.muted = ?", domainID, false, false).
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var watches []Watch
+	for rows.Next() {
+		watch := Watch{DomainID: domainID}
+		err = rows.Scan(&watch.EmailID, &watch.Channels)
+		if err != nil {
+			return nil, err
+		}
+		watches = append(watches, watch)
+	}
+	return watches, rows.Err()
+}
+
+// bindInvitations turns the invitations to the address of an account into memberships of the account
+func bindInvitations(db *gorm.DB, account *Account) error {
+	return db.Model(&Membership{}).
+		Where("account_id = 0 AND invitee = ?", account.Email).
+		Updates(map[string]interface{}{"account_id": account.ID, "invitee": ""}).Error
+}
+
+// whereMember selects the membership of an account or the invitation of an address without an account
+func whereMember(db *gorm.DB, member *Membership) *gorm.DB {
+	return db.Where("organisation_id = ? AND account_id = ? AND invitee = ?", member.OrganisationID, member.AccountID, member.Invitee)
+}
+
+// sendInvitation tells the recipient about the organisations that invited it
+func (api *API) sendInvitation(recipient string) error {
+	var names []string
+	err := api.db.Table("organisations").
+		Joins("JOIN memberships ON memberships.organisation_id = organisations.id").
+		Joins("LEFT JOIN accounts ON accounts.id = memberships.account_id").
+		Where("(accounts.email = ? OR memberships.invitee = ?) AND memberships.pending = ?", recipient, recipient, true).
+		Order("organisations.name").
+		Pluck("organisations.name", &names).Error
+	if err != nil || len(names) == 0 {
+		return err
+	}
+	return api.sendMail(recipient, TemplateInvite, &inviteTemplateData{
+		mailContext:   api.mailContext(),
+		Organisations: names,
+		Link:          api.link("/account.html"),
+	})
+}
+
+// orgRequest authenticates a request for an organisation,
+// it writes an error response and returns false if the account is not an active member with at least role
+func (api *API) orgRequest(w http.ResponseWriter, r *http.Request, scope string, role string) (*Account, *Organisation, *Membership, bool) {
+	account, ok := api.requestAccount(w, r, scope)
+	if !ok {
+		return nil, nil, nil, false
+	}
+	if account == nil {
+		api.writeAccessDenied(w)
+		return nil, nil, nil, false
+	}
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		api.writeNotFound(w)
+		return nil, nil, nil, false
+	}
+
+	var org Organisation
+	var membership Membership
+	err = api.db.Where(&Organisation{ID: uint(id)}).First(&org).Error
+	if err == nil {
+		err = api.db.Where(&Membership{OrganisationID: org.ID, AccountID: account.ID}).First(&membership).Error
+	}
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			api.writeNotFound(w)
+		} else {
+			api.logError(w, err)
+		}
+		return nil, nil, nil, false
+	}
+	if membership.Pending && role != "" {
+		api.writeNotFound(w)
+		return nil, nil, nil, false
+	}
+	if roleRanks[membership.Role] < roleRanks[role] {
+		api.writeAccessDenied(w)
+		return nil, nil, nil, false
+	}
+	return account, &org, &membership, true
+}
+
+// decodeOrgRequest reads the JSON body of a request
+func (api *API) decodeOrgRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if !strings.EqualFold(r.Header.Get("Content-Type"), "application/json") {
+		api.writeError(w, "invalid request")
+		return false
+	}
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		api.writeError(w, "invalid request")
+		return false
+	}
+	return true
+}
+
+// orgsRoute lists (GET) the organisations of the account and creates (POST) a new one
+func (api *API) orgsRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false && strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a GET or POST request")
+		return
+	}
+	scope := ScopeWatchRead
+	if strings.EqualFold(r.Method, "POST") {
+		scope = ScopeWatchWrite
+	}
+	account, ok := api.requestAccount(w, r, scope)
+	if !ok {
+		return
+	}
+	if account == nil {
+		api.writeAccessDenied(w)
+		return
+	}
+
+	if strings.EqualFold(r.Method, "GET") {
+		rows, err := api.db.Table("organisations").
+			Select("organisations.id, organisations.name, memberships.role, memberships.pending").
+			Joins("JOIN memberships ON memberships.organisation_id = organisations.id").
+			Where("memberships.account_id = ?", account.ID).
+			Order("organisations.name").
+			Rows()
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+		defer rows.Close()
+		orgs := []orgInfo{}
+		for rows.Next() {
+			var org orgInfo
+			err = rows.Scan(&org.ID, &org.Name, &org.Role, &org.Pending)
+			if err != nil {
+				api.logError(w, err)
+				return
+			}
+			orgs = append(orgs, org)
+		}
+		api.writeSuccessResponse(w, orgs)
+		return
+	}
+
+	apiRequest := struct {
+		Name string
+	}{}
+	if !api.decodeOrgRequest(w, r, &apiRequest) {
+		return
+	}
+	apiRequest.Name = strings.TrimSpace(apiRequest.Name)
+	if apiRequest.Name == "" || len(apiRequest.Name) > 64 {
+		api.writeError(w, "invalid name")
+		return
+	}
+
+	org := Organisation{Name: apiRequest.Name}
+	tx := api.db.Begin()
+	err := tx.Create(&org).Error
+	if err == nil {
+		err = tx.Create(&Membership{OrganisationID: org.ID, AccountID: account.ID, Role: RoleOwner, Channels: EmailChannel}).Error
+	}
+	if err == nil {
+		// the creator is a member right away
+		err = tx.Model(&Membership{}).Where(&Membership{OrganisationID: org.ID, AccountID: account.ID}).Update("pending", false).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		api.logError(w, err)
+		return
+	}
+	err = tx.Commit().Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	api.writeSuccessResponse(w, &orgInfo{org.ID, org.Name, RoleOwner, false})
+}
+
+// orgRoute returns (GET) the members and the watch list of an organisation or deletes it (DELETE)
+func (api *API) orgRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "DELETE") {
+		_, org, _, ok := api.orgRequest(w, r, ScopeWatchWrite, RoleOwner)
+		if !ok {
+			return
+		}
+		tx := api.db.Begin()
+		err := tx.Where(&OrgWatch{OrganisationID: org.ID}).Delete(&OrgWatch{}).Error
+		if err == nil {
+			err = tx.Where(&Membership{OrganisationID: org.ID}).Delete(&Membership{}).Error
+		}
+		if err == nil {
+			err = tx.Delete(org).Error
+		}
+		if err != nil {
+			tx.Rollback()
+			api.logError(w, err)
+			return
+		}
+		err = tx.Commit().Error
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+		api.writeSuccessResponse(w, nil)
+		return
+	}
+	if strings.EqualFold(r.Method, "GET") == false {
+		api.writeError(w, "Must be a GET or DELETE request")
+		return
+	}
+
+	_, org, membership, ok := api.orgRequest(w, r, ScopeWatchRead, RoleMember)
+	if !ok {
+		return
+	}
+
+	// invitees without an account have the AccountID 0
+	rows, err := api.db.Table("memberships").
+		Select("memberships.account_id, COALESCE(accounts.email, memberships.invitee) AS email, memberships.role, memberships.channels, memberships.muted, memberships.pending").
+		Joins("LEFT JOIN accounts ON accounts.id = memberships.account_id").
+		Where("memberships.organisation_id = ?", org.ID).
+		Order("email").
+		Rows()
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	defer rows.Close()
+	members := []orgMember{}
+	for rows.Next() {
+		var member orgMember
+		var channels string
+		err = rows.Scan(&member.AccountID, &member.Email, &member.Role, &channels, &member.Muted, &member.Pending)
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+		member.Channels = strings.Split(channels, ",")
+		members = append(members, member)
+	}
+
+	watchRows, err := api.db.Table("org_watches").
+		Select("domains.domain, COALESCE(accounts.email, ''), org_watches.created_at").
+		Joins("JOIN domains ON domains.id = org_watches.domain_id").
+		Joins("LEFT JOIN accounts ON accounts.id = org_watches.added_by").
+		Where("org_watches.organisation_id = ?", org.ID).
+		Order("domains.domain").
+		Rows()
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	defer watchRows.Close()
+	watches := []orgWatch{}
+	for watchRows.Next() {
+		var watch orgWatch
+		err = watchRows.Scan(&watch.Domain, &watch.AddedBy, &watch.CreatedAt)
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+		watches = append(watches, watch)
+	}
+
+	api.writeSuccessResponse(w, &struct {
+		orgInfo
+		Members []orgMember
+		Watches []orgWatch
+	}{orgInfo{org.ID, org.Name, membership.Role, false}, members, watches})
+}
+
+// orgWatchRoute adds domains to the watch list of an organisation, every member can do this
+func (api *API) orgWatchRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST request")
+		return
+	}
+	account, org, _, ok := api.orgRequest(w, r, ScopeWatchWrite, RoleMember)
+	if !ok {
+		return
+	}
+	apiRequest := struct {
+		Domains []string
+	}{}
+	if !api.decodeOrgRequest(w, r, &apiRequest) {
+		return
+	}
+
+	for _, d := range apiRequest.Domains {
+		if !govalidator.IsDNSName(d) {
+			api.writeError(w, "invalid domain")
+			return
+		}
+	}
+	for _, d := range apiRequest.Domains {
+		var domain Domain
+		err := api.db.FirstOrCreate(&domain, &Domain{Domain: strings.ToLower(d)}).Error
+		if err == nil {
+			err = api.db.Where(&OrgWatch{OrganisationID: org.ID, DomainID: domain.ID}).Attrs(&OrgWatch{AddedBy: account.ID}).FirstOrCreate(&OrgWatch{}).Error
+		}
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+	}
+	api.writeSuccessResponse(w, nil)
+}
+
+// orgUnwatchRoute removes domains from the watch list of an organisation, every member can do this
+func (api *API) orgUnwatchRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST request")
+		return
+	}
+	_, org, _, ok := api.orgRequest(w, r, ScopeWatchWrite, RoleMember)
+	if !ok {
+		return
+	}
+	apiRequest := struct {
+		Domains []string
+	}{}
+	if !api.decodeOrgRequest(w, r, &apiRequest) {
+		return
+	}
+
+	for _, d := range apiRequest.Domains {
+		var domain Domain
+		err := api.db.Where(&Domain{Domain: strings.ToLower(d)}).First(&domain).Error
+		if err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				continue
+			}
+			api.logError(w, err)
+			return
+		}
+		err = api.db.Where(&OrgWatch{OrganisationID: org.ID, DomainID: domain.ID}).Delete(&OrgWatch{}).Error
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+	}
+	api.writeSuccessResponse(w, nil)
+}
+
+// countOwners returns the number of active owners of an organisation
+func (api *API) countOwners(orgID uint) (int, error) {
+	var count int
+	err := api.db.Model(&Membership{}).Where("organisation_id = ? AND role = ? AND pending = ?", orgID, RoleOwner, false).Count(&count).Error
+	return count, err
+}
+
+// orgMembersRoute invites a member or changes the role of a member (POST)
+// and revokes the invitation of an address without an account (DELETE),
+// admins can manage members and admins, only owners can manage owners
+func (api *API) orgMembersRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "DELETE") {
+		api.revokeInvitation(w, r)
+		return
+	}
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST or DELETE request")
+		return
+	}
+	_, org, membership, ok := api.orgRequest(w, r, ScopeWatchWrite, RoleAdmin)
+	if !ok {
+		return
+	}
+	apiRequest := struct {
+		Email string
+		Role  string
+	}{}
+	if !api.decodeOrgRequest(w, r, &apiRequest) {
+		return
+	}
+
+	apiRequest.Email = strings.ToLower(apiRequest.Email)
+	if !govalidator.IsEmail(apiRequest.Email) {
+		api.writeError(w, "invalid email")
+		return
+	}
+	if apiRequest.Role == "" {
+		apiRequest.Role = RoleMember
+	}
+	if _, ok := roleRanks[apiRequest.Role]; !ok {
+		api.writeError(w, "invalid role")
+		return
+	}
+	if roleRanks[apiRequest.Role] > roleRanks[membership.Role] {
+		api.writeAccessDenied(w)
+		return
+	}
+
+	var email Email
+	err := api.db.Where(&Email{Email: apiRequest.Email}).Attrs(&Email{Locale: requestLocale(r)}).FirstOrCreate(&email).Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	// an address without an account is bound to its account on the first login
+	invited := Membership{OrganisationID: org.ID, Invitee: email.Email}
+	var account Account
+	db := api.db.Where(&Account{Email: email.Email}).First(&account)
+	if db.Error != nil && !db.RecordNotFound() {
+		api.logError(w, db.Error)
+		return
+	}
+	if !db.RecordNotFound() {
+		invited = Membership{OrganisationID: org.ID, AccountID: account.ID}
+	}
+
+	var member Membership
+	db = whereMember(api.db, &invited).First(&member)
+	if db.Error != nil && !db.RecordNotFound() {
+		api.logError(w, db.Error)
+		return
+	}
+	if db.RecordNotFound() {
+		// the invitation has to be accepted before the member is notified
+		err = api.db.Create(&Membership{OrganisationID: org.ID, AccountID: invited.AccountID, Invitee: invited.Invitee, Role: apiRequest.Role, Channels: EmailChannel, Pending: true}).Error
+		if err == nil {
+			err = api.enqueueMail(api.db, KindInvite, &email)
+		}
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+		api.writeSuccessResponse(w, &struct{ Pending bool }{true})
+		return
+	}
+
+	if roleRanks[member.Role] > roleRanks[membership.Role] {
+		api.writeAccessDenied(w)
+		return
+	}
+	if member.Role == RoleOwner && apiRequest.Role != RoleOwner && !member.Pending {
+		owners, err := api.countOwners(org.ID)
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+		if owners <= 1 {
+			api.writeError(w, "the last owner can not be removed")
+			return
+		}
+	}
+	err = whereMember(api.db.Model(&Membership{}), &invited).Update("role", apiRequest.Role).Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	api.writeSuccessResponse(w, &struct{ Pending bool }{member.Pending})
+}
+
+// revokeInvitation deletes the invitation of an address without an account,
+// members with an account are removed by orgMemberRoute
+func (api *API) revokeInvitation(w http.ResponseWriter, r *http.Request) {
+	_, org, membership, ok := api.orgRequest(w, r, ScopeWatchWrite, RoleAdmin)
+	if !ok {
+		return
+	}
+	apiRequest := struct {
+		Email string
+	}{}
+	if !api.decodeOrgRequest(w, r, &apiRequest) {
+		return
+	}
+
+	invited := Membership{OrganisationID: org.ID, Invitee: strings.ToLower(apiRequest.Email)}
+	var member Membership
+	db := whereMember(api.db, &invited).First(&member)
+	if db.Error != nil {
+		if db.RecordNotFound() {
+			api.writeNotFound(w)
+		} else {
+			api.logError(w, db.Error)
+		}
+		return
+	}
+	if roleRanks[member.Role] > roleRanks[membership.Role] {
+		api.writeAccessDenied(w)
+		return
+	}
+	err := whereMember(api.db, &invited).Delete(&Membership{}).Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	api.writeSuccessResponse(w, nil)
+}
+
+// orgMemberRoute removes a member, every member can leave the organisation
+func (api *API) orgMemberRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "DELETE") == false {
+		api.writeError(w, "Must be a DELETE request")
+		return
+	}
+	// pending members can decline the invitation
+	account, org, membership, ok := api.orgRequest(w, r, ScopeWatchWrite, "")
+	if !ok {
+		return
+	}
+	accountID, err := strconv.ParseUint(mux.Vars(r)["account"], 10, 32)
+	if err != nil {
+		api.writeNotFound(w)
+		return
+	}
+
+	var member Membership
+	db := api.db.Where(&Membership{OrganisationID: org.ID, AccountID: uint(accountID)}).First(&member)
+	if db.Error != nil {
+		if db.RecordNotFound() {
+			api.writeNotFound(w)
+		} else {
+			api.logError(w, db.Error)
+		}
+		return
+	}
+	if member.AccountID != account.ID &&
+		(membership.Pending || roleRanks[membership.Role] < roleRanks[RoleAdmin] || roleRanks[member.Role] > roleRanks[membership.Role]) {
+		api.writeAccessDenied(w)
+		return
+	}
+	if member.Role == RoleOwner && !member.Pending {
+		owners, err := api.countOwners(org.ID)
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+		if owners <= 1 {
+			api.writeError(w, "the last owner can not be removed")
+			return
+		}
+	}
+
+	err = api.db.Where(&Membership{OrganisationID: org.ID, AccountID: member.AccountID}).Delete(&Membership{}).Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	api.writeSuccessResponse(w, nil)
+}
+
+// orgAcceptRoute accepts the invitation to an organisation
+func (api *API) orgAcceptRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST request")
+		return
+	}
+	account, org, _, ok := api.orgRequest(w, r, ScopeWatchWrite, "")
+	if !ok {
+		return
+	}
+	err := api.db.Model(&Membership{}).Where(&Membership{OrganisationID: org.ID, AccountID: account.ID}).Update("pending", false).Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	api.writeSuccessResponse(w, nil)
+}
+
+// orgPreferencesRoute sets how the member is notified about the watches of the organisation
+func (api *API) orgPreferencesRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST request")
+		return
+	}
+	account, org, _, ok := api.orgRequest(w, r, ScopeWatchWrite, RoleMember)
+	if !ok {
+		return
+	}
+	apiRequest := struct {
+		Channels []string
+		Muted    bool
+	}{}
+	if !api.decodeOrgRequest(w, r, &apiRequest) {
+		return
+	}
+	updates := map[string]interface{}{"muted": apiRequest.Muted}
+	// the channels are kept if they are not part of the request
+	if apiRequest.Channels != nil {
+		channels, err := api.parseChannels(apiRequest.Channels)
+		if err != nil {
+			api.writeError(w, err.Error())
+			return
+		}
+		updates["channels"] = channels
+	}
+
+	err := api.db.Model(&Membership{}).Where(&Membership{OrganisationID: org.ID, AccountID: account.ID}).Updates(updates).Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	api.writeSuccessResponse(w, nil)
+}
@@ -0,0 +1,246 @@
+package api1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// accountID returns the id of the account of an email
+func (api *testAPI) accountID(email string) uint {
+	var account Account
+	if err := api.db.Where(&Account{Email: email}).First(&account).Error; err != nil {
+		api.t.Fatal(err)
+	}
+	return account.ID
+}
+
+func TestOrgRoles(t *testing.T) {
+	api := newTestAPI(t, nil)
+	owner := api.login("owner@example.com")
+	admin := api.login("admin@example.com")
+	member := api.login("member@example.com")
+
+	w := api.request("POST", "/api1/orgs", map[string]interface{}{"Name": "Example"}, owner)
+	if w.Code != 200 {
+		t.Fatal(w.Body.String())
+	}
+	var org orgInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &org); err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/api1/orgs/%d", org.ID)
+	memberPath := func(email string) string {
+		return fmt.Sprintf("%s/members/%d", path, api.accountID(email))
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		header http.Header
+		code   int
+	}{
+		{"invite admin", "POST", path + "/members", map[string]interface{}{"Email": "admin@example.com", "Role": RoleAdmin}, nil, 200},
+		{"invite member", "POST", path + "/members", map[string]interface{}{"Email": "member@example.com"}, nil, 200},
+
+		// pending members only see their invitation and can accept or decline it
+		{"pending read", "GET", path, nil, admin, 404},
+		{"pending invite", "POST", path + "/members", map[string]interface{}{"Email": "c@example.com"}, admin, 404},
+		{"pending watch", "POST", path + "/watch", map[string]interface{}{"Domains": []string{"example.net"}}, admin, 404},
+		{"pending preferences", "POST", path + "/preferences", map[string]interface{}{"Muted": true}, admin, 404},
+		{"pending remove other", "DELETE", memberPath("member@example.com"), nil, admin, 403},
+		{"pending remove owner", "DELETE", memberPath("owner@example.com"), nil, admin, 403},
+		{"pending decline", "DELETE", memberPath("member@example.com"), nil, member, 200},
+		{"declined accept", "POST", path + "/accept", nil, member, 404},
+		{"accept", "POST", path + "/accept", nil, admin, 200},
+
+		// the last owner can neither leave nor be demoted
+		{"last owner demote", "POST", path + "/members", map[string]interface{}{"Email": "owner@example.com", "Role": RoleAdmin}, nil, 400},
+		{"last owner leave", "DELETE", memberPath("owner@example.com"), nil, nil, 400},
+
+		// admins manage members and admins up to their own role, but not owners
+		{"admin invite owner", "POST", path + "/members", map[string]interface{}{"Email": "member@example.com", "Role": RoleOwner}, admin, 403},
+		{"admin invite", "POST", path + "/members", map[string]interface{}{"Email": "member@example.com"}, admin, 200},
+		{"member accept", "POST", path + "/accept", nil, member, 200},
+		{"admin promote admin", "POST", path + "/members", map[string]interface{}{"Email": "member@example.com", "Role": RoleAdmin}, admin, 200},
+		{"admin promote owner", "POST", path + "/members", map[string]interface{}{"Email": "member@example.com", "Role": RoleOwner}, admin, 403},
+		{"admin demote owner", "POST", path + "/members", map[string]interface{}{"Email": "owner@example.com", "Role": RoleMember}, admin, 403},
+		{"admin remove owner", "DELETE", memberPath("owner@example.com"), nil, admin, 403},
+		{"admin delete org", "DELETE", path, nil, admin, 403},
+		{"admin demote admin", "POST", path + "/members", map[string]interface{}{"Email": "member@example.com", "Role": RoleMember}, admin, 200},
+
+		// members can only leave
+		{"member invite", "POST", path + "/members", map[string]interface{}{"Email": "c@example.com"}, member, 403},
+		{"member remove admin", "DELETE", memberPath("admin@example.com"), nil, member, 403},
+		{"member watch", "POST", path + "/watch", map[string]interface{}{"Domains": []string{"example.net"}}, member, 200},
+		{"member leave", "DELETE", memberPath("member@example.com"), nil, member, 200},
+
+		// with a second owner the first one can leave
+		{"promote owner", "POST", path + "/members", map[string]interface{}{"Email": "admin@example.com", "Role": RoleOwner}, nil, 200},
+		{"owner leave", "DELETE", memberPath("owner@example.com"), nil, nil, 200},
+		{"new last owner leave", "DELETE", memberPath("admin@example.com"), nil, admin, 400},
+		{"delete org", "DELETE", path, nil, admin, 200},
+	}
+	for _, test := range tests {
+		header := test.header
+		if header == nil {
+			header = owner
+		}
+		w := api.request(test.method, test.path, test.body, header)
+		if w.Code != test.code {
+			t.Fatalf("%s: %d %s", test.name, w.Code, w.Body.String())
+		}
+		if test.code == 400 && !strings.Contains(w.Body.String(), "the last owner can not be removed") {
+			t.Fatalf("%s: %s", test.name, w.Body.String())
+		}
+	}
+}
+
+func TestOrgWatchers(t *testing.T) {
+	api := newTestAPI(t, nil)
+	owner := api.login("owner@example.com")
+	muted := api.login("muted@example.com")
+	pending := api.login("pending@example.com")
+
+	w := api.request("POST", "/api1/orgs", map[string]interface{}{"Name": "Example"}, owner)
+	if w.Code != 200 {
+		t.Fatal(w.Body.String())
+	}
+	var org orgInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &org); err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/api1/orgs/%d", org.ID)
+	for _, request := range []struct {
+		path   string
+		body   interface{}
+		header http.Header
+	}{
+		{"/members", map[string]interface{}{"Email": "muted@example.com"}, owner},
+		{"/members", map[string]interface{}{"Email": "pending@example.com"}, owner},
+		{"/accept", nil, muted},
+		{"/preferences", map[string]interface{}{"Muted": true}, muted},
+		{"/watch", map[string]interface{}{"Domains": []string{"example.net"}}, owner},
+	} {
+		w = api.request("POST", path+request.path, request.body, request.header)
+		if w.Code != 200 {
+			t.Fatalf("%s: %s", request.path, w.Body.String())
+		}
+	}
+	// a pending member can not change its preferences
+	w = api.request("POST", path+"/preferences", map[string]interface{}{"Muted": false}, pending)
+	if w.Code != 404 {
+		t.Fatal(w.Body.String())
+	}
+
+	var domain Domain
+	if err := api.db.Where(&Domain{Domain: "example.net"}).First(&domain).Error; err != nil {
+		t.Fatal(err)
+	}
+	var email Email
+	if err := api.db.Where(&Email{Email: "owner@example.com"}).First(&email).Error; err != nil {
+		t.Fatal(err)
+	}
+	watches, err := orgWatchers(api.db, domain.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(watches) != 1 || watches[0].EmailID != email.ID || watches[0].Channels != EmailChannel {
+		t.Fatalf("%+v", watches)
+	}
+
+	// a member is in an organisation once
+	err = api.db.Create(&Membership{OrganisationID: org.ID, AccountID: api.accountID("muted@example.com"), Role: RoleMember, Channels: EmailChannel}).Error
+	if err == nil {
+		t.Fatal("a second membership was created")
+	}
+}
+
+func TestOrgInvitation(t *testing.T) {
+	api := newTestAPI(t, nil)
+	owner := api.login("owner@example.com")
+	admin := api.login("admin@example.com")
+
+	w := api.request("POST", "/api1/orgs", map[string]interface{}{"Name": "Example"}, owner)
+	if w.Code != 200 {
+		t.Fatal(w.Body.String())
+	}
+	var org orgInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &org); err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/api1/orgs/%d", org.ID)
+	members := func() []orgMember {
+		w := api.request("GET", path, nil, owner)
+		var detail struct{ Members []orgMember }
+		if err := json.Unmarshal(w.Body.Bytes(), &detail); err != nil {
+			t.Fatal(err)
+		}
+		return detail.Members
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   interface{}
+		header http.Header
+		code   int
+	}{
+		{"POST", "/members", map[string]interface{}{"Email": "admin@example.com", "Role": RoleAdmin}, owner, 200},
+		{"POST", "/accept", nil, admin, 200},
+		// invitations to addresses without an account do not create one
+		{"POST", "/members", map[string]interface{}{"Email": "New@example.com", "Role": RoleAdmin}, owner, 200},
+		{"POST", "/members", map[string]interface{}{"Email": "new@example.com"}, admin, 200},
+		{"POST", "/members", map[string]interface{}{"Email": "boss@example.com", "Role": RoleOwner}, owner, 200},
+		{"POST", "/members", map[string]interface{}{"Email": "gone@example.com"}, admin, 200},
+		// admins revoke invitations up to their own role
+		{"DELETE", "/members", map[string]interface{}{"Email": "boss@example.com"}, admin, 403},
+		{"DELETE", "/members", map[string]interface{}{"Email": "Gone@example.com"}, admin, 200},
+		{"DELETE", "/members", map[string]interface{}{"Email": "gone@example.com"}, admin, 404},
+		// members with an account are removed by their account id
+		{"DELETE", "/members", map[string]interface{}{"Email": "admin@example.com"}, owner, 404},
+	}
+	for _, test := range tests {
+		w := api.request(test.method, path+test.path, test.body, test.header)
+		if w.Code != test.code {
+			t.Fatalf("%s %s %v: %d %s", test.method, test.path, test.body, w.Code, w.Body.String())
+		}
+	}
+	if api.hasAccount("new@example.com") || api.hasAccount("boss@example.com") {
+		t.Fatal("an invitation created an account")
+	}
+	list := members()
+	if len(list) != 4 || list[2].Email != "new@example.com" || list[2].AccountID != 0 || list[2].Role != RoleMember || !list[2].Pending {
+		t.Fatalf("%+v", list)
+	}
+
+	if err := api.sendInvitation("new@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if mails := api.mails(); len(mails) != 1 || !strings.Contains(mails[0], "Example") {
+		t.Fatal(mails)
+	}
+
+	// the first login binds the invitation to the new account
+	invitee := api.login("new@example.com")
+	w = api.request("GET", "/api1/orgs", nil, invitee)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"Pending":true`) {
+		t.Fatal(w.Body.String())
+	}
+	w = api.request("POST", path+"/accept", nil, invitee)
+	if w.Code != 200 {
+		t.Fatal(w.Body.String())
+	}
+	list = members()
+	if list[2].Email != "new@example.com" || list[2].AccountID != api.accountID("new@example.com") || list[2].Pending {
+		t.Fatalf("%+v", list)
+	}
+	w = api.request("DELETE", path+"/members", map[string]interface{}{"Email": "new@example.com"}, owner)
+	if w.Code != 404 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,457 @@
+package api1
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Eun/domwatch"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	MessagePending   = "pending"
+	MessageSent      = "sent"
+	MessageDead      = "dead"
+	MessageCancelled = "cancelled" // the alert was acknowledged before the message was sent
+)
+
+const (
+	KindAvailable = "available"
+	KindConfirm   = "confirm"
+	KindUnwatch   = "unwatch"
+	KindLogin     = "login"
+	KindInvite    = "invite"
+)
+
+// Message is a notification in the outbox.
+// It carries everything needed for the delivery, because the domain and
+// the watches are gone by the time it is sent.
+type Message struct {
+	ID          uint   `gorm:"primary_key;not null"`
+	Kind        string `gorm:"type:char(32);not null"`
+	Channel     string `gorm:"type:char(64);not null;default:'email'"`
+	Recipient   string `gorm:"type:char(255);not null"`
+	Domain      string `gorm:"type:char(255);not null"`
+	Verdict     string `gorm:"type:char(32)"`
+	Evidence    string `gorm:"type:text"`
+	Digest      bool   `gorm:"not null;default:false"` // sent together with the other due digest messages of the recipient
+	AlertID     uint   `gorm:"not null;default:0;index"`
+	Step        int    `gorm:"not null;default:0"` // escalation step, 0 for the first notification
+	Status      string `gorm:"type:char(16);not null;index"`
+	Attempts    int    `gorm:"not null;default:0"`
+	NextAttempt int64  `gorm:"not null;default:0;index"`
+	LastError   string `gorm:"type:text"`
+	LeaseOwner  string `gorm:"type:char(255);not null;default:''"`
+	LeaseUntil  int64  `gorm:"not null;default:0"`
+	CreatedAt   time.Time
+	SentAt      *time.Time
+}
+
+// Delivery logs a single delivery attempt of a message
+type Delivery struct {
+	ID        uint   `gorm:"primary_key;not null"`
+	MessageID uint   `gorm:"not null;index"`
+	Channel   string `gorm:"type:char(64);not null"`
+	Attempt   int    `gorm:"not null"`
+	Success   bool   `gorm:"not null"`
+	Error     string `gorm:"type:text"`
+	Duration  int64  `gorm:"not null"` // milliseconds
+	CreatedAt time.Time
+}
+
+// enqueueMessages writes the availability messages for all watchers of a domain,
+// one for every channel of a watch, scheduled according to the preferences of the email.
+// If escalation is configured every watcher gets an alert.
+// It must be called inside the transaction that removes the domain.
+func (api *API) enqueueMessages(tx *gorm.DB, watches []Watch, domain *Domain, result *domwatch.Result) error {
+	now := time.Now().UTC()
+	evidence, err := json.Marshal(result.Evidence)
+	if err != nil {
+		return err
+	}
+	for _, w := range watches {
+		var email Email
+		err := tx.Where(&Email{ID: w.EmailID}).First(&email).Error
+		if err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				continue
+			}
+			return err
+		}
+		channels := w.Channels
+		if channels == "" {
+			channels = EmailChannel
+		}
+		var messages []*Message
+		alert := Alert{
+			Domain:    domain.Domain,
+			Recipient: email.Email,
+			Channels:  channels,
+			Verdict:   result.Verdict,
+			Evidence:  string(evidence),
+		}
+		for _, channel := range strings.Split(channels, ",") {
+			digest := isDigest(&email, channel)
+			msg := &Message{
+				Kind:        KindAvailable,
+				Channel:     channel,
+				Recipient:   email.Email,
+				Domain:      domain.Domain,
+				Verdict:     result.Verdict,
+				Evidence:    string(evidence),
+				Digest:      digest,
+				Status:      MessagePending,
+				NextAttempt: nextDelivery(&email, now, digest).Unix(),
+			}
+			if alert.Start == 0 || msg.NextAttempt < alert.Start {
+				alert.Start = msg.NextAttempt
+			}
+			messages = append(messages, msg)
+		}
+
+		err = api.createAlert(tx, &alert)
+		if err != nil {
+			return err
+		}
+		for _, msg := range messages {
+			msg.AlertID = alert.ID
+			err = tx.Create(msg).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// wakeDispatcher tells the dispatcher that there are new messages
+func (api *API) wakeDispatcher() {
+	select {
+	case api.outboxChan <- true:
+	default:
+	}
+}
+
+func (api *API) dispatchTask() {
+	api.dispatchMessages()
+	ticker := time.NewTicker(api.config.pollDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-api.closeChan:
+			return
+		case <-ticker.C:
+			api.dispatchMessages()
+		case <-api.outboxChan:
+			api.dispatchMessages()
+		}
+	}
+}
+
+func (api *API) dispatchMessages() {
+	api.escalateAlerts()
+	for {
+		messages, err := api.claimMessages(leaseBatchSize)
+		if err != nil {
+			api.logger.Printf("Error on dispatchMessages: %s", err.Error())
+			return
+		}
+		if len(messages) == 0 {
+			return
+		}
+		digests := make(map[string][]*Message)
+		for i := range messages {
+			if messages[i].Digest {
+				digests[messages[i].Recipient] = append(digests[messages[i].Recipient], &messages[i])
+				continue
+			}
+			err = api.deliverMessage(&messages[i])
+			if err != nil {
+				api.logger.Printf("Error on dispatchMessages: %s", err.Error())
+				return
+			}
+		}
+		for recipient, group := range digests {
+			err = api.deliverDigest(recipient, group)
+			if err != nil {
+				api.logger.Printf("Error on dispatchMessages: %s", err.Error())
+				return
+			}
+		}
+	}
+}
+
+// claimMessages leases up to limit messages that are due for delivery,
+// a negative limit claims all of them. conditions narrow down the messages.
+func (api *API) claimMessages(limit int, conditions ...interface{}) ([]Message, error) {
+	now := time.Now().UTC().Unix()
+	leaseUntil := now + int64(api.config.leaseDuration/time.Second)
+
+	query := api.db.Where("status = ? AND next_attempt <= ? AND lease_until < ?", MessagePending, now, now)
+	if len(conditions) > 0 {
+		query = query.Where(conditions[0], conditions[1:]...)
+	}
+	var candidates []Message
+	err := query.Order("next_attempt").Limit(limit).Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []Message
+	for _, msg := range candidates {
+		db := api.db.Model(&Message{}).
+			Where("id = ? AND status = ? AND lease_until < ?", msg.ID, MessagePending, now).
+			Updates(map[string]interface{}{"lease_owner": *api.config.InstanceID, "lease_until": leaseUntil})
+		if db.Error != nil {
+			return claimed, db.Error
+		}
+		if db.RowsAffected == 1 {
+			claimed = append(claimed, msg)
+		}
+	}
+	return claimed, nil
+}
+
+func (api *API) deliverMessage(msg *Message) error {
+	return api.deliver([]*Message{msg}, func() error {
+		return api.sendMessage(msg)
+	})
+}
+
+// deliverDigest sends the claimed digest messages of a recipient
+// together with all other due ones in a single mail
+func (api *API) deliverDigest(recipient string, group []*Message) error {
+	more, err := api.claimMessages(-1, "recipient = ? AND digest = ?", recipient, true)
+	if err != nil {
+		return err
+	}
+	for i := range more {
+		group = append(group, &more[i])
+	}
+	return api.deliver(group, func() error {
+		return api.sendDigest(recipient, group)
+	})
+}
+
+// deliver sends messages with send and records the outcome for each of them,
+// failed messages are retried with an exponential backoff until MaxAttempts is reached
+func (api *API) deliver(messages []*Message, send func() error) error {
+	start := time.Now()
+	sendErr := send()
+	duration := int64(time.Since(start) / time.Millisecond)
+
+	for _, msg := range messages {
+		msg.Attempts++
+		delivery := Delivery{
+			MessageID: msg.ID,
+			Channel:   msg.Channel,
+			Attempt:   msg.Attempts,
+			Success:   sendErr == nil,
+			Duration:  duration,
+		}
+		if sendErr != nil {
+			delivery.Error = sendErr.Error()
+		}
+		err := api.db.Create(&delivery).Error
+		if err != nil {
+			return err
+		}
+
+		msg.LeaseOwner = ""
+		msg.LeaseUntil = 0
+		if sendErr == nil {
+			now := time.Now().UTC()
+			msg.Status = MessageSent
+			msg.SentAt = &now
+			msg.LastError = ""
+		} else {
+			api.logger.Printf("Delivery of message %d to '%s' via %s failed (attempt %d): %s\n", msg.ID, msg.Recipient, msg.Channel, msg.Attempts, sendErr.Error())
+			msg.LastError = sendErr.Error()
+			if msg.Attempts >= *api.config.Outbox.MaxAttempts {
+				msg.Status = MessageDead
+			} else {
+				retry := time.Now().UTC().Add(api.config.retryDelay(msg.Attempts))
+				if msg.Kind == KindAvailable {
+					// retries of notifications wait for the end of the quiet hours as well
+					email := api.lookupEmail(msg.Recipient)
+					retry = nextDelivery(&email, retry, false)
+				}
+				msg.NextAttempt = retry.Unix()
+			}
+		}
+		// only the outcome is written and only while this instance holds the lease,
+		// an admin may have queued the message again in the meantime
+		db := api.db.Model(&Message{}).
+			Where("id = ? AND lease_owner = ?", msg.ID, *api.config.InstanceID).
+			Updates(map[string]interface{}{
+				"status":       msg.Status,
+				"attempts":     msg.Attempts,
+				"next_attempt": msg.NextAttempt,
+				"last_error":   msg.LastError,
+				"sent_at":      msg.SentAt,
+				"lease_owner":  "",
+				"lease_until":  0,
+			})
+		if db.Error != nil {
+			return db.Error
+		}
+		if db.RowsAffected == 0 {
+			api.logger.Printf("Lost the lease of message %d, its delivery was not recorded\n", msg.ID)
+		}
+	}
+	return nil
+}
+
+func (api *API) sendMessage(msg *Message) error {
+	switch msg.Kind {
+	case KindAvailable, KindConfirm, KindUnwatch, KindLogin, KindInvite:
+	default:
+		return errors.New("unknown message kind '" + msg.Kind + "'")
+	}
+	notifier, ok := api.notifiers[msg.Channel]
+	if !ok {
+		return errors.New("unknown channel '" + msg.Channel + "'")
+	}
+
+	notification := Notification{
+		MessageID: msg.ID,
+		Kind:      msg.Kind,
+		Recipient: msg.Recipient,
+		Domain:    msg.Domain,
+		Verdict:   msg.Verdict,
+		Step:      msg.Step,
+		Time:      msg.CreatedAt,
+		Ack:       api.ackLink(msg.AlertID, msg.Recipient),
+	}
+	if msg.Evidence != "" {
+		err := json.Unmarshal([]byte(msg.Evidence), &notification.Evidence)
+		if err != nil {
+			return err
+		}
+	}
+	return notifier.Notify(&notification)
+}
+
+// isAdmin accepts the admin token and the sessions of accounts that are administrators
+func (api *API) isAdmin(r *http.Request) bool {
+	// the comparison takes the same time for every wrong token of the same length
+	if api.config.AdminToken != nil && *api.config.AdminToken != "" &&
+		subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+*api.config.AdminToken)) == 1 {
+		return true
+	}
+	account, err := api.currentAccount(r)
+	if err != nil {
+		api.logger.Printf("Error on isAdmin: %s", err.Error())
+		return false
+	}
+	return account != nil && account.Admin
+}
+
+func (api *API) outboxRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false {
+		api.writeError(w, "Must be a GET request")
+		return
+	}
+	if !api.isAdmin(r) {
+		api.writeAccessDenied(w)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = MessageDead
+	}
+
+	var messages []Message
+	err := api.db.Where(&Message{Status: status}).Order("id").Find(&messages).Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	api.writeSuccessResponse(w, messages)
+}
+
+func (api *API) outboxRetryRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST request")
+		return
+	}
+	if !api.isAdmin(r) {
+		api.writeAccessDenied(w)
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		api.writeNotFound(w)
+		return
+	}
+
+	var msg Message
+	db := api.db.Where(&Message{ID: uint(id)}).First(&msg)
+	if db.Error != nil {
+		if db.RecordNotFound() {
+			api.writeNotFound(w)
+		} else {
+			api.logError(w, db.Error)
+		}
+		return
+	}
+
+	if msg.Status == MessageSent {
+		api.writeError(w, "message was already sent")
+		return
+	}
+
+	// the lease is released so the next dispatch picks the message up, a delivery
+	// that is still running can not record its outcome anymore
+	err = api.db.Model(&Message{}).
+		Where("id = ? AND status <> ?", msg.ID, MessageSent).
+		Updates(map[string]interface{}{
+			"status":       MessagePending,
+			"attempts":     0,
+			"next_attempt": time.Now().UTC().Unix(),
+			"lease_owner":  "",
+			"lease_until":  0,
+		}).Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	api.wakeDispatcher()
+
+	api.writeSuccessResponse(w, nil)
+}
+
+func (api *API) outboxDeliveriesRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false {
+		api.writeError(w, "Must be a GET request")
+		return
+	}
+	if !api.isAdmin(r) {
+		api.writeAccessDenied(w)
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		api.writeNotFound(w)
+		return
+	}
+
+	var deliveries []Delivery
+	err = api.db.Where(&Delivery{MessageID: uint(id)}).Order("id").Find(&deliveries).Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	api.writeSuccessResponse(w, deliveries)
+}
@@ -0,0 +1,119 @@
+package api1
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestOutboxLease(t *testing.T) {
+	api := newTestAPI(t, map[string]interface{}{"AdminToken": "admin", "InstanceID": "instance1"})
+	message := func(id uint) Message {
+		var msg Message
+		if err := api.db.Where(&Message{ID: id}).First(&msg).Error; err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	msg := Message{Kind: KindAvailable, Channel: EmailChannel, Recipient: "a@example.com", Domain: "a-example.com", Status: MessagePending}
+	if err := api.db.Create(&msg).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := api.claimMessages(1)
+	if err != nil || len(claimed) != 1 || message(msg.ID).LeaseOwner != "instance1" {
+		t.Fatal(claimed, err)
+	}
+
+	// an admin queues the message again while it is delivered
+	header := http.Header{"Authorization": {"Bearer admin"}}
+	w := api.request("POST", "/api1/outbox/"+strconv.Itoa(int(msg.ID))+"/retry", nil, header)
+	if w.Code != 200 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if retried := message(msg.ID); retried.LeaseOwner != "" || retried.LeaseUntil != 0 || retried.Status != MessagePending {
+		t.Fatalf("%+v", retried)
+	}
+
+	// the running delivery does not overwrite the retry
+	if err = api.deliver([]*Message{&claimed[0]}, func() error { return errors.New("timeout") }); err != nil {
+		t.Fatal(err)
+	}
+	if retried := message(msg.ID); retried.Attempts != 0 || retried.LastError != "" || retried.NextAttempt > time.Now().Unix() {
+		t.Fatalf("%+v", retried)
+	}
+
+	// the next claim delivers it
+	claimed, err = api.claimMessages(1)
+	if err != nil || len(claimed) != 1 {
+		t.Fatal(claimed, err)
+	}
+	if err = api.deliver([]*Message{&claimed[0]}, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if sent := message(msg.ID); sent.Status != MessageSent || sent.Attempts != 1 || sent.SentAt == nil || sent.LeaseOwner != "" {
+		t.Fatalf("%+v", sent)
+	}
+	var deliveries int
+	api.db.Model(&Delivery{}).Where(&Delivery{MessageID: msg.ID}).Count(&deliveries)
+	if deliveries != 2 {
+		t.Fatal(deliveries)
+	}
+}
+
+func TestOutboxRetryQuietHours(t *testing.T) {
+	api := newTestAPI(t, map[string]interface{}{"InstanceID": "instance1"})
+	// the quiet hours start now and last three hours
+	now := time.Now().UTC()
+	clock := func(t time.Time) string { return t.Format("15:04") }
+	email := Email{Email: "a@example.com", QuietHours: clock(now) + "-" + clock(now.Add(3*time.Hour))}
+	if err := api.db.Create(&email).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []string{KindAvailable, KindConfirm} {
+		msg := Message{Kind: kind, Channel: EmailChannel, Recipient: email.Email, Domain: "a-example.com", Status: MessagePending}
+		if err := api.db.Create(&msg).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	claimed, err := api.claimMessages(2)
+	if err != nil || len(claimed) != 2 {
+		t.Fatal(claimed, err)
+	}
+	for i := range claimed {
+		if err = api.deliver([]*Message{&claimed[i]}, func() error { return errors.New("timeout") }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var messages []Message
+	if err = api.db.Order("id").Find(&messages).Error; err != nil {
+		t.Fatal(err)
+	}
+	// the notification waits for the end of the quiet hours, the confirmation is retried after the delay
+	retry := now.Add(api.config.retryDelay(1))
+	if next := time.Unix(messages[0].NextAttempt, 0); next.Before(now.Add(2*time.Hour)) || next.After(now.Add(3*time.Hour)) {
+		t.Fatalf("%+v", messages[0])
+	}
+	if next := time.Unix(messages[1].NextAttempt, 0); next.Before(retry.Add(-time.Second)) || next.After(retry.Add(time.Minute)) {
+		t.Fatalf("%+v", messages[1])
+	}
+}
+
+func TestOutboxAdminToken(t *testing.T) {
+	api := newTestAPI(t, map[string]interface{}{"AdminToken": "admin"})
+	for token, code := range map[string]int{"Bearer admin": 200, "Bearer admin2": 403, "Bearer adm": 403, "admin": 403, "": 403} {
+		w := api.request("GET", "/api1/outbox", nil, http.Header{"Authorization": {token}})
+		if w.Code != code {
+			t.Fatalf("%q: %d %s", token, w.Code, w.Body.String())
+		}
+	}
+	// without a token nobody is an administrator by the header
+	*api.config.AdminToken = ""
+	w := api.request("GET", "/api1/outbox", nil, http.Header{"Authorization": {"Bearer "}})
+	if w.Code != 403 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
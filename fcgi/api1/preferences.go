@@ -0,0 +1,359 @@
+package api1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	DigestInstant = "instant"
+	DigestHourly  = "hourly"
+	DigestDaily   = "daily"
+)
+
+// daily digests are sent at this hour in the timezone of the recipient
+const digestHour = 8
+
+// preference links are part of every mail, so they are valid as long as the unwatch links
+const preferencesTokenTTL = unwatchTokenTTL
+
+type preferences struct {
+	Email      string
+	Digest     string
+	Timezone   string
+	QuietHours string
+}
+
+// quietWindow is a daily window in minutes since midnight, it wraps around midnight if End < Start
+type quietWindow struct {
+	Start int
+	End   int
+}
+
+// parseQuietHours parses a comma separated list of windows like 22:00-07:00
+func parseQuietHours(s string) ([]quietWindow, error) {
+	var windows []quietWindow
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var w quietWindow
+		var h1, m1, h2, m2 int
+		if _, err := fmt.Sscanf(part, "%d:%d-%d:%d", &h1, &m1, &h2, &m2); err != nil ||
+			h1 < 0 || h1 > 23 || m1 < 0 || m1 > 59 || h2 < 0 || h2 > 24 || m2 < 0 || m2 > 59 || (h2 == 24 && m2 != 0) {
+			return nil, fmt.Errorf("invalid quiet hours '%s'", part)
+		}
+		w.Start = h1*60 + m1
+		w.End = h2*60 + m2
+		if w.Start == w.End {
+			return nil, fmt.Errorf("invalid quiet hours '%s'", part)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func formatQuietHours(windows []quietWindow) string {
+	var parts []string
+	for _, w := range windows {
+		parts = append(parts, fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60))
+	}
+	return strings.Join(parts, ",")
+}
+
+// until returns the end of the window if t lies inside of it
+func (w quietWindow) until(t time.Time) (time.Time, bool) {
+	minute := t.Hour()*60 + t.Minute()
+	// the end is a wall clock time, the day may be shorter or longer on a change of daylight saving time
+	end := func(days int) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day()+days, w.End/60, w.End%60, 0, 0, t.Location())
+	}
+	if w.Start < w.End {
+		if minute >= w.Start && minute < w.End {
+			return end(0), true
+		}
+		return t, false
+	}
+	if minute >= w.Start {
+		return end(1), true
+	}
+	if minute < w.End {
+		return end(0), true
+	}
+	return t, false
+}
+
+// emailLocation returns the timezone of an email, UTC if none is set
+func emailLocation(email *Email) *time.Location {
+	if email.Timezone != "" {
+		if loc, err := time.LoadLocation(email.Timezone); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// nextDelivery returns when a message to email may be sent,
+// digests wait for the next slot and nothing is sent during the quiet hours
+func nextDelivery(email *Email, now time.Time, digest bool) time.Time {
+	t := now.In(emailLocation(email))
+	if digest {
+		switch email.Digest {
+		case DigestHourly:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case DigestDaily:
+			slot := time.Date(t.Year(), t.Month(), t.Day(), digestHour, 0, 0, 0, t.Location())
+			if !slot.After(t) {
+				slot = slot.AddDate(0, 0, 1)
+			}
+			t = slot
+		}
+	}
+
+	windows, _ := parseQuietHours(email.QuietHours)
+	// the end of one window can lie inside another one
+	for i := 0; i <= len(windows); i++ {
+		moved := false
+		for _, w := range windows {
+			if end, ok := w.until(t); ok {
+				t = end
+				moved = true
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+	return t
+}
+
+// isDigest tells whether messages to email over channel are collected into a digest
+func isDigest(email *Email, channel string) bool {
+	return channel == EmailChannel && (email.Digest == DigestHourly || email.Digest == DigestDaily)
+}
+
+func (api *API) preferencesToken(email string) string {
+	return api.signToken("preferences", time.Now().Add(preferencesTokenTTL), email)
+}
+
+func (api *API) preferencesLink(email string) string {
+	return api.link("/preferences.html?token=" + url.QueryEscape(api.preferencesToken(email)))
+}
+
+// validate normalizes the preferences
+func (p *preferences) validate() error {
+	p.Digest = strings.ToLower(strings.TrimSpace(p.Digest))
+	switch p.Digest {
+	case "":
+		p.Digest = DigestInstant
+	case DigestInstant, DigestHourly, DigestDaily:
+	default:
+		return fmt.Errorf("invalid digest '%s'", p.Digest)
+	}
+
+	p.Timezone = strings.TrimSpace(p.Timezone)
+	if p.Timezone == "Local" {
+		return errors.New("invalid timezone 'Local'")
+	}
+	if _, err := time.LoadLocation(p.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone '%s'", p.Timezone)
+	}
+
+	windows, err := parseQuietHours(p.QuietHours)
+	if err != nil {
+		return err
+	}
+	p.QuietHours = formatQuietHours(windows)
+	return nil
+}
+
+// reschedule moves the messages of an email that were not tried yet according to its preferences
+func (api *API) reschedule(email *Email) error {
+	now := time.Now().UTC()
+	var messages []Message
+	err := api.db.Where("recipient = ? AND kind = ? AND status = ? AND attempts = 0 AND lease_until < ?",
+		email.Email, KindAvailable, MessagePending, now.Unix()).Find(&messages).Error
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		digest := isDigest(email, msg.Channel)
+		err = api.db.Model(&Message{}).Where("id = ? AND lease_until < ?", msg.ID, now.Unix()).Updates(map[string]interface{}{
+			"digest":       digest,
+			"next_attempt": nextDelivery(email, now, digest).Unix(),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preferencesRoute shows (GET) and changes (POST) the delivery preferences of an email,
+// both need a token from one of the mails
+func (api *API) preferencesRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false && strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a GET or POST request")
+		return
+	}
+
+	apiRequest := struct {
+		Token string
+		preferences
+	}{}
+	redirect := false
+	if strings.EqualFold(r.Method, "GET") {
+		apiRequest.Token = r.URL.Query().Get("token")
+	} else {
+		contentType := r.Header.Get("Content-Type")
+		if strings.EqualFold(contentType, "application/json") {
+			err := json.NewDecoder(r.Body).Decode(&apiRequest)
+			if err != nil {
+				api.writeError(w, "invalid request")
+				return
+			}
+		} else if strings.EqualFold(contentType, "application/x-www-form-urlencoded") {
+			apiRequest.Token = r.FormValue("token")
+			apiRequest.Digest = r.FormValue("digest")
+			apiRequest.Timezone = r.FormValue("timezone")
+			apiRequest.QuietHours = r.FormValue("quiet_hours")
+			redirect = true
+		} else {
+			api.writeError(w, "invalid request")
+			return
+		}
+	}
+
+	fields, err := api.verifyToken("preferences", apiRequest.Token)
+	if err != nil || len(fields) != 1 {
+		if redirect {
+			w.Header().Set("Location", "/#invalid_token")
+			w.WriteHeader(302)
+		} else {
+			api.writeAccessDenied(w)
+		}
+		return
+	}
+
+	var email Email
+	db := api.db.Where(&Email{Email: fields[0]}).First(&email)
+	if db.Error != nil {
+		if db.RecordNotFound() {
+			api.writeNotFound(w)
+		} else {
+			api.logError(w, db.Error)
+		}
+		return
+	}
+
+	if strings.EqualFold(r.Method, "GET") {
+		digest := email.Digest
+		if digest == "" {
+			digest = DigestInstant
+		}
+		api.writeSuccessResponse(w, &preferences{
+			Email:      email.Email,
+			Digest:     digest,
+			Timezone:   email.Timezone,
+			QuietHours: email.QuietHours,
+		})
+		return
+	}
+
+	err = apiRequest.validate()
+	if err != nil {
+		if redirect {
+			w.Header().Set("Location", "/#invalid_preferences")
+			w.WriteHeader(302)
+		} else {
+			api.writeError(w, err.Error())
+		}
+		return
+	}
+
+	email.Digest = apiRequest.Digest
+	email.Timezone = apiRequest.Timezone
+	email.QuietHours = apiRequest.QuietHours
+	err = api.db.Model(&email).Updates(map[string]interface{}{
+		"digest":      email.Digest,
+		"timezone":    email.Timezone,
+		"quiet_hours": email.QuietHours,
+	}).Error
+	if err == nil {
+		err = api.reschedule(&email)
+	}
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	if redirect {
+		w.Header().Set("Location", "/#preferences_saved")
+		w.WriteHeader(302)
+	} else {
+		api.writeSuccessResponse(w, nil)
+	}
+}
+
+type digestEvent struct {
+	Domain string
+	Time   string
+	Ack    string
+}
+
+type digestTemplateData struct {
+	mailContext
+	Period      string
+	Events      []digestEvent
+	Watching    []string
+	Preferences string
+	Unsubscribe string
+}
+
+// sendDigest sends one mail for several availability messages
+func (api *API) sendDigest(recipient string, messages []*Message) error {
+	context := &digestTemplateData{
+		mailContext: api.mailContext(),
+		Period:      DigestDaily,
+		Preferences: api.preferencesLink(recipient),
+		Unsubscribe: api.unsubscribeLink(recipient, ""),
+	}
+
+	var email Email
+	err := api.db.Where(&Email{Email: recipient}).First(&email).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+	if email.Digest == DigestHourly {
+		context.Period = DigestHourly
+	}
+	if err == nil {
+		err = api.db.Table("domains").
+			Joins("JOIN watches ON watches.domain_id = domains.id").
+			Where("watches.email_id = ? AND watches.pending = ?", email.ID, false).
+			Order("domains.domain").
+			Pluck("domains.domain", &context.Watching).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	loc := emailLocation(&email)
+	for _, msg := range messages {
+		event := digestEvent{
+			Domain: msg.Domain,
+			Time:   msg.CreatedAt.In(loc).Format("2006-01-02 15:04 MST"),
+			Ack:    api.ackLink(msg.AlertID, recipient),
+		}
+		context.Events = append(context.Events, event)
+	}
+	return api.sendMail(recipient, TemplateDigest, context, api.unsubscribeHeaders(recipient)...)
+}
@@ -0,0 +1,119 @@
+package api1
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+		ok  bool
+	}{
+		{"", "", true},
+		{"22:00-07:00", "22:00-07:00", true},
+		{" 9:5-17:30 , 0:00-24:00", "09:05-17:30,00:00-24:00", true},
+		{"22:00-22:00", "", false},
+		{"24:00-07:00", "", false},
+		{"22:00-24:30", "", false},
+		{"22:60-07:00", "", false},
+		{"22-07", "", false},
+	}
+	for _, test := range tests {
+		windows, err := parseQuietHours(test.in)
+		if (err == nil) != test.ok || formatQuietHours(windows) != test.out {
+			t.Fatalf("%q: %q %v", test.in, formatQuietHours(windows), err)
+		}
+	}
+}
+
+func TestNextDelivery(t *testing.T) {
+	utc := func(s string) time.Time {
+		t, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	tests := []struct {
+		name   string
+		email  Email
+		now    string
+		digest bool
+		next   string
+	}{
+		{"instant", Email{}, "2026-03-10 12:34", false, "2026-03-10 12:34"},
+		{"invalid timezone", Email{Timezone: "Mars/Olympus", QuietHours: "12:00-13:00"}, "2026-03-10 12:34", false, "2026-03-10 13:00"},
+
+		// 22:00-07:00 in Berlin are 21:00-06:00 UTC in winter
+		{"before wrap-around", Email{Timezone: "Europe/Berlin", QuietHours: "22:00-07:00"}, "2026-03-10 20:59", false, "2026-03-10 20:59"},
+		{"start of wrap-around", Email{Timezone: "Europe/Berlin", QuietHours: "22:00-07:00"}, "2026-03-10 21:00", false, "2026-03-11 06:00"},
+		{"before midnight", Email{Timezone: "Europe/Berlin", QuietHours: "22:00-07:00"}, "2026-03-10 22:30", false, "2026-03-11 06:00"},
+		{"after midnight", Email{Timezone: "Europe/Berlin", QuietHours: "22:00-07:00"}, "2026-03-10 05:00", false, "2026-03-10 06:00"},
+		{"end of wrap-around", Email{Timezone: "Europe/Berlin", QuietHours: "22:00-07:00"}, "2026-03-10 06:00", false, "2026-03-10 06:00"},
+		{"summer time", Email{Timezone: "Europe/Berlin", QuietHours: "22:00-07:00"}, "2026-07-10 20:30", false, "2026-07-11 05:00"},
+		// the night of the change to summer time is an hour shorter
+		{"change of daylight saving time", Email{Timezone: "Europe/Berlin", QuietHours: "01:00-04:00"}, "2026-03-29 00:30", false, "2026-03-29 02:00"},
+
+		{"day window", Email{Timezone: "America/New_York", QuietHours: "09:00-17:00"}, "2026-01-15 15:00", false, "2026-01-15 22:00"},
+		{"windows in a row", Email{QuietHours: "22:00-07:00,06:30-08:00"}, "2026-03-10 23:00", false, "2026-03-11 08:00"},
+
+		{"hourly digest", Email{Digest: DigestHourly}, "2026-03-10 12:34", true, "2026-03-10 13:00"},
+		{"hourly digest ignored", Email{Digest: DigestHourly}, "2026-03-10 12:34", false, "2026-03-10 12:34"},
+		{"hourly digest in quiet hours", Email{Digest: DigestHourly, QuietHours: "22:00-07:00"}, "2026-03-10 21:30", true, "2026-03-11 07:00"},
+		{"daily digest", Email{Digest: DigestDaily}, "2026-03-10 07:00", true, "2026-03-10 08:00"},
+		{"daily digest tomorrow", Email{Digest: DigestDaily}, "2026-03-10 08:00", true, "2026-03-11 08:00"},
+		{"daily digest in timezone", Email{Digest: DigestDaily, Timezone: "Asia/Tokyo"}, "2026-03-10 12:00", true, "2026-03-10 23:00"},
+		{"daily digest in quiet hours", Email{Digest: DigestDaily, Timezone: "Europe/Berlin", QuietHours: "07:00-09:00"}, "2026-03-10 12:00", true, "2026-03-11 08:00"},
+	}
+	for _, test := range tests {
+		next := nextDelivery(&test.email, utc(test.now), test.digest)
+		if !next.Equal(utc(test.next)) {
+			t.Fatalf("%s: %s", test.name, next.UTC().Format("2006-01-02 15:04"))
+		}
+	}
+}
+
+func TestPreferencesReschedule(t *testing.T) {
+	api := newTestAPI(t, nil)
+	email := Email{Email: "a@example.com"}
+	if err := api.db.Create(&email).Error; err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	msg := Message{Kind: KindAvailable, Channel: EmailChannel, Recipient: email.Email, Domain: "example.net", Status: MessagePending, NextAttempt: now.Unix()}
+	if err := api.db.Create(&msg).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	w := api.request("POST", "/api1/preferences", map[string]interface{}{
+		"Token":    api.preferencesToken(email.Email),
+		"Digest":   "Daily",
+		"Timezone": "Asia/Tokyo",
+	}, nil)
+	if w.Code != 200 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if err := api.db.Where(&Email{Email: email.Email}).First(&email).Error; err != nil {
+		t.Fatal(err)
+	}
+	if email.Digest != DigestDaily || email.Timezone != "Asia/Tokyo" {
+		t.Fatalf("%+v", email)
+	}
+	if err := api.db.Where(&Message{ID: msg.ID}).First(&msg).Error; err != nil {
+		t.Fatal(err)
+	}
+	next := time.Unix(msg.NextAttempt, 0).In(emailLocation(&email))
+	if !msg.Digest || next.Hour() != digestHour || next.Minute() != 0 || !next.After(now) || next.Sub(now) > 24*time.Hour {
+		t.Fatalf("%+v %s", msg, next)
+	}
+
+	for _, invalid := range []map[string]interface{}{{"Digest": "weekly"}, {"Timezone": "Local"}, {"Timezone": "Mars/Olympus"}, {"QuietHours": "22:00"}} {
+		invalid["Token"] = api.preferencesToken(email.Email)
+		w = api.request("POST", "/api1/preferences", invalid, nil)
+		if w.Code != 400 {
+			t.Fatal(invalid, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package api1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func pushTitle(notification *Notification) string {
+	if notification.Step > 0 {
+		return fmt.Sprintf("Reminder: %s is %s!", notification.Domain, notification.Verdict)
+	}
+	return fmt.Sprintf("%s is %s!", notification.Domain, notification.Verdict)
+}
+
+func pushText(notification *Notification) string {
+	if len(notification.Evidence) == 0 {
+		return pushTitle(notification)
+	}
+	return strings.Join(notification.Evidence, "\n")
+}
+
+// ntfyNotifier publishes to a ntfy topic, URL is the url of the topic
+type ntfyNotifier struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newNtfyNotifier(channel *ChannelConfig) (Notifier, error) {
+	n := &ntfyNotifier{
+		url:    *channel.URL,
+		client: &http.Client{Timeout: channel.timeout},
+	}
+	if channel.Token != nil {
+		n.token = *channel.Token
+	}
+	return n, nil
+}
+
+func (n *ntfyNotifier) Notify(notification *Notification) error {
+	req, err := http.NewRequest("POST", n.url, strings.NewReader(pushText(notification)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", pushTitle(notification))
+	req.Header.Set("Priority", "high")
+	req.Header.Set("Tags", "warning")
+	if notification.Ack != "" {
+		req.Header.Set("Actions", "view, Acknowledge, "+notification.Ack)
+	}
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	return doWebhookRequest(n.client, req)
+}
+
+// gotifyNotifier sends a message to a gotify server, Token is the application token
+type gotifyNotifier struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newGotifyNotifier(name string, channel *ChannelConfig) (Notifier, error) {
+	if channel.Token == nil {
+		return nil, fmt.Errorf("Channel '%s' needs a Token", name)
+	}
+	return &gotifyNotifier{
+		url:    strings.TrimRight(*channel.URL, "/") + "/message",
+		token:  *channel.Token,
+		client: &http.Client{Timeout: channel.timeout},
+	}, nil
+}
+
+func (n *gotifyNotifier) Notify(notification *Notification) error {
+	payload := &struct {
+		Title    string                 `json:"title"`
+		Message  string                 `json:"message"`
+		Priority int                    `json:"priority"`
+		Extras   map[string]interface{} `json:"extras,omitempty"`
+	}{Title: pushTitle(notification), Message: pushText(notification), Priority: 8}
+	if notification.Ack != "" {
+		payload.Extras = map[string]interface{}{
+			"client::notification": map[string]interface{}{"click": map[string]string{"url": notification.Ack}},
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", n.token)
+	return doWebhookRequest(n.client, req)
+}
@@ -0,0 +1,164 @@
+package api1
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The methods in this file are used by the newer versions of the API,
+// they share the database, the check cache and the rate limits with api1.
+
+// EventAvailable is the type of the event when a watched domain became available
+const EventAvailable = KindAvailable
+
+// Event is an alert that was raised for a watch of an account
+type Event struct {
+	ID        uint
+	Type      string
+	Domain    string
+	Verdict   string
+	Evidence  []string
+	Channels  []string
+	CreatedAt time.Time
+	AckedBy   string     `json:",omitempty"`
+	AckedAt   *time.Time `json:",omitempty"`
+}
+
+// Check checks a domain right away, results are cached for Check.CacheTTL
+func (api *API) Check(domain string) (*CheckResult, error) {
+	return api.cachedCheck(domain)
+}
+
+// CheckCacheTTL is how long the result of a check is reused
+func (api *API) CheckCacheTTL() time.Duration {
+	return api.config.Check.cacheTTL
+}
+
+// Cached tells whether the result of a check of domain is cached or the domain is being checked,
+// such checks do not need a token of the rate limit
+func (api *API) Cached(domain string) bool {
+	return api.cached(domain)
+}
+
+// Allow takes one token from the rate limit bucket of client,
+// if it is empty it returns how long the client has to wait
+func (api *API) Allow(client string) (bool, time.Duration) {
+	return api.allow(client, 1)
+}
+
+// ParseChannels validates a list of channels and returns them in the stored form
+func (api *API) ParseChannels(channels []string) (string, error) {
+	return api.parseChannels(channels)
+}
+
+// Watches returns all watches of the account
+func (api *API) Watches(account *Account) ([]*WatchInfo, error) {
+	return api.accountWatches(account)
+}
+
+// Watch returns a watch of the account, nil if the account does not watch the domain
+func (api *API) Watch(account *Account, domain string) (*WatchInfo, error) {
+	watches, err := api.accountWatches(account)
+	if err != nil {
+		return nil, err
+	}
+	for _, watch := range watches {
+		if watch.Domain == domain {
+			return watch, nil
+		}
+	}
+	return nil, nil
+}
+
+// SetWatch creates or updates a watch of the account with channels in the stored form,
+// the watch is confirmed right away. It returns true if the watch was created.
+func (api *API) SetWatch(account *Account, domain string, channels string) (bool, error) {
+	email, err := api.accountEmail(account)
+	if err != nil {
+		return false, err
+	}
+	var dom Domain
+	err = api.db.FirstOrCreate(&dom, &Domain{Domain: domain}).Error
+	if err != nil {
+		return false, err
+	}
+
+	var count int
+	err = api.db.Model(&Watch{}).Where(&Watch{DomainID: dom.ID, EmailID: email.ID}).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	err = api.db.Where(&Watch{DomainID: dom.ID, EmailID: email.ID}).
+		Assign(map[string]interface{}{"channels": channels, "pending": false}).
+		FirstOrCreate(&Watch{}).Error
+	if err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// RemoveWatch removes a watch of the account, it returns false if there was none
+func (api *API) RemoveWatch(account *Account, domain string) (bool, error) {
+	email, err := api.accountEmail(account)
+	if err != nil {
+		return false, err
+	}
+	var dom Domain
+	err = api.db.Where(&Domain{Domain: domain}).First(&dom).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	db := api.db.Where(&Watch{DomainID: dom.ID, EmailID: email.ID}).Delete(&Watch{})
+	return db.RowsAffected > 0, db.Error
+}
+
+func newEvent(alert *Alert) *Event {
+	event := &Event{
+		ID:        alert.ID,
+		Type:      EventAvailable,
+		Domain:    alert.Domain,
+		Verdict:   alert.Verdict,
+		Evidence:  []string{},
+		Channels:  strings.Split(alert.Channels, ","),
+		CreatedAt: alert.CreatedAt,
+		AckedBy:   alert.AckedBy,
+		AckedAt:   alert.AckedAt,
+	}
+	if alert.Evidence != "" {
+		json.Unmarshal([]byte(alert.Evidence), &event.Evidence)
+	}
+	return event
+}
+
+// Events returns at most limit events of the account with an ID greater than after, the oldest first
+func (api *API) Events(account *Account, after uint, limit int) ([]*Event, error) {
+	var alerts []Alert
+	err := api.db.Where("recipient = ? AND id > ?", account.Email, after).Order("id").Limit(limit).Find(&alerts).Error
+	if err != nil {
+		return nil, err
+	}
+	events := []*Event{}
+	for i := range alerts {
+		events = append(events, newEvent(&alerts[i]))
+	}
+	return events, nil
+}
+
+// Event returns an event of the account, nil if it does not exist
+func (api *API) Event(account *Account, id uint) (*Event, error) {
+	var alert Alert
+	err := api.db.Where(&Alert{ID: id, Recipient: account.Email}).First(&alert).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return newEvent(&alert), nil
+}
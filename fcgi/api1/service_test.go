@@ -0,0 +1,45 @@
+package api1
+
+import (
+	"testing"
+
+	"github.com/Eun/domwatch"
+)
+
+func TestEventsWithoutEscalation(t *testing.T) {
+	api := newTestAPI(t, nil)
+	api.login("a@example.com")
+	var account Account
+	if err := api.db.Where(&Account{Email: "a@example.com"}).First(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.SetWatch(&account, "a-example.com", EmailChannel); err != nil {
+		t.Fatal(err)
+	}
+	var domain Domain
+	var watches []Watch
+	api.db.Where(&Domain{Domain: "a-example.com"}).First(&domain)
+	api.db.Where(&Watch{DomainID: domain.ID}).Find(&watches)
+
+	result := &domwatch.Result{Available: true, Verdict: domwatch.VerdictAvailable}
+	if err := api.enqueueMessages(api.db, watches, &domain, result); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := api.Events(&account, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Domain != "a-example.com" || events[0].Type != EventAvailable {
+		t.Fatalf("%+v", events)
+	}
+
+	// there is no escalation to stop, so the notification has no ack link
+	var msg Message
+	if err = api.db.Where(&Message{Recipient: "a@example.com", Kind: KindAvailable}).First(&msg).Error; err != nil {
+		t.Fatal(err)
+	}
+	if msg.AlertID != events[0].ID || api.ackLink(msg.AlertID, msg.Recipient) != "" {
+		t.Fatalf("%+v", msg)
+	}
+}
@@ -0,0 +1,192 @@
+package api1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Eun/domwatch"
+)
+
+const (
+	suggestLimit       = 100  // names returned if the request sets no limit
+	maxSuggestKeywords = 20   // keywords of a request, they are combined in pairs
+	maxSuggestAffixes  = 100  // prefixes and suffixes of a request, each
+	maxSuggestWords    = 1000 // longest word list of a request
+)
+
+type suggestRequest struct {
+	Keywords  []string
+	Prefixes  []string // DefaultPrefixes if missing
+	Suffixes  []string // DefaultSuffixes if missing
+	Words     []string
+	Hyphenate bool
+	Plurals   bool
+	Digits    bool
+	MinLength int
+	MaxLength int
+	Limit     int
+	TLD       string
+	Check     bool // check the names with the batch checker
+}
+
+// parseSuggestRequest reads the parameters of a GET request or the JSON body of a POST request
+func parseSuggestRequest(w http.ResponseWriter, r *http.Request) (*suggestRequest, error) {
+	apiRequest := suggestRequest{}
+	if strings.EqualFold(r.Method, "POST") {
+		if !strings.HasPrefix(strings.ToLower(r.Header.Get("Content-Type")), "application/json") {
+			return nil, fmt.Errorf("invalid request")
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&apiRequest); err != nil {
+			return nil, fmt.Errorf("invalid request")
+		}
+	} else {
+		query := r.URL.Query()
+		apiRequest.Keywords = splitNames(query.Get("keywords"))
+		if _, ok := query["prefixes"]; ok {
+			apiRequest.Prefixes = splitNames(query.Get("prefixes"))
+		}
+		if _, ok := query["suffixes"]; ok {
+			apiRequest.Suffixes = splitNames(query.Get("suffixes"))
+		}
+		apiRequest.Words = splitNames(query.Get("words"))
+		apiRequest.Hyphenate = query.Get("hyphenate") == "1" || query.Get("hyphenate") == "true"
+		apiRequest.Plurals = query.Get("plurals") == "1" || query.Get("plurals") == "true"
+		apiRequest.Digits = query.Get("digits") == "1" || query.Get("digits") == "true"
+		apiRequest.Check = query.Get("check") == "1" || query.Get("check") == "true"
+		apiRequest.TLD = query.Get("tld")
+		for name, value := range map[string]*int{"min_length": &apiRequest.MinLength, "max_length": &apiRequest.MaxLength, "limit": &apiRequest.Limit} {
+			if s := query.Get(name); s != "" {
+				n, err := strconv.Atoi(s)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s", name)
+				}
+				*value = n
+			}
+		}
+	}
+
+	if apiRequest.Prefixes == nil {
+		apiRequest.Prefixes = domwatch.DefaultPrefixes
+	}
+	if apiRequest.Suffixes == nil {
+		apiRequest.Suffixes = domwatch.DefaultSuffixes
+	}
+	apiRequest.TLD = strings.ToLower(strings.Trim(apiRequest.TLD, ". "))
+	if apiRequest.TLD == "" {
+		apiRequest.TLD = "com"
+	}
+	return &apiRequest, nil
+}
+
+// suggestRoute generates names from keywords, with check the names are streamed through the batch checker
+func (api *API) suggestRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false && strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a GET or POST request")
+		return
+	}
+	apiRequest, err := parseSuggestRequest(w, r)
+	if err != nil {
+		api.writeError(w, err.Error())
+		return
+	}
+	if len(apiRequest.Keywords) == 0 {
+		api.writeError(w, "no keywords")
+		return
+	}
+	// the generator combines every list with the keywords, so their size bounds the work of a request
+	for _, list := range []struct {
+		name  string
+		words []string
+		max   int
+	}{
+		{"keywords", apiRequest.Keywords, maxSuggestKeywords},
+		{"prefixes", apiRequest.Prefixes, maxSuggestAffixes},
+		{"suffixes", apiRequest.Suffixes, maxSuggestAffixes},
+		{"words", apiRequest.Words, maxSuggestWords},
+	} {
+		if len(list.words) > list.max {
+			api.writeError(w, fmt.Sprintf("at most %d %s per request", list.max, list.name))
+			return
+		}
+		for _, word := range list.words {
+			if len(word) > domwatch.MaxLabelLength {
+				api.writeError(w, fmt.Sprintf("%s can be at most %d characters long", list.name, domwatch.MaxLabelLength))
+				return
+			}
+		}
+	}
+	if apiRequest.MinLength < 0 || apiRequest.MaxLength < 0 || apiRequest.Limit < 0 ||
+		(apiRequest.MaxLength > 0 && apiRequest.MinLength > apiRequest.MaxLength) {
+		api.writeError(w, "invalid length")
+		return
+	}
+	if apiRequest.Limit == 0 {
+		apiRequest.Limit = suggestLimit
+	}
+	if apiRequest.Limit > *api.config.Check.MaxBatch {
+		apiRequest.Limit = *api.config.Check.MaxBatch
+	}
+	if _, ok := NormalizeDomain("name." + apiRequest.TLD); !ok {
+		api.writeError(w, "invalid tld")
+		return
+	}
+
+	// checking is only available to accounts like batches and every name that is not cached counts as a check,
+	// suggestions alone count as one check for the rate limit
+	var client string
+	if apiRequest.Check {
+		account, ok := api.requestAccount(w, r, ScopeCheck)
+		if !ok {
+			return
+		}
+		if account == nil {
+			api.writeAccessDenied(w)
+			return
+		}
+		client = Client(account, r)
+	} else {
+		var ok bool
+		if client, ok = api.checkClient(w, r); !ok {
+			return
+		}
+	}
+
+	names := domwatch.GenerateNames(apiRequest.Keywords, domwatch.GeneratorOptions{
+		Prefixes:  apiRequest.Prefixes,
+		Suffixes:  apiRequest.Suffixes,
+		Words:     apiRequest.Words,
+		Hyphenate: apiRequest.Hyphenate,
+		Plurals:   apiRequest.Plurals,
+		Digits:    apiRequest.Digits,
+		MinLength: apiRequest.MinLength,
+		MaxLength: apiRequest.MaxLength,
+		Limit:     apiRequest.Limit,
+	})
+	if !apiRequest.Check {
+		if ok, retryAfter := api.allow(client, 1); !ok {
+			api.writeTooManyRequests(w, retryAfter)
+			return
+		}
+		if names == nil {
+			names = []string{}
+		}
+		domains := make([]string, len(names))
+		for i, name := range names {
+			domains[i] = name + "." + apiRequest.TLD
+		}
+		api.writeSuccessResponse(w, &struct {
+			TLD     string
+			Names   []string
+			Domains []string
+		}{apiRequest.TLD, names, domains})
+		return
+	}
+	budget, ok := api.allowChecks(w, client, batchDomains(names, apiRequest.TLD))
+	if !ok {
+		return
+	}
+	api.streamBatch(w, r, budget, names, apiRequest.TLD)
+}
@@ -0,0 +1,63 @@
+package api1
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSuggestLimits(t *testing.T) {
+	// nothing answers on the DNS server, so checks fail right away
+	api := newTestAPI(t, map[string]interface{}{
+		"DNSServer": "127.0.0.1",
+		"Check":     map[string]interface{}{"Rate": 600, "Burst": 3},
+	})
+	words := func(n int, length int) []string {
+		list := make([]string, n)
+		for i := range list {
+			list[i] = strings.Repeat("a", length)
+		}
+		return list
+	}
+
+	tests := []struct {
+		name    string
+		request map[string]interface{}
+	}{
+		{"keywords", map[string]interface{}{"Keywords": words(maxSuggestKeywords+1, 4)}},
+		{"prefixes", map[string]interface{}{"Keywords": []string{"acme"}, "Prefixes": words(maxSuggestAffixes+1, 4)}},
+		{"suffixes", map[string]interface{}{"Keywords": []string{"acme"}, "Suffixes": words(maxSuggestAffixes+1, 4)}},
+		{"words", map[string]interface{}{"Keywords": []string{"acme"}, "Words": words(maxSuggestWords+1, 4)}},
+		{"long keyword", map[string]interface{}{"Keywords": words(1, 64)}},
+	}
+	for _, test := range tests {
+		w := api.request("POST", "/api1/suggest", test.request, nil)
+		if w.Code != 400 {
+			t.Fatalf("%s: %d %s", test.name, w.Code, w.Body.String())
+		}
+	}
+
+	body := `{"Keywords": ["` + strings.Repeat("a", 1<<20) + `"]}`
+	header := http.Header{"Content-Type": {"application/json"}}
+	if w := api.request("POST", "/api1/suggest", bytes.NewReader([]byte(body)), header); w.Code != 400 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	header = api.login("a@example.com")
+	w := api.request("GET", "/api1/suggest?keywords=acme&limit=4", nil, header)
+	if w.Code != 200 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	// checked names beyond the burst wait for the rate limit
+	w = api.request("GET", "/api1/suggest?keywords=acme&check=1&limit=4", nil, header)
+	if w.Code != 200 || strings.Count(w.Body.String(), "\n") != 4 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	// checked suggestions with the default limit fit into the default burst
+	if defaults := newTestAPI(t, nil); suggestLimit > *defaults.config.Check.Burst {
+		t.Fatal(suggestLimit, *defaults.config.Check.Burst)
+	}
+}
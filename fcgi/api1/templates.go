@@ -0,0 +1,412 @@
+package api1
+
+import (
+	htmltemplate "html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// A mail template consists of three files in <Mail.Templates>/<locale>/:
+// <name>.subject.txt, <name>.txt and <name>.html.
+// Missing files fall back to the default locale and then to the built in templates.
+
+const (
+	TemplateAvailable = "available"
+	TemplateConfirm   = "confirm"
+	TemplateUnwatch   = "unwatch"
+	TemplateDigest    = "digest"
+	TemplateLogin     = "login"
+	TemplateInvite    = "invite"
+)
+
+type templateSource struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+var builtinTemplates = map[string]templateSource{
+	TemplateAvailable: {
+		Subject: `{{if .Reminder}}Reminder: {{end}}⚠️ {{.Domain}} is available!`,
+		Text: `We just wanted to notify you that the domain
+
+    {{.Domain}}
+
+is now available.
+{{if .Ack}}
+Please acknowledge this notification, otherwise we keep reminding you:
+
+    {{.Ack}}
+{{end}}
+Sincerely,
+
+{{.Site}}
+
+To receive a summary instead open {{.Preferences}}
+To stop all notifications open {{.Unsubscribe}}
+`,
+		HTML: `<p>We just wanted to notify you that the domain</p>
+<p style="font-size:1.4em"><strong>{{.Domain}}</strong></p>
+<p>is now available.</p>
+{{if .Ack}}<p><a href="{{.Ack}}">Acknowledge</a>, otherwise we keep reminding you.</p>
+{{end}}<p>Sincerely,<br>{{.Site}}</p>
+<p style="font-size:.8em"><a href="{{.Preferences}}">Receive a summary instead</a> &middot; <a href="{{.Unsubscribe}}">Stop all notifications</a></p>
+`,
+	},
+	TemplateDigest: {
+		Subject: `{{if eq (len .Events) 1}}⚠️ {{(index .Events 0).Domain}} is available!{{else}}⚠️ {{len .Events}} domains are available!{{end}}`,
+		Text: `Here is your {{.Period}} summary, the following domains are now available:
+{{range .Events}}
+    {{.Domain}} (since {{.Time}}){{if .Ack}}
+    acknowledge: {{.Ack}}{{end}}
+{{end}}{{if .Watching}}
+You are still watching: {{range $index, $element := .Watching}}{{if $index}}, {{end}}{{$element}}{{end}}
+{{end}}
+Sincerely,
+
+{{.Site}}
+
+To change how often you receive mails open {{.Preferences}}
+To stop all notifications open {{.Unsubscribe}}
+`,
+		HTML: `<p>Here is your {{.Period}} summary, the following domains are now available:</p>
+<ul>{{range .Events}}<li><strong>{{.Domain}}</strong> (since {{.Time}}){{if .Ack}} <a href="{{.Ack}}">Acknowledge</a>{{end}}</li>{{end}}</ul>
+{{if .Watching}}<p>You are still watching: {{range $index, $element := .Watching}}{{if $index}}, {{end}}{{$element}}{{end}}</p>
+{{end}}<p>Sincerely,<br>{{.Site}}</p>
+<p style="font-size:.8em"><a href="{{.Preferences}}">Change how often you receive mails</a> &middot; <a href="{{.Unsubscribe}}">Stop all notifications</a></p>
+`,
+	},
+	TemplateConfirm: {
+		Subject: `Please confirm your watches`,
+		Text: `{{if .Domains}}Someone, hopefully you, asked us to notify this address when the
+following domains become available:
+{{range .Domains}}
+    {{.}}
+{{end}}{{end}}{{if .Browsers}}
+Someone, hopefully you, asked us to send the notifications of this address
+to {{if eq .Browsers 1}}a browser{{else}}{{.Browsers}} browsers{{end}} as push messages.
+{{end}}
+Please confirm by opening this link until {{.Expires}}:
+
+    {{.Link}}
+
+If this was not you, just ignore this mail and nothing will happen.
+
+Sincerely,
+
+{{.Site}}
+`,
+		HTML: `{{if .Domains}}<p>Someone, hopefully you, asked us to notify this address when the following domains become available:</p>
+<ul>{{range .Domains}}<li>{{.}}</li>{{end}}</ul>
+{{end}}{{if .Browsers}}<p>Someone, hopefully you, asked us to send the notifications of this address to {{if eq .Browsers 1}}a browser{{else}}{{.Browsers}} browsers{{end}} as push messages.</p>
+{{end}}<p><a href="{{.Link}}">Confirm</a> (valid until {{.Expires}})</p>
+<p>If this was not you, just ignore this mail and nothing will happen.</p>
+<p>Sincerely,<br>{{.Site}}</p>
+`,
+	},
+	TemplateLogin: {
+		Subject: `Your login link`,
+		Text: `Someone, hopefully you, asked for a link to log in with this address.
+
+Open this link until {{.Expires}} to log in:
+
+    {{.Link}}
+
+If this was not you, just ignore this mail and nothing will happen.
+
+Sincerely,
+
+{{.Site}}
+`,
+		HTML: `<p>Someone, hopefully you, asked for a link to log in with this address.</p>
+<p><a href="{{.Link}}">Log in</a> (valid until {{.Expires}})</p>
+<p>If this was not you, just ignore this mail and nothing will happen.</p>
+<p>Sincerely,<br>{{.Site}}</p>
+`,
+	},
+	TemplateInvite: {
+		Subject: `You were invited to {{index .Organisations 0}}`,
+		Text: `You were invited to share the watch list of
+{{range .Organisations}}
+    {{.}}
+{{end}}
+Log in to accept the invitation, you will then be notified when a domain
+on the list becomes available:
+
+    {{.Link}}
+
+If you do not want to join, just ignore this mail and nothing will happen.
+
+Sincerely,
+
+{{.Site}}
+`,
+		HTML: `<p>You were invited to share the watch list of</p>
+<ul>{{range .Organisations}}<li>{{.}}</li>{{end}}</ul>
+<p><a href="{{.Link}}">Log in to accept the invitation</a>, you will then be notified when a domain on the list becomes available.</p>
+<p>If you do not want to join, just ignore this mail and nothing will happen.</p>
+<p>Sincerely,<br>{{.Site}}</p>
+`,
+	},
+	TemplateUnwatch: {
+		Subject: `Manage your watches`,
+		Text: `Someone, hopefully you, asked us to stop notifying this address.
+{{range .Links}}
+To stop watching {{.Domain}} open
+
+    {{.Link}}
+{{end}}
+To stop all notifications open
+
+    {{.Unsubscribe}}
+
+If this was not you, just ignore this mail and nothing will happen.
+
+Sincerely,
+
+{{.Site}}
+`,
+		HTML: `<p>Someone, hopefully you, asked us to stop notifying this address.</p>
+<ul>{{range .Links}}<li><a href="{{.Link}}">Stop watching {{.Domain}}</a></li>{{end}}</ul>
+<p><a href="{{.Unsubscribe}}">Stop all notifications</a></p>
+<p>If this was not you, just ignore this mail and nothing will happen.</p>
+<p>Sincerely,<br>{{.Site}}</p>
+`,
+	},
+}
+
+type mailTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// mailTemplates holds the parsed templates by locale and name
+type mailTemplates struct {
+	defaultLocale string
+	locales       map[string]map[string]*mailTemplate
+	// sources of the default locale, other locales only override single files
+	sources map[string]templateSource
+}
+
+func readTemplateFile(dir string, locale string, file string) (string, bool, error) {
+	if dir == "" {
+		return "", false, nil
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, locale, file))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return string(b), true, nil
+}
+
+// loadMailTemplates parses the built in templates and the templates in dir
+func loadMailTemplates(dir string, defaultLocale string) (*mailTemplates, error) {
+	locales := []string{defaultLocale}
+	if dir != "" {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() && !strings.EqualFold(e.Name(), defaultLocale) {
+				locales = append(locales, strings.ToLower(e.Name()))
+			}
+		}
+	}
+
+	templates := &mailTemplates{
+		defaultLocale: defaultLocale,
+		locales:       make(map[string]map[string]*mailTemplate),
+		sources:       make(map[string]templateSource),
+	}
+	for _, locale := range locales {
+		templates.locales[locale] = make(map[string]*mailTemplate)
+		for name, builtin := range builtinTemplates {
+			source := builtin
+			if locale != defaultLocale {
+				source = templates.sources[name]
+			}
+			found := false
+			for _, part := range []struct {
+				file string
+				dst  *string
+			}{{name + ".subject.txt", &source.Subject}, {name + ".txt", &source.Text}, {name + ".html", &source.HTML}} {
+				s, ok, err := readTemplateFile(dir, locale, part.file)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					*part.dst = s
+					found = true
+				}
+			}
+			if locale == defaultLocale {
+				templates.sources[name] = source
+			} else if !found {
+				continue
+			}
+
+			t, err := parseMailTemplate(name, source)
+			if err != nil {
+				return nil, err
+			}
+			templates.locales[locale][name] = t
+		}
+	}
+	return templates, nil
+}
+
+func parseMailTemplate(name string, source templateSource) (*mailTemplate, error) {
+	var t mailTemplate
+	var err error
+	t.subject, err = texttemplate.New(name + ".subject").Parse(strings.TrimSpace(source.Subject))
+	if err != nil {
+		return nil, err
+	}
+	t.text, err = texttemplate.New(name + ".text").Parse(source.Text)
+	if err != nil {
+		return nil, err
+	}
+	t.html, err = htmltemplate.New(name + ".html").Parse(source.HTML)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// get returns the template for a locale like de-AT, falling back to de and the default locale
+func (templates *mailTemplates) get(name string, locale string) *mailTemplate {
+	locale = strings.ToLower(strings.Replace(locale, "_", "-", -1))
+	for _, l := range []string{locale, strings.SplitN(locale, "-", 2)[0]} {
+		if t, ok := templates.locales[l][name]; ok {
+			return t
+		}
+	}
+	return templates.locales[templates.defaultLocale][name]
+}
+
+// localePattern matches a language tag like de or de-at, the stored locales are at most 16 characters
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{1,8})*$`)
+
+// normalizeLocale returns a locale like de_AT in the stored form de-at, false if it is no language tag
+func normalizeLocale(locale string) (string, bool) {
+	locale = strings.ToLower(strings.Replace(strings.TrimSpace(locale), "_", "-", -1))
+	if len(locale) > 16 || !localePattern.MatchString(locale) {
+		return "", false
+	}
+	return locale, true
+}
+
+// requestLocale returns the first language of the Accept-Language header
+func requestLocale(r *http.Request) string {
+	lang := strings.SplitN(r.Header.Get("Accept-Language"), ",", 2)[0]
+	lang, _ = normalizeLocale(strings.SplitN(lang, ";", 2)[0])
+	return lang
+}
+
+// sampleTemplateData returns example data to preview a template
+func (api *API) sampleTemplateData(name string) interface{} {
+	unsubscribe := api.link("/api1/unsubscribe?token=example")
+	preferences := api.link("/preferences.html?token=example")
+	ack := ""
+	if len(api.config.Escalation.Steps) > 0 {
+		ack = api.link("/api1/ack?token=example")
+	}
+	switch name {
+	case TemplateAvailable:
+		return &availableTemplateData{
+			mailContext: api.mailContext(),
+			Domain:      "example.com",
+			Ack:         ack,
+			Preferences: preferences,
+			Unsubscribe: unsubscribe,
+		}
+	case TemplateDigest:
+		now := time.Now().UTC()
+		return &digestTemplateData{
+			mailContext: api.mailContext(),
+			Period:      DigestDaily,
+			Events: []digestEvent{
+				{"example.com", now.Add(-5 * time.Hour).Format("2006-01-02 15:04 MST"), ack},
+				{"example.net", now.Add(-2 * time.Hour).Format("2006-01-02 15:04 MST"), ack},
+			},
+			Watching:    []string{"example.org"},
+			Preferences: preferences,
+			Unsubscribe: unsubscribe,
+		}
+	case TemplateConfirm:
+		return &confirmTemplateData{
+			mailContext: api.mailContext(),
+			Domains:     []string{"example.com", "example.net"},
+			Browsers:    1,
+			Link:        api.link("/api1/confirm?token=example"),
+			Expires:     time.Now().Add(api.config.confirmationTTL).UTC().Format(time.RFC1123Z),
+		}
+	case TemplateLogin:
+		return &loginTemplateData{
+			mailContext: api.mailContext(),
+			Link:        api.link("/api1/login?token=example"),
+			Expires:     time.Now().Add(api.config.loginTTL).UTC().Format(time.RFC1123Z),
+		}
+	case TemplateInvite:
+		return &inviteTemplateData{
+			mailContext:   api.mailContext(),
+			Organisations: []string{"Example Inc."},
+			Link:          api.link("/account.html"),
+		}
+	case TemplateUnwatch:
+		return &unwatchTemplateData{
+			mailContext: api.mailContext(),
+			Links:       []unwatchLink{{"example.com", unsubscribe}, {"example.net", unsubscribe}},
+			Unsubscribe: unsubscribe,
+		}
+	}
+	return nil
+}
+
+func (api *API) templatePreviewRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false {
+		api.writeError(w, "Must be a GET request")
+		return
+	}
+	if !api.isAdmin(r) {
+		api.writeAccessDenied(w)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	data := api.sampleTemplateData(name)
+	if data == nil {
+		api.writeNotFound(w)
+		return
+	}
+
+	rendered, err := api.renderMail(name, r.URL.Query().Get("locale"), data)
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(rendered.HTML))
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(rendered.Text))
+	default:
+		api.writeSuccessResponse(w, rendered)
+	}
+}
@@ -0,0 +1,161 @@
+package api1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Eun/domwatch"
+	"github.com/asaskevich/govalidator"
+)
+
+// the list of generic TLDs is downloaded at most once per tldCacheDuration
+const tldCacheDuration = 24 * time.Hour
+
+type tldCache struct {
+	mu      sync.Mutex
+	fetched time.Time
+	tlds    []string
+}
+
+// gtlds returns the cached list of generic TLDs
+func (api *API) gtlds() ([]string, error) {
+	api.tlds.mu.Lock()
+	defer api.tlds.mu.Unlock()
+	if time.Since(api.tlds.fetched) > tldCacheDuration {
+		tlds, err := domwatch.FetchGTLDs(&http.Client{Timeout: 30 * time.Second})
+		if err != nil {
+			return nil, err
+		}
+		api.tlds.tlds = tlds
+		api.tlds.fetched = time.Now()
+	}
+	return api.tlds.tlds, nil
+}
+
+// matrixRoute checks labels in a set of TLDs and returns the availability grid.
+// GET takes the parameters labels and tlds, POST takes JSON and can watch the taken domains.
+func (api *API) matrixRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false && strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a GET or POST request")
+		return
+	}
+
+	apiRequest := struct {
+		Labels   []string
+		TLDs     string // list, group or all
+		Watch    bool   // watch the domains that are taken
+		Channels []string
+	}{}
+	if strings.EqualFold(r.Method, "GET") {
+		query := r.URL.Query()
+		apiRequest.Labels = splitNames(query.Get("labels"))
+		apiRequest.TLDs = query.Get("tlds")
+	} else {
+		if !strings.EqualFold(r.Header.Get("Content-Type"), "application/json") {
+			api.writeError(w, "invalid request")
+			return
+		}
+		err := json.NewDecoder(r.Body).Decode(&apiRequest)
+		if err != nil {
+			api.writeError(w, "invalid request")
+			return
+		}
+	}
+
+	account, ok := api.requestAccount(w, r, ScopeCheck)
+	if !ok {
+		return
+	}
+	if account == nil {
+		api.writeAccessDenied(w)
+		return
+	}
+	if apiRequest.Watch {
+		if key, err := api.requestKey(r); err != nil || (key != nil && !key.allows(ScopeWatchWrite)) {
+			api.writeAccessDenied(w)
+			return
+		}
+	}
+
+	var labels []string
+	for _, label := range apiRequest.Labels {
+		label = strings.ToLower(strings.TrimSpace(label))
+		if !govalidator.IsDNSName(label) || strings.Contains(label, ".") {
+			api.writeError(w, fmt.Sprintf("invalid label '%s'", label))
+			return
+		}
+		labels = append(labels, label)
+	}
+	if len(labels) == 0 {
+		api.writeError(w, "no labels")
+		return
+	}
+	if apiRequest.TLDs == "" {
+		apiRequest.TLDs = "popular"
+	}
+	tlds, err := domwatch.ResolveTLDs(apiRequest.TLDs, api.gtlds)
+	if err != nil {
+		api.writeError(w, err.Error())
+		return
+	}
+	if n := len(labels) * len(tlds); n > *api.config.Check.MaxBatch {
+		// all generic TLDs are more than the default limit even for one label
+		if strings.EqualFold(strings.TrimSpace(apiRequest.TLDs), domwatch.TLDAll) {
+			api.writeError(w, fmt.Sprintf("'all' are %d TLDs, %d labels in all of them are more than %d domains, use a group or a list of TLDs", len(tlds), len(labels), *api.config.Check.MaxBatch))
+			return
+		}
+		api.writeError(w, fmt.Sprintf("%d labels in %d TLDs are %d domains, at most %d per request", len(labels), len(tlds), n, *api.config.Check.MaxBatch))
+		return
+	}
+	channels, err := api.parseChannels(apiRequest.Channels)
+	if err != nil {
+		api.writeError(w, err.Error())
+		return
+	}
+
+	var domains []string
+	for _, label := range labels {
+		for _, tld := range tlds {
+			domains = append(domains, label+"."+tld)
+		}
+	}
+	budget, ok := api.allowChecks(w, Client(account, r), domains)
+	if !ok {
+		return
+	}
+
+	matrix := domwatch.CheckMatrix(labels, tlds, *api.config.Check.Concurrency, func(domain string) (*domwatch.Result, error) {
+		if !budget.take(domain, r.Context().Done()) {
+			return nil, errors.New("request cancelled")
+		}
+		api.checkSlots <- struct{}{}
+		defer func() { <-api.checkSlots }()
+		result, err := api.cachedCheck(domain)
+		if err != nil {
+			api.logger.Printf("Error on check of '%s': %s\n", domain, err.Error())
+			return nil, errors.New(errCheckFailed)
+		}
+		return result.Result, nil
+	})
+
+	watched := []string{}
+	if apiRequest.Watch {
+		watched = append(watched, matrix.Taken()...)
+		for _, domain := range watched {
+			if _, err = api.SetWatch(account, domain, channels); err != nil {
+				api.logError(w, err)
+				return
+			}
+		}
+	}
+
+	api.writeSuccessResponse(w, &struct {
+		*domwatch.Matrix
+		Watched []string
+	}{matrix, watched})
+}
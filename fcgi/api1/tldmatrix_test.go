@@ -0,0 +1,63 @@
+package api1
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Eun/domwatch"
+)
+
+func TestMatrixRateLimit(t *testing.T) {
+	// nothing answers on the DNS server, so checks fail right away and are not cached
+	api := newTestAPI(t, map[string]interface{}{
+		"DNSServer": "127.0.0.1",
+		"Check":     map[string]interface{}{"Rate": 600, "Burst": 2},
+	})
+	header := api.login("a@example.com")
+
+	// more domains than the burst wait for the rate limit
+	w := api.request("GET", "/api1/check/matrix?labels=acme&tlds=com,net,org", nil, header)
+	if w.Code != 200 || strings.Count(w.Body.String(), `"Error"`) != 3 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	*api.config.Check.Rate = 1
+	w = api.request("GET", "/api1/check/matrix?labels=acme&tlds=com,net,org", nil, header)
+	if w.Code != 429 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	// cached domains are free
+	for _, domain := range []string{"acme.com", "acme.net", "acme.org"} {
+		api.cacheResult(domain, domwatch.VerdictRegistered)
+	}
+	w = api.request("GET", "/api1/check/matrix?labels=acme&tlds=com,net,org", nil, header)
+	if w.Code != 200 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
+
+func TestMatrixLimit(t *testing.T) {
+	api := newTestAPI(t, map[string]interface{}{
+		"Check": map[string]interface{}{"MaxBatch": 10},
+	})
+	header := api.login("a@example.com")
+	api.tlds.tlds = []string{"com", "net", "org", "shop", "store", "app", "dev", "xyz", "cloud", "tech", "codes"}
+	api.tlds.fetched = time.Now()
+
+	// all TLDs are rejected before anything is checked with a hint at the groups
+	w := api.request("GET", "/api1/check/matrix?labels=acme&tlds=all", nil, header)
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "'all' are 11 TLDs") || !strings.Contains(w.Body.String(), "use a group") {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	w = api.request("GET", "/api1/check/matrix?labels=acme,rocket&tlds=popular", nil, header)
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "2 labels in 9 TLDs are 18 domains, at most 10") {
+		t.Fatal(w.Code, w.Body.String())
+	}
+
+	// the default group fits into the default limits
+	api = newTestAPI(t, nil)
+	if n := len(domwatch.TLDGroups["popular"]) * 2; n > *api.config.Check.MaxBatch || n > *api.config.Check.Burst {
+		t.Fatal(n)
+	}
+}
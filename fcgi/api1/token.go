@@ -0,0 +1,86 @@
+package api1
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Setting is a value that is shared by all instances
+type Setting struct {
+	Name  string `gorm:"type:char(64);primary_key;not null"`
+	Value string `gorm:"type:text;not null"`
+}
+
+var errInvalidToken = errors.New("invalid token")
+
+// loadSecret returns the key for signed tokens,
+// if none is configured a random one is created once and stored in the database
+func (api *API) loadSecret() error {
+	if api.config.Secret != nil && *api.config.Secret != "" {
+		api.secret = []byte(*api.config.Secret)
+		return nil
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	var setting Setting
+	err := api.db.Where(&Setting{Name: "secret"}).Attrs(&Setting{Value: hex.EncodeToString(random)}).FirstOrCreate(&setting).Error
+	if err != nil {
+		return err
+	}
+	api.secret = []byte(setting.Value)
+	return nil
+}
+
+// signToken creates a token for purpose that carries fields and is valid until expires
+func (api *API) signToken(purpose string, expires time.Time, fields ...string) string {
+	payload := strings.Join(append([]string{purpose, strconv.FormatInt(expires.Unix(), 10)}, fields...), "\x00")
+	mac := hmac.New(sha256.New, api.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyToken checks the signature, the purpose and the expiry of a token and returns its fields
+func (api *API) verifyToken(purpose string, token string) ([]string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, errInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	mac := hmac.New(sha256.New, api.secret)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errInvalidToken
+	}
+
+	fields := strings.Split(string(payload), "\x00")
+	if len(fields) < 2 || fields[0] != purpose {
+		return nil, errInvalidToken
+	}
+	expires, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return nil, errors.New("token expired")
+	}
+	return fields[2:], nil
+}
+
+// link returns the absolute url for a path on this site
+func (api *API) link(path string) string {
+	return strings.TrimRight(*api.config.BaseURL, "/") + path
+}
@@ -0,0 +1,209 @@
+package api1
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TLSNone             = "none"
+	TLSStartTLSRequired = "starttls-required"
+	TLSImplicit         = "implicit-tls"
+)
+
+// Transport hands a rendered mail to the next hop
+type Transport interface {
+	Send(from string, to []string, msg []byte) error
+	// Check verifies that the transport is able to deliver mails
+	Check() error
+}
+
+func newTransport(config *MailConfig) (Transport, error) {
+	switch *config.Transport {
+	case "smtp":
+		return newSMTPTransport(config)
+	case "sendmail":
+		return &sendmailTransport{path: *config.Sendmail}, nil
+	case "lmtp":
+		return &lmtpTransport{socket: *config.Socket, hostname: *config.Hostname, timeout: 30 * time.Second}, nil
+	case "maildir":
+		return &maildirTransport{dir: *config.Maildir}, nil
+	}
+	return nil, fmt.Errorf("Unknown mail transport '%s'", *config.Transport)
+}
+
+type smtpTransport struct {
+	addr     string
+	server   string
+	tls      string
+	hostname string
+	auth     smtp.Auth
+	timeout  time.Duration
+}
+
+func newSMTPTransport(config *MailConfig) (Transport, error) {
+	t := &smtpTransport{
+		addr:     net.JoinHostPort(*config.Server, strconv.Itoa(*config.Port)),
+		server:   *config.Server,
+		tls:      *config.TLS,
+		hostname: *config.Hostname,
+		timeout:  30 * time.Second,
+	}
+
+	switch *config.Auth {
+	case "none":
+	case "plain":
+		t.auth = smtp.PlainAuth("", *config.Username, *config.Password, *config.Server)
+	case "cram-md5":
+		t.auth = smtp.CRAMMD5Auth(*config.Username, *config.Password)
+	case "login":
+		t.auth = &loginAuth{*config.Username, *config.Password, *config.Server}
+	case "xoauth2":
+		t.auth = &xoauth2Auth{*config.Username, *config.Password}
+	default:
+		return nil, fmt.Errorf("Unknown mail auth '%s'", *config.Auth)
+	}
+	return t, nil
+}
+
+// dial connects, says hello, secures the connection and authenticates
+func (t *smtpTransport) dial() (*smtp.Client, error) {
+	tlsConfig := &tls.Config{ServerName: t.server}
+	dialer := &net.Dialer{Timeout: t.timeout}
+
+	var conn net.Conn
+	var err error
+	if t.tls == TLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", t.addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", t.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(5 * t.timeout))
+
+	client, err := smtp.NewClient(conn, t.server)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err = client.Hello(t.hostname); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	if t.tls == TLSStartTLSRequired {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("mail server does not support STARTTLS")
+		}
+		if err = client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	if t.auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			client.Close()
+			return nil, errors.New("mail server does not support AUTH")
+		}
+		if err = client.Auth(t.auth); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func (t *smtpTransport) Send(from string, to []string, msg []byte) error {
+	client, err := t.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err = client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err = client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (t *smtpTransport) Check() error {
+	client, err := t.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Quit()
+}
+
+// loginAuth implements the LOGIN mechanism, it is only used on encrypted connections or localhost
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && server.Name != "localhost" && server.Name != "127.0.0.1" && server.Name != "::1" {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge '%s'", fromServer)
+}
+
+// xoauth2Auth implements the XOAUTH2 mechanism, the token is an OAuth 2.0 access token
+type xoauth2Auth struct {
+	username string
+	token    string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// the server sent an error description, an empty response ends the exchange
+		return []byte{}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,163 @@
+package api1
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// sendmailTransport pipes the mail into a sendmail -t compatible binary
+type sendmailTransport struct {
+	path string
+}
+
+func (t *sendmailTransport) Send(from string, to []string, msg []byte) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(t.path, "-i", "-t", "-f", from)
+	cmd.Stdin = bytes.NewReader(msg)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%s: %s %s", t.path, err.Error(), strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (t *sendmailTransport) Check() error {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return fmt.Errorf("%s is not executable", t.path)
+	}
+	return nil
+}
+
+// lmtpTransport delivers to a local mail server using LMTP on a unix socket
+type lmtpTransport struct {
+	socket   string
+	hostname string
+	timeout  time.Duration
+}
+
+func (t *lmtpTransport) dial() (*textproto.Conn, error) {
+	conn, err := net.DialTimeout("unix", t.socket, t.timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(5 * t.timeout))
+	text := textproto.NewConn(conn)
+	if _, _, err = text.ReadResponse(220); err != nil {
+		text.Close()
+		return nil, err
+	}
+	if err = lmtpCmd(text, 250, "LHLO %s", t.hostname); err != nil {
+		text.Close()
+		return nil, err
+	}
+	return text, nil
+}
+
+func lmtpCmd(text *textproto.Conn, expectCode int, format string, args ...interface{}) error {
+	id, err := text.Cmd(format, args...)
+	if err != nil {
+		return err
+	}
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+	_, _, err = text.ReadResponse(expectCode)
+	return err
+}
+
+func (t *lmtpTransport) Send(from string, to []string, msg []byte) error {
+	text, err := t.dial()
+	if err != nil {
+		return err
+	}
+	defer text.Close()
+
+	if err = lmtpCmd(text, 250, "MAIL FROM:<%s>", from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err = lmtpCmd(text, 25, "RCPT TO:<%s>", rcpt); err != nil {
+			return err
+		}
+	}
+	if err = lmtpCmd(text, 354, "DATA"); err != nil {
+		return err
+	}
+	w := text.DotWriter()
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	// LMTP replies once for every recipient
+	for range to {
+		if _, _, err = text.ReadResponse(250); err != nil {
+			return err
+		}
+	}
+	return lmtpCmd(text, 221, "QUIT")
+}
+
+func (t *lmtpTransport) Check() error {
+	text, err := t.dial()
+	if err != nil {
+		return err
+	}
+	defer text.Close()
+	return lmtpCmd(text, 221, "QUIT")
+}
+
+// maildirTransport writes every mail as .eml file into the new directory of a maildir,
+// it is meant for development
+type maildirTransport struct {
+	dir string
+}
+
+var maildirCounter uint64
+
+func (t *maildirTransport) Send(from string, to []string, msg []byte) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(t.dir, sub), 0700); err != nil {
+			return err
+		}
+	}
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%d_%d.%s.eml", time.Now().Unix(), os.Getpid(), atomic.AddUint64(&maildirCounter, 1), strings.Replace(hostname, "/", "_", -1))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Return-Path: <%s>\r\nDelivered-To: %s\r\n", from, strings.Join(to, ", "))
+	buf.Write(msg)
+
+	tmp := filepath.Join(t.dir, "tmp", name)
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(t.dir, "new", name))
+}
+
+func (t *maildirTransport) Check() error {
+	if err := os.MkdirAll(filepath.Join(t.dir, "new"), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Join(t.dir, "new"), ".check")
+	if err != nil {
+		return errors.New("maildir is not writable: " + err.Error())
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,176 @@
+package api1
+
+import (
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaildirTransport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	transport := &maildirTransport{dir: dir}
+	if err := transport.Check(); err != nil {
+		t.Fatal(err)
+	}
+	msg := "Subject: test\r\n\r\nHello\r\n"
+	for i := 0; i < 2; i++ {
+		if err := transport.Send("bounces@example.com", []string{"a@example.com", "b@example.com"}, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the check leaves nothing behind and every mail gets its own file
+	if len(entries) != 2 || entries[0].Name() == entries[1].Name() || !strings.HasSuffix(entries[0].Name(), ".eml") {
+		t.Fatal(entries)
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "new", entries[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "Return-Path: <bounces@example.com>\r\nDelivered-To: a@example.com, b@example.com\r\n"+msg {
+		t.Fatalf("%q", raw)
+	}
+	if tmp, _ := ioutil.ReadDir(filepath.Join(dir, "tmp")); len(tmp) != 0 {
+		t.Fatal(tmp)
+	}
+
+	// a file is in the way of the maildir
+	file := filepath.Join(t.TempDir(), "file")
+	if err = ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	transport = &maildirTransport{dir: file}
+	if transport.Check() == nil || transport.Send("bounces@example.com", []string{"a@example.com"}, []byte(msg)) == nil {
+		t.Fatal("maildir in a file")
+	}
+}
+
+// lmtpServer is a LMTP server on a unix socket that records the dialogue,
+// it rejects recipients at reject.example.com and fails the delivery to recipients at full.example.com
+type lmtpServer struct {
+	socket   string
+	dialogue chan []string
+}
+
+func newLMTPServer(t *testing.T) *lmtpServer {
+	s := &lmtpServer{socket: filepath.Join(t.TempDir(), "lmtp.sock"), dialogue: make(chan []string, 10)}
+	listener, err := net.Listen("unix", s.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *lmtpServer) serve(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	text := textproto.NewConn(conn)
+	defer text.Close()
+	var dialogue []string
+	defer func() { s.dialogue <- dialogue }()
+
+	var recipients []string
+	text.PrintfLine("220 localhost LMTP ready")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		dialogue = append(dialogue, line)
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch {
+		case command == "LHLO":
+			text.PrintfLine("250-localhost")
+			text.PrintfLine("250 PIPELINING")
+		case command == "MAIL":
+			text.PrintfLine("250 2.1.0 OK")
+		case command == "RCPT" && strings.Contains(line, "@reject.example.com"):
+			text.PrintfLine("550 5.1.1 unknown user")
+		case command == "RCPT":
+			recipients = append(recipients, line)
+			text.PrintfLine("250 2.1.5 OK")
+		case command == "DATA":
+			text.PrintfLine("354 go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			dialogue = append(dialogue, string(data))
+			for _, rcpt := range recipients {
+				if strings.Contains(rcpt, "@full.example.com") {
+					text.PrintfLine("452 4.2.2 mailbox full")
+				} else {
+					text.PrintfLine("250 2.0.0 delivered")
+				}
+			}
+		case command == "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("500 unknown command")
+		}
+	}
+}
+
+func TestLMTPTransport(t *testing.T) {
+	server := newLMTPServer(t)
+	transport := &lmtpTransport{socket: server.socket, hostname: "domwatch.test", timeout: time.Second}
+
+	if err := transport.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if dialogue := <-server.dialogue; strings.Join(dialogue, "|") != "LHLO domwatch.test|QUIT" {
+		t.Fatal(dialogue)
+	}
+
+	// lines that start with a dot are escaped
+	msg := "Subject: test\r\n\r\nHello\r\n.\r\n..\r\n"
+	err := transport.Send("bounces@example.com", []string{"a@example.com", "b@example.com"}, []byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialogue := <-server.dialogue
+	expected := []string{
+		"LHLO domwatch.test",
+		"MAIL FROM:<bounces@example.com>",
+		"RCPT TO:<a@example.com>",
+		"RCPT TO:<b@example.com>",
+		"DATA",
+		strings.Replace(msg, "\r\n", "\n", -1),
+		"QUIT",
+	}
+	if strings.Join(dialogue, "|") != strings.Join(expected, "|") {
+		t.Fatalf("%q", dialogue)
+	}
+
+	// a rejected recipient or a failed delivery fails the message
+	for _, to := range [][]string{{"a@reject.example.com"}, {"a@example.com", "b@full.example.com"}} {
+		err = transport.Send("bounces@example.com", to, []byte(msg))
+		if err == nil {
+			t.Fatal(to)
+		}
+		<-server.dialogue
+	}
+
+	transport.socket = filepath.Join(filepath.Dir(server.socket), "missing.sock")
+	if transport.Check() == nil {
+		t.Fatal("missing socket")
+	}
+}
@@ -0,0 +1,161 @@
+package api1
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// unwatch tokens are part of every mail, so they are valid for a long time
+const unwatchTokenTTL = 365 * 24 * time.Hour
+
+// unwatchToken signs the right to remove the watch of email for domain,
+// or all watches of email if domain is empty
+func (api *API) unwatchToken(email string, domain string) string {
+	expires := time.Now().Add(unwatchTokenTTL)
+	if domain == "" {
+		return api.signToken("unwatch", expires, email)
+	}
+	return api.signToken("unwatch", expires, email, domain)
+}
+
+func (api *API) unsubscribeLink(email string, domain string) string {
+	return api.link("/api1/unsubscribe?token=" + url.QueryEscape(api.unwatchToken(email, domain)))
+}
+
+// verifyUnwatchToken returns the email and the domain a token was issued for
+func (api *API) verifyUnwatchToken(token string) (email string, domain string, err error) {
+	fields, err := api.verifyToken("unwatch", token)
+	if err != nil {
+		return "", "", err
+	}
+	switch len(fields) {
+	case 1:
+		return fields[0], "", nil
+	case 2:
+		return fields[0], fields[1], nil
+	}
+	return "", "", errInvalidToken
+}
+
+// removeWatches deletes the watch of an email for a domain,
+// or all watches of the email if domain is empty
+func (api *API) removeWatches(address string, domain string) error {
+	var email Email
+	err := api.db.Where(&Email{Email: address}).First(&email).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+	if domain == "" {
+		// the watch lists of organisations are kept for the other members
+		if email.AccountID != 0 {
+			err = api.db.Model(&Membership{}).Where("account_id = ?", email.AccountID).Update("muted", true).Error
+			if err != nil {
+				return err
+			}
+		}
+		return api.db.Where(&Watch{EmailID: email.ID}).Delete(&Watch{}).Error
+	}
+
+	var dom Domain
+	err = api.db.Where(&Domain{Domain: domain}).First(&dom).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+	return api.db.Where(&Watch{DomainID: dom.ID, EmailID: email.ID}).Delete(&Watch{}).Error
+}
+
+// unsubscribeRoute is the target of the links in the mails and of RFC 8058 one-click unsubscribes.
+// A GET only shows a confirmation page, so link scanners can not remove watches.
+func (api *API) unsubscribeRoute(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if strings.EqualFold(r.Method, "GET") {
+		w.Header().Set("Location", "/unsubscribe.html?token="+url.QueryEscape(token))
+		w.WriteHeader(302)
+		return
+	}
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST request")
+		return
+	}
+
+	oneClick := r.PostFormValue("List-Unsubscribe") == "One-Click"
+	if token == "" {
+		token = r.PostFormValue("token")
+	}
+
+	email, domain, err := api.verifyUnwatchToken(token)
+	if err != nil {
+		if oneClick {
+			api.writeAccessDenied(w)
+		} else {
+			w.Header().Set("Location", "/#invalid_token")
+			w.WriteHeader(302)
+		}
+		return
+	}
+
+	err = api.removeWatches(email, domain)
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	if oneClick {
+		api.writeSuccessResponse(w, nil)
+	} else {
+		w.Header().Set("Location", "/#unsubscribed")
+		w.WriteHeader(302)
+	}
+}
+
+type unwatchLink struct {
+	Domain string
+	Link   string
+}
+
+type unwatchTemplateData struct {
+	mailContext
+	Links       []unwatchLink
+	Unsubscribe string
+}
+
+// sendUnwatchLinks sends a mail with an unsubscribe link for every watch of an email
+func (api *API) sendUnwatchLinks(recipient string) error {
+	var email Email
+	err := api.db.Where(&Email{Email: recipient}).First(&email).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+
+	var domains []string
+	err = api.db.Table("domains").
+		Joins("JOIN watches ON watches.domain_id = domains.id").
+		Where("watches.email_id = ?", email.ID).
+		Order("domains.domain").
+		Pluck("domains.domain", &domains).Error
+	if err != nil || len(domains) == 0 {
+		return err
+	}
+
+	context := &unwatchTemplateData{
+		mailContext: api.mailContext(),
+		Unsubscribe: api.unsubscribeLink(recipient, ""),
+	}
+	for _, d := range domains {
+		context.Links = append(context.Links, unwatchLink{d, api.unsubscribeLink(recipient, d)})
+	}
+	return api.sendMail(recipient, TemplateUnwatch, context, api.unsubscribeHeaders(recipient)...)
+}
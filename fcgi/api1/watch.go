@@ -1,244 +1,358 @@
-package api1
-
-import (
-	"encoding/json"
-	"net/http"
-	"strings"
-	"time"
-
-	"github.com/asaskevich/govalidator"
-)
-
-type Domain struct {
-	ID          uint   `gorm:"primary_key;not null"`
-	Domain      string `gorm:"type:char(255);unique;not null"`
-	LastChecked int64  `gorm:"not null"`
-	CreatedAt   time.Time
-}
-
-type Email struct {
-	ID        uint   `gorm:"primary_key;not null"`
-	Email     string `gorm:"type:char(255);unique;not null"`
-	CreatedAt time.Time
-}
-
-type Watch struct {
-	DomainID  uint `gorm:"not null"`
-	EmailID   uint `gorm:"not null"`
-	CreatedAt time.Time
-}
-
-func (api *API) watchRoute(w http.ResponseWriter, r *http.Request) {
-	if strings.EqualFold(r.Method, "POST") == false {
-		api.writeError(w, "Must be a POST request")
-		return
-	}
-
-	var err error
-	apiRequest := struct {
-		Domains []string
-		Email   string
-	}{}
-	redirect := false
-	contentType := r.Header.Get("Content-Type")
-	if strings.EqualFold(contentType, "application/json") {
-		decoder := json.NewDecoder(r.Body)
-		err = decoder.Decode(&apiRequest)
-		if err != nil {
-			api.writeError(w, "invalid request")
-			return
-		}
-	} else if strings.EqualFold(contentType, "application/x-www-form-urlencoded") {
-		d := r.FormValue("domain")
-		if len(d) <= 0 {
-			if redirect {
-				w.Header().Set("Location", "/#invalid_domain")
-				w.WriteHeader(302)
-			} else {
-				api.writeError(w, "invalid domain")
-			}
-			return
-		}
-		apiRequest.Domains = []string{d}
-		apiRequest.Email = r.FormValue("email")
-		redirect = true
-	} else {
-		api.writeError(w, "invalid request")
-		return
-	}
-
-	apiRequest.Email = strings.ToLower(apiRequest.Email)
-
-	if !govalidator.IsEmail(apiRequest.Email) {
-		if redirect {
-			w.Header().Set("Location", "/#invalid_email")
-			w.WriteHeader(302)
-		} else {
-			api.writeError(w, "invalid email")
-		}
-		return
-	}
-
-	var email Email
-	err = api.db.FirstOrCreate(&email, &Email{Email: apiRequest.Email}).Error
-	if err != nil {
-		api.logError(w, err)
-		return
-	}
-
-	for _, d := range apiRequest.Domains {
-		if !govalidator.IsDNSName(d) {
-			if redirect {
-				w.Header().Set("Location", "/#invalid_domain")
-				w.WriteHeader(302)
-			} else {
-				api.writeError(w, "invalid domain")
-			}
-			return
-		}
-
-		var domain Domain
-		err = api.db.FirstOrCreate(&domain, &Domain{Domain: strings.ToLower(d)}).Error
-		if err != nil {
-			api.logError(w, err)
-			return
-		}
-
-		var watch Watch
-		err = api.db.FirstOrCreate(&watch, &Watch{DomainID: domain.ID, EmailID: email.ID}).Error
-		if err != nil {
-			api.logError(w, err)
-			return
-		}
-	}
-
-	if redirect {
-		w.Header().Set("Location", "/#success")
-		w.WriteHeader(302)
-	} else {
-		api.writeSuccessResponse(w, nil)
-	}
-}
-
-func (api *API) unwatchRoute(w http.ResponseWriter, r *http.Request) {
-	if strings.EqualFold(r.Method, "POST") == false {
-		api.writeError(w, "Must be a POST request")
-		return
-	}
-
-	var err error
-	apiRequest := struct {
-		Domains []string
-		Email   string
-	}{}
-	redirect := false
-	contentType := r.Header.Get("Content-Type")
-	if strings.EqualFold(contentType, "application/json") {
-		decoder := json.NewDecoder(r.Body)
-		err = decoder.Decode(&apiRequest)
-		if err != nil {
-			api.writeError(w, "invalid request")
-			return
-		}
-	} else if strings.EqualFold(contentType, "application/x-www-form-urlencoded") {
-		d := r.FormValue("domain")
-		if len(d) <= 0 {
-			if redirect {
-				w.Header().Set("Location", "/#invalid_domain")
-				w.WriteHeader(302)
-			} else {
-				api.writeError(w, "invalid domain")
-			}
-			return
-		}
-		apiRequest.Domains = []string{d}
-		apiRequest.Email = r.FormValue("email")
-		redirect = true
-	} else {
-		api.writeError(w, "invalid request")
-		return
-	}
-
-	apiRequest.Email = strings.ToLower(apiRequest.Email)
-
-	if !govalidator.IsEmail(apiRequest.Email) {
-		if redirect {
-			w.Header().Set("Location", "/#invalid_email")
-			w.WriteHeader(302)
-		} else {
-			api.writeError(w, "invalid email")
-		}
-		return
-	}
-
-	var email Email
-	db := api.db.Where(&Email{Email: apiRequest.Email}).First(&email)
-	if db.Error != nil {
-		if db.RecordNotFound() {
-			if redirect {
-				w.Header().Set("Location", "/#success")
-				w.WriteHeader(302)
-			} else {
-				api.writeSuccessResponse(w, nil)
-			}
-		} else {
-			api.logError(w, err)
-		}
-		return
-	}
-
-	for _, d := range apiRequest.Domains {
-		if !govalidator.IsDNSName(d) {
-			if redirect {
-				w.Header().Set("Location", "/#invalid_domain")
-				w.WriteHeader(302)
-			} else {
-				api.writeError(w, "invalid domain")
-			}
-			return
-		}
-		var domain Domain
-		db = api.db.Where(&Domain{Domain: strings.ToLower(d)}).First(&domain)
-		if db.Error != nil {
-			if db.RecordNotFound() {
-				if redirect {
-					w.Header().Set("Location", "/#success")
-					w.WriteHeader(302)
-				} else {
-					api.writeSuccessResponse(w, nil)
-				}
-			} else {
-				api.logError(w, err)
-			}
-			return
-		}
-
-		var watch Watch
-		db = api.db.Where(&Watch{DomainID: domain.ID, EmailID: email.ID}).First(&watch)
-		if db.Error != nil {
-			if db.RecordNotFound() {
-				if redirect {
-					w.Header().Set("Location", "/#success")
-					w.WriteHeader(302)
-				} else {
-					api.writeSuccessResponse(w, nil)
-				}
-			} else {
-				api.logError(w, err)
-			}
-			return
-		}
-
-		err = api.db.Delete(&watch).Error
-		if err != nil {
-			api.logError(w, err)
-			return
-		}
-	}
-	if redirect {
-		w.Header().Set("Location", "/#success")
-		w.WriteHeader(302)
-	} else {
-		api.writeSuccessResponse(w, nil)
-	}
-}
+package api1
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+)
+
+type Domain struct {
+	ID          uint   `gorm:"primary_key;not null"`
+	Domain      string `gorm:"type:char(255);unique;not null"`
+	LastChecked int64  `gorm:"not null"`
+	Verdict     string `gorm:"type:char(32);not null;default:''"` // of the last check, empty if it was not checked yet
+	LeaseOwner  string `gorm:"type:char(255);not null;default:''"`
+	LeaseUntil  int64  `gorm:"not null;default:0"`
+	CreatedAt   time.Time
+}
+
+type Email struct {
+	ID         uint   `gorm:"primary_key;not null"`
+	AccountID  uint   `gorm:"not null;default:0;index"`
+	Email      string `gorm:"type:char(255);unique;not null"`
+	Locale     string `gorm:"type:char(16);not null;default:''"`
+	Digest     string `gorm:"type:char(16);not null;default:'instant'"`
+	Timezone   string `gorm:"type:char(64);not null;default:''"`  // IANA name, UTC if empty
+	QuietHours string `gorm:"type:char(255);not null;default:''"` // e.g. 22:00-07:00,12:00-13:00
+	Bounces    int    `gorm:"not null;default:0"`
+	Suspended  bool   `gorm:"not null;default:false"` // no mails are sent after too many hard bounces
+	LastBounce *time.Time
+	CreatedAt  time.Time
+}
+
+type Watch struct {
+	DomainID  uint   `gorm:"not null"`
+	EmailID   uint   `gorm:"not null"`
+	Channels  string `gorm:"type:char(255);not null;default:'email'"`
+	Pending   bool   `gorm:"not null;default:false"` // not confirmed by the owner of the email yet
+	CreatedAt time.Time
+}
+
+func (api *API) watchRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST request")
+		return
+	}
+
+	var err error
+	apiRequest := struct {
+		Domains  []string
+		Email    string
+		Channels []string
+		Locale   string
+	}{}
+	redirect := false
+	contentType := r.Header.Get("Content-Type")
+	if strings.EqualFold(contentType, "application/json") {
+		decoder := json.NewDecoder(r.Body)
+		err = decoder.Decode(&apiRequest)
+		if err != nil {
+			api.writeError(w, "invalid request")
+			return
+		}
+	} else if strings.EqualFold(contentType, "application/x-www-form-urlencoded") {
+		d := r.FormValue("domain")
+		if len(d) <= 0 {
+			if redirect {
+				w.Header().Set("Location", "/#invalid_domain")
+				w.WriteHeader(302)
+			} else {
+				api.writeError(w, "invalid domain")
+			}
+			return
+		}
+		apiRequest.Domains = []string{d}
+		apiRequest.Email = r.FormValue("email")
+		apiRequest.Channels = r.Form["channel"]
+		apiRequest.Locale = r.FormValue("locale")
+		redirect = true
+	} else {
+		api.writeError(w, "invalid request")
+		return
+	}
+
+	// a logged in user watches with the email of the account
+	account, ok := api.requestAccount(w, r, ScopeWatchWrite)
+	if !ok {
+		return
+	}
+	if account != nil {
+		apiRequest.Email = account.Email
+	}
+
+	apiRequest.Email = strings.ToLower(apiRequest.Email)
+
+	if !govalidator.IsEmail(apiRequest.Email) {
+		if redirect {
+			w.Header().Set("Location", "/#invalid_email")
+			w.WriteHeader(302)
+		} else {
+			api.writeError(w, "invalid email")
+		}
+		return
+	}
+
+	channels, err := api.parseChannels(apiRequest.Channels)
+	if err != nil {
+		if redirect {
+			w.Header().Set("Location", "/#invalid_channel")
+			w.WriteHeader(302)
+		} else {
+			api.writeError(w, err.Error())
+		}
+		return
+	}
+
+	if apiRequest.Locale == "" {
+		apiRequest.Locale = requestLocale(r)
+	} else if apiRequest.Locale, ok = normalizeLocale(apiRequest.Locale); !ok {
+		if redirect {
+			w.Header().Set("Location", "/#invalid_locale")
+			w.WriteHeader(302)
+		} else {
+			api.writeError(w, "invalid locale")
+		}
+		return
+	}
+
+	var email Email
+	err = api.db.Where(&Email{Email: apiRequest.Email}).Attrs(&Email{Locale: apiRequest.Locale}).FirstOrCreate(&email).Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	pending := false
+	for _, d := range apiRequest.Domains {
+		if !govalidator.IsDNSName(d) {
+			if redirect {
+				w.Header().Set("Location", "/#invalid_domain")
+				w.WriteHeader(302)
+			} else {
+				api.writeError(w, "invalid domain")
+			}
+			return
+		}
+
+		var domain Domain
+		err = api.db.FirstOrCreate(&domain, &Domain{Domain: strings.ToLower(d)}).Error
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+
+		// new watches stay pending until the owner of the email confirms them,
+		// a logged in user already proved the ownership and may change the channels of existing watches
+		query := api.db.Where(&Watch{DomainID: domain.ID, EmailID: email.ID})
+		if account != nil {
+			query = query.Assign(map[string]interface{}{"channels": channels, "pending": false})
+		} else {
+			query = query.Attrs(&Watch{Channels: channels, Pending: true})
+		}
+		var watch Watch
+		err = query.FirstOrCreate(&watch).Error
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+		if watch.Pending {
+			pending = true
+		}
+	}
+
+	if pending {
+		err = api.enqueueMail(api.db, KindConfirm, &email)
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+	}
+
+	if redirect {
+		if pending {
+			w.Header().Set("Location", "/#confirm")
+		} else {
+			w.Header().Set("Location", "/#success")
+		}
+		w.WriteHeader(302)
+	} else {
+		api.writeSuccessResponse(w, &struct{ Pending bool }{pending})
+	}
+}
+
+func (api *API) unwatchRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST request")
+		return
+	}
+
+	var err error
+	apiRequest := struct {
+		Domains []string
+		Email   string
+		Token   string
+	}{}
+	redirect := false
+	contentType := r.Header.Get("Content-Type")
+	if strings.EqualFold(contentType, "application/json") {
+		decoder := json.NewDecoder(r.Body)
+		err = decoder.Decode(&apiRequest)
+		if err != nil {
+			api.writeError(w, "invalid request")
+			return
+		}
+	} else if strings.EqualFold(contentType, "application/x-www-form-urlencoded") {
+		d := r.FormValue("domain")
+		if len(d) <= 0 {
+			if redirect {
+				w.Header().Set("Location", "/#invalid_domain")
+				w.WriteHeader(302)
+			} else {
+				api.writeError(w, "invalid domain")
+			}
+			return
+		}
+		apiRequest.Domains = []string{d}
+		apiRequest.Email = r.FormValue("email")
+		apiRequest.Token = r.FormValue("token")
+		redirect = true
+	} else {
+		api.writeError(w, "invalid request")
+		return
+	}
+
+	// a logged in user removes the watches of the account without a token
+	account, ok := api.requestAccount(w, r, ScopeWatchWrite)
+	if !ok {
+		return
+	}
+	if account != nil {
+		apiRequest.Email = account.Email
+	}
+
+	apiRequest.Email = strings.ToLower(apiRequest.Email)
+
+	if !govalidator.IsEmail(apiRequest.Email) {
+		if redirect {
+			w.Header().Set("Location", "/#invalid_email")
+			w.WriteHeader(302)
+		} else {
+			api.writeError(w, "invalid email")
+		}
+		return
+	}
+
+	var email Email
+	db := api.db.Where(&Email{Email: apiRequest.Email}).First(&email)
+	if db.Error != nil {
+		if db.RecordNotFound() {
+			if redirect {
+				w.Header().Set("Location", "/#success")
+				w.WriteHeader(302)
+			} else {
+				api.writeSuccessResponse(w, nil)
+			}
+		} else {
+			api.logError(w, db.Error)
+		}
+		return
+	}
+
+	// without a token the owner of the email gets a mail with links to remove the watches
+	if apiRequest.Token == "" && account == nil {
+		err = api.enqueueMail(api.db, KindUnwatch, &email)
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+		if redirect {
+			w.Header().Set("Location", "/#unwatch_mail")
+			w.WriteHeader(302)
+		} else {
+			api.writeSuccessResponse(w, &struct{ Sent bool }{true})
+		}
+		return
+	}
+
+	tokenDomain := ""
+	if account == nil {
+		var tokenEmail string
+		tokenEmail, tokenDomain, err = api.verifyUnwatchToken(apiRequest.Token)
+		if err != nil || tokenEmail != email.Email {
+			api.writeAccessDenied(w)
+			return
+		}
+	}
+
+	for _, d := range apiRequest.Domains {
+		if !govalidator.IsDNSName(d) {
+			if redirect {
+				w.Header().Set("Location", "/#invalid_domain")
+				w.WriteHeader(302)
+			} else {
+				api.writeError(w, "invalid domain")
+			}
+			return
+		}
+		if tokenDomain != "" && !strings.EqualFold(tokenDomain, d) {
+			api.writeAccessDenied(w)
+			return
+		}
+		var domain Domain
+		db = api.db.Where(&Domain{Domain: strings.ToLower(d)}).First(&domain)
+		if db.Error != nil {
+			if db.RecordNotFound() {
+				if redirect {
+					w.Header().Set("Location", "/#success")
+					w.WriteHeader(302)
+				} else {
+					api.writeSuccessResponse(w, nil)
+				}
+			} else {
+				api.logError(w, db.Error)
+			}
+			return
+		}
+
+		var watch Watch
+		db = api.db.Where(&Watch{DomainID: domain.ID, EmailID: email.ID}).First(&watch)
+		if db.Error != nil {
+			if db.RecordNotFound() {
+				if redirect {
+					w.Header().Set("Location", "/#success")
+					w.WriteHeader(302)
+				} else {
+					api.writeSuccessResponse(w, nil)
+				}
+			} else {
+				api.logError(w, db.Error)
+			}
+			return
+		}
+
+		err = api.db.Where(&Watch{DomainID: domain.ID, EmailID: email.ID}).Delete(&Watch{}).Error
+		if err != nil {
+			api.logError(w, err)
+			return
+		}
+	}
+	if redirect {
+		w.Header().Set("Location", "/#success")
+		w.WriteHeader(302)
+	} else {
+		api.writeSuccessResponse(w, nil)
+	}
+}
@@ -0,0 +1,87 @@
+package api1
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWatchChannels(t *testing.T) {
+	api := newTestAPI(t, map[string]interface{}{
+		"Channels": map[string]interface{}{
+			"ops": map[string]interface{}{"Type": "webhook", "URL": "https://hooks.example.com/domwatch"},
+		},
+	})
+	channels := func() string {
+		var watch Watch
+		if err := api.db.First(&watch).Error; err != nil {
+			t.Fatal(err)
+		}
+		return watch.Channels
+	}
+	watch := func(channel string, header http.Header) {
+		w := api.request("POST", "/api1/watch", map[string]interface{}{
+			"Email":    "a@example.com",
+			"Domains":  []string{"a-example.com"},
+			"Channels": []string{channel},
+		}, header)
+		if w.Code != 200 {
+			t.Fatal(w.Body.String())
+		}
+	}
+
+	header := api.login("a@example.com")
+	watch(EmailChannel, header)
+	if channels() != EmailChannel {
+		t.Fatal(channels())
+	}
+
+	// anonymous requests can not change the channels of an existing watch
+	watch("ops", nil)
+	if channels() != EmailChannel {
+		t.Fatal(channels())
+	}
+
+	watch("ops", header)
+	if channels() != "ops" {
+		t.Fatal(channels())
+	}
+}
+
+func TestWatchLocale(t *testing.T) {
+	api := newTestAPI(t, nil)
+	locale := func(email string) string {
+		var record Email
+		if err := api.db.Where(&Email{Email: email}).First(&record).Error; err != nil {
+			t.Fatal(err)
+		}
+		return record.Locale
+	}
+
+	for _, invalid := range []string{"de-AT-x-private-use", "<script>", "d", "de-", "1234"} {
+		w := api.request("POST", "/api1/watch", map[string]interface{}{"Email": "a@example.com", "Domains": []string{"a-example.com"}, "Locale": invalid}, nil)
+		if w.Code != 400 {
+			t.Fatal(invalid, w.Code, w.Body.String())
+		}
+	}
+	w := api.request("POST", "/api1/watch", "domain=a-example.com&email=a%40example.com&locale=%3Cscript%3E", nil)
+	if w.Code != 302 || w.Header().Get("Location") != "/#invalid_locale" {
+		t.Fatal(w.Code, w.Header())
+	}
+
+	w = api.request("POST", "/api1/watch", map[string]interface{}{"Email": "a@example.com", "Domains": []string{"a-example.com"}, "Locale": " de_AT "}, nil)
+	if w.Code != 200 || locale("a@example.com") != "de-at" {
+		t.Fatal(w.Body.String(), locale("a@example.com"))
+	}
+
+	// the Accept-Language header is only stored if it is a language tag
+	for email, language := range map[string]string{"b@example.com": "fr-CH, fr;q=0.9", "c@example.com": "*", "d@example.com": strings.Repeat("de-at-", 4)} {
+		w = api.request("POST", "/api1/watch", map[string]interface{}{"Email": email, "Domains": []string{"a-example.com"}}, http.Header{"Accept-Language": {language}})
+		if w.Code != 200 {
+			t.Fatal(w.Body.String())
+		}
+	}
+	if locale("b@example.com") != "fr-ch" || locale("c@example.com") != "" || locale("d@example.com") != "" {
+		t.Fatal(locale("b@example.com"), locale("c@example.com"), locale("d@example.com"))
+	}
+}
@@ -0,0 +1,253 @@
+package api1
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// VerdictError is stored when the last check of a domain failed
+const VerdictError = "error"
+
+const (
+	WatchPending   = "pending"   // waiting for the confirmation of the email
+	WatchActive    = "active"    // the domain is checked every CheckInterval
+	WatchSuspended = "suspended" // the email bounced too often, no notifications are sent
+)
+
+const (
+	watchesPerPage    = 50
+	maxWatchesPerPage = 200
+)
+
+type WatchInfo struct {
+	Domain      string
+	Channels    []string
+	Status      string
+	Verdict     string
+	CreatedAt   time.Time
+	LastChecked *time.Time
+	NextCheck   *time.Time // nil if the watch is not checked
+}
+
+// accountWatches returns all watches of the email of an account
+func (api *API) accountWatches(account *Account) ([]*WatchInfo, error) {
+	email, err := api.accountEmail(account)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := api.db.Table("watches").
+		Select("domains.domain, domains.last_checked, domains.verdict, watches.channels, watches.pending, watches.created_at").
+		Joins("JOIN domains ON domains.id = watches.domain_id").
+		Where("watches.email_id = ?", email.ID).
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now().UTC()
+	watches := []*WatchInfo{}
+	for rows.Next() {
+		var watch WatchInfo
+		var lastChecked int64
+		var channels string
+		var pending bool
+		err = rows.Scan(&watch.Domain, &lastChecked, &watch.Verdict, &channels, &pending, &watch.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		watch.Channels = strings.Split(channels, ",")
+
+		switch {
+		case pending:
+			watch.Status = WatchPending
+		case email.Suspended:
+			watch.Status = WatchSuspended
+		default:
+			watch.Status = WatchActive
+		}
+		if lastChecked > 0 {
+			t := time.Unix(lastChecked, 0).UTC()
+			watch.LastChecked = &t
+		}
+		// pending watches are not checked, suspended ones are checked but nobody is notified
+		if watch.Status != WatchPending {
+			next := time.Unix(lastChecked, 0).UTC().Add(api.config.intervalDuration)
+			if next.Before(now) {
+				next = now
+			}
+			watch.NextCheck = &next
+		}
+		watches = append(watches, &watch)
+	}
+	return watches, rows.Err()
+}
+
+// sortWatches sorts by domain, created or checked, a leading - sorts descending
+func sortWatches(watches []*WatchInfo, by string) bool {
+	desc := strings.HasPrefix(by, "-")
+	by = strings.TrimPrefix(by, "-")
+
+	var less func(a, b *WatchInfo) bool
+	switch by {
+	case "", "domain":
+		less = func(a, b *WatchInfo) bool { return a.Domain < b.Domain }
+	case "created":
+		less = func(a, b *WatchInfo) bool { return a.CreatedAt.Before(b.CreatedAt) }
+	case "checked":
+		less = func(a, b *WatchInfo) bool {
+			if a.LastChecked == nil || b.LastChecked == nil {
+				return a.LastChecked == nil && b.LastChecked != nil
+			}
+			return a.LastChecked.Before(*b.LastChecked)
+		}
+	default:
+		return false
+	}
+	sort.SliceStable(watches, func(i, j int) bool {
+		if desc {
+			return less(watches[j], watches[i])
+		}
+		return less(watches[i], watches[j])
+	})
+	return true
+}
+
+// Page returns the bounds of a page in a list of total items, pages after the last one are empty
+func Page(total int, page int, perPage int) (int, int) {
+	// the bound is checked before multiplying to avoid an overflow
+	start := total
+	if page-1 <= total/perPage {
+		start = (page - 1) * perPage
+		if start > total {
+			start = total
+		}
+	}
+	end := start + perPage
+	if end > total {
+		end = total
+	}
+	return start, end
+}
+
+// watchesRoute lists the watches of the account.
+// Query parameters: q (part of the domain), status, channel, sort, page and per_page.
+func (api *API) watchesRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false {
+		api.writeError(w, "Must be a GET request")
+		return
+	}
+	account, ok := api.requestAccount(w, r, ScopeWatchRead)
+	if !ok {
+		return
+	}
+	if account == nil {
+		api.writeAccessDenied(w)
+		return
+	}
+
+	query := r.URL.Query()
+	page := 1
+	if s := query.Get("page"); s != "" {
+		p, err := strconv.Atoi(s)
+		if err != nil || p < 1 {
+			api.writeError(w, "invalid page")
+			return
+		}
+		page = p
+	}
+	perPage := watchesPerPage
+	if s := query.Get("per_page"); s != "" {
+		p, err := strconv.Atoi(s)
+		if err != nil || p < 1 || p > maxWatchesPerPage {
+			api.writeError(w, "invalid per_page")
+			return
+		}
+		perPage = p
+	}
+	status := strings.ToLower(query.Get("status"))
+	switch status {
+	case "", WatchPending, WatchActive, WatchSuspended:
+	default:
+		api.writeError(w, "invalid status")
+		return
+	}
+
+	watches, err := api.accountWatches(account)
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	if !sortWatches(watches, strings.ToLower(query.Get("sort"))) {
+		api.writeError(w, "invalid sort")
+		return
+	}
+
+	q := strings.ToLower(query.Get("q"))
+	channel := query.Get("channel")
+	filtered := watches[:0]
+	for _, watch := range watches {
+		if q != "" && !strings.Contains(watch.Domain, q) {
+			continue
+		}
+		if status != "" && watch.Status != status {
+			continue
+		}
+		if channel != "" {
+			found := false
+			for _, c := range watch.Channels {
+				found = found || c == channel
+			}
+			if !found {
+				continue
+			}
+		}
+		filtered = append(filtered, watch)
+	}
+
+	total := len(filtered)
+	start, end := Page(total, page, perPage)
+
+	api.writeSuccessResponse(w, &struct {
+		Total   int
+		Page    int
+		PerPage int
+		Watches []*WatchInfo
+	}{total, page, perPage, filtered[start:end]})
+}
+
+// watchDetailRoute returns a single watch of the account
+func (api *API) watchDetailRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false {
+		api.writeError(w, "Must be a GET request")
+		return
+	}
+	account, ok := api.requestAccount(w, r, ScopeWatchRead)
+	if !ok {
+		return
+	}
+	if account == nil {
+		api.writeAccessDenied(w)
+		return
+	}
+
+	watches, err := api.accountWatches(account)
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+	domain := strings.ToLower(mux.Vars(r)["domain"])
+	for _, watch := range watches {
+		if watch.Domain == domain {
+			api.writeSuccessResponse(w, watch)
+			return
+		}
+	}
+	api.writeNotFound(w)
+}
@@ -0,0 +1,44 @@
+package api1
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+func TestWatchesPages(t *testing.T) {
+	api := newTestAPI(t, nil)
+	header := api.login("a@example.com")
+	w := api.request("POST", "/api1/watch", map[string]interface{}{"Domains": []string{"a-example.com", "b-example.com", "c-example.com"}}, header)
+	if w.Code != 200 {
+		t.Fatal(w.Body.String())
+	}
+
+	tests := []struct {
+		page    string
+		domains int
+	}{
+		{"1", 2},
+		{"2", 1},
+		{"3", 0},
+		{strconv.Itoa(maxInt), 0},
+	}
+	for _, test := range tests {
+		w = api.request("GET", "/api1/watches?sort=domain&per_page=2&page="+test.page, nil, header)
+		if w.Code != 200 {
+			t.Fatalf("page %s: %d %s", test.page, w.Code, w.Body.String())
+		}
+		var list struct {
+			Total   int
+			Watches []*WatchInfo
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		if list.Total != 3 || len(list.Watches) != test.domains {
+			t.Fatalf("page %s: %s", test.page, w.Body.String())
+		}
+	}
+}
+
+const maxInt = int(^uint(0) >> 1)
@@ -0,0 +1,95 @@
+package api1
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type webhookPayload struct {
+	Domain     string    `json:"domain"`
+	Verdict    string    `json:"verdict"`
+	Evidence   []string  `json:"evidence"`
+	Escalation int       `json:"escalation,omitempty"`
+	Ack        string    `json:"ack,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// webhookNotifier posts a json payload to an url, the timestamp and the body
+// are signed with HMAC-SHA256 if a secret is configured
+type webhookNotifier struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func newWebhookNotifier(channel *ChannelConfig) (Notifier, error) {
+	n := &webhookNotifier{
+		url:    *channel.URL,
+		client: &http.Client{Timeout: channel.timeout},
+	}
+	if channel.Secret != nil {
+		n.secret = *channel.Secret
+	}
+	return n, nil
+}
+
+// signPayload signs "<timestamp>.<body>", receivers reject old timestamps
+// so a captured request can not be replayed later
+func signPayload(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *webhookNotifier) Notify(notification *Notification) error {
+	body, err := json.Marshal(&webhookPayload{
+		Domain:     notification.Domain,
+		Verdict:    notification.Verdict,
+		Evidence:   notification.Evidence,
+		Escalation: notification.Step,
+		Ack:        notification.Ack,
+		Time:       notification.Time,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Domwatch-Delivery", strconv.FormatUint(uint64(notification.MessageID), 10))
+	if n.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Domwatch-Timestamp", timestamp)
+		req.Header.Set("X-Domwatch-Signature", signPayload(n.secret, timestamp, body))
+	}
+
+	return doWebhookRequest(n.client, req)
+}
+
+// doWebhookRequest sends the request and fails for every non 2xx status
+func doWebhookRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s responded with %s: %s", req.URL.Host, resp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,53 @@
+package api1
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	t.Cleanup(server.Close)
+	api := newTestAPI(t, map[string]interface{}{
+		"Channels": map[string]interface{}{
+			"ops": map[string]interface{}{"Type": "webhook", "URL": server.URL, "Secret": "secret"},
+		},
+	})
+
+	notification := &Notification{MessageID: 7, Kind: KindAvailable, Domain: "example.com", Verdict: "available"}
+	if err := api.notifiers["ops"].Notify(notification); err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("X-Domwatch-Delivery") != "7" {
+		t.Fatal(header)
+	}
+
+	// the receiver checks the age of the timestamp and the signature of timestamp and body
+	timestamp, err := strconv.ParseInt(header.Get("X-Domwatch-Timestamp"), 10, 64)
+	if err != nil || time.Since(time.Unix(timestamp, 0)) > 5*time.Minute {
+		t.Fatal(header.Get("X-Domwatch-Timestamp"), err)
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(header.Get("X-Domwatch-Timestamp") + "."))
+	mac.Write(body)
+	if !hmac.Equal([]byte(header.Get("X-Domwatch-Signature")), []byte("sha256="+hex.EncodeToString(mac.Sum(nil)))) {
+		t.Fatal(header.Get("X-Domwatch-Signature"))
+	}
+
+	// a replayed body with a new timestamp does not match the signature
+	if signPayload("secret", strconv.FormatInt(timestamp+600, 10), body) == header.Get("X-Domwatch-Signature") {
+		t.Fatal("the signature does not cover the timestamp")
+	}
+}
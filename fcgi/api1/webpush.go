@@ -0,0 +1,401 @@
+package api1
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/jinzhu/gorm"
+)
+
+// WebPushChannel is the name of the built in web push channel,
+// it is available if VAPID keys are configured
+const WebPushChannel = "webpush"
+
+// PushSubscription is a browser that subscribed to the notifications of an email
+type PushSubscription struct {
+	ID        uint   `gorm:"primary_key;not null"`
+	EmailID   uint   `gorm:"not null;index"`
+	Endpoint  string `gorm:"type:varchar(1024);unique;not null"`
+	P256dh    string `gorm:"type:char(255);not null"`
+	Auth      string `gorm:"type:char(255);not null"`
+	Pending   bool   `gorm:"not null;default:false"` // not confirmed by the owner of the email yet
+	CreatedAt time.Time
+}
+
+type vapidKey struct {
+	private   *ecdsa.PrivateKey
+	publicKey string
+}
+
+// GenerateVAPIDKeys creates a new key pair for the WebPush config
+func GenerateVAPIDKeys() (privateKey string, publicKey string, err error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(key.Bytes()), base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+func parseVAPIDKey(privateKey string) (*vapidKey, error) {
+	raw, err := decodeBase64URL(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	pub := key.PublicKey().Bytes()
+	return &vapidKey{
+		private: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(pub[1:33]),
+				Y:     new(big.Int).SetBytes(pub[33:]),
+			},
+			D: new(big.Int).SetBytes(raw),
+		},
+		publicKey: base64.RawURLEncoding.EncodeToString(pub),
+	}, nil
+}
+
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// token creates the VAPID JWT for the origin of an endpoint
+func (key *vapidKey) token(endpoint string, subject string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, key.private, digest[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// encryptPushPayload encrypts a payload for a subscription as described in RFC 8291 (aes128gcm)
+func encryptPushPayload(sub *PushSubscription, payload []byte) ([]byte, error) {
+	uaPublicRaw, err := decodeBase64URL(sub.P256dh)
+	if err != nil {
+		return nil, err
+	}
+	authSecret, err := decodeBase64URL(sub.Auth)
+	if err != nil {
+		return nil, err
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicRaw)
+	if err != nil {
+		return nil, err
+	}
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+	ecdhSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	// HKDF with a single block of output, which is all that is needed here
+	keyInfo := append(append(append([]byte("WebPush: info\x00"), uaPublicRaw...), asPublic...), 1)
+	ikm := hmacSHA256(hmacSHA256(authSecret, ecdhSecret), keyInfo)
+	prk := hmacSHA256(salt, ikm)
+	cek := hmacSHA256(prk, []byte("Content-Encoding: aes128gcm\x00\x01"))[:16]
+	nonce := hmacSHA256(prk, []byte("Content-Encoding: nonce\x00\x01"))[:12]
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	body.Write(salt)
+	binary.Write(&body, binary.BigEndian, uint32(4096))
+	body.WriteByte(byte(len(asPublic)))
+	body.Write(asPublic)
+	// 0x02 marks the last (and only) record
+	body.Write(gcm.Seal(nil, nonce, append(payload, 2), nil))
+	return body.Bytes(), nil
+}
+
+type webPushNotifier struct {
+	api     *API
+	key     *vapidKey
+	subject string
+	client  *http.Client
+}
+
+func (n *webPushNotifier) Notify(notification *Notification) error {
+	var email Email
+	err := n.api.db.Where(&Email{Email: notification.Recipient}).First(&email).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+	var subscriptions []PushSubscription
+	err = n.api.db.Where("email_id = ? AND pending = ?", email.ID, false).Find(&subscriptions).Error
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(&struct {
+		Title  string
+		Body   string
+		Domain string
+		Ack    string `json:",omitempty"`
+	}{pushTitle(notification), pushText(notification), notification.Domain, notification.Ack})
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for i := range subscriptions {
+		err = n.send(&subscriptions[i], payload)
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (n *webPushNotifier) send(sub *PushSubscription, payload []byte) error {
+	body, err := encryptPushPayload(sub, payload)
+	if err != nil {
+		return err
+	}
+	token, err := n.key.token(sub.Endpoint, n.subject)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", "86400")
+	req.Header.Set("Urgency", "high")
+	req.Header.Set("Authorization", fmt.Sprintf("vapid t=%s, k=%s", token, n.key.publicKey))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == 404 || resp.StatusCode == 410:
+		// the browser unsubscribed
+		return n.api.db.Delete(sub).Error
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("%s responded with %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+func (api *API) pushKeyRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "GET") == false {
+		api.writeError(w, "Must be a GET request")
+		return
+	}
+	if api.config.WebPush.key == nil {
+		api.writeNotFound(w)
+		return
+	}
+	api.writeSuccessResponse(w, &struct{ PublicKey string }{api.config.WebPush.key.publicKey})
+}
+
+type pushSubscriptionRequest struct {
+	Email        string
+	Subscription struct {
+		Endpoint string `json:"endpoint"`
+		Keys     struct {
+			P256dh string `json:"p256dh"`
+			Auth   string `json:"auth"`
+		} `json:"keys"`
+	}
+}
+
+// pushSubscribeRoute stores the subscription of a browser. Subscriptions of logged in users are active right away,
+// anonymous ones are pending until the owner of the email confirms them like a watch.
+func (api *API) pushSubscribeRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST request")
+		return
+	}
+	if api.config.WebPush.key == nil {
+		api.writeNotFound(w)
+		return
+	}
+
+	var apiRequest pushSubscriptionRequest
+	err := json.NewDecoder(r.Body).Decode(&apiRequest)
+	if err != nil {
+		api.writeError(w, "invalid request")
+		return
+	}
+
+	// a logged in user subscribes with the email of the account
+	account, ok := api.requestAccount(w, r, ScopeWatchWrite)
+	if !ok {
+		return
+	}
+	if account != nil {
+		apiRequest.Email = account.Email
+	}
+
+	apiRequest.Email = strings.ToLower(apiRequest.Email)
+	if !govalidator.IsEmail(apiRequest.Email) {
+		api.writeError(w, "invalid email")
+		return
+	}
+	sub := apiRequest.Subscription
+	if !govalidator.IsURL(sub.Endpoint) || !strings.HasPrefix(sub.Endpoint, "https://") {
+		api.writeError(w, "invalid endpoint")
+		return
+	}
+	if _, err = decodeBase64URL(sub.Keys.P256dh); err != nil || sub.Keys.P256dh == "" {
+		api.writeError(w, "invalid keys")
+		return
+	}
+	if _, err = decodeBase64URL(sub.Keys.Auth); err != nil || sub.Keys.Auth == "" {
+		api.writeError(w, "invalid keys")
+		return
+	}
+
+	var email Email
+	err = api.db.FirstOrCreate(&email, &Email{Email: apiRequest.Email}).Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	var existing PushSubscription
+	found := true
+	err = api.db.Where(&PushSubscription{Endpoint: sub.Endpoint}).First(&existing).Error
+	if err != nil {
+		if !gorm.IsRecordNotFoundError(err) {
+			api.logError(w, err)
+			return
+		}
+		found = false
+		err = nil
+	}
+	if found && !existing.Pending && account == nil {
+		// an anonymous request must not move a confirmed subscription to another address
+		if existing.EmailID != email.ID {
+			api.writeAccessDenied(w)
+			return
+		}
+		api.writeSuccessResponse(w, nil)
+		return
+	}
+
+	tx := api.db.Begin()
+	if found {
+		// a new pending subscription needs a new confirmation, so it is created again
+		err = tx.Delete(&existing).Error
+	}
+	if err == nil {
+		err = tx.Create(&PushSubscription{
+			EmailID:  email.ID,
+			Endpoint: sub.Endpoint,
+			P256dh:   sub.Keys.P256dh,
+			Auth:     sub.Keys.Auth,
+			Pending:  account == nil,
+		}).Error
+	}
+	if err == nil && account == nil {
+		err = api.enqueueMail(tx, KindConfirm, &email)
+	}
+	if err != nil {
+		tx.Rollback()
+		api.logError(w, err)
+		return
+	}
+	err = tx.Commit().Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	api.writeSuccessResponse(w, nil)
+}
+
+func (api *API) pushUnsubscribeRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Method, "POST") == false {
+		api.writeError(w, "Must be a POST request")
+		return
+	}
+
+	var apiRequest pushSubscriptionRequest
+	err := json.NewDecoder(r.Body).Decode(&apiRequest)
+	if err != nil || apiRequest.Subscription.Endpoint == "" {
+		api.writeError(w, "invalid request")
+		return
+	}
+
+	err = api.db.Where(&PushSubscription{Endpoint: apiRequest.Subscription.Endpoint}).Delete(&PushSubscription{}).Error
+	if err != nil {
+		api.logError(w, err)
+		return
+	}
+
+	api.writeSuccessResponse(w, nil)
+}
@@ -0,0 +1,72 @@
+package api1
+
+import (
+	"testing"
+)
+
+func TestPushSubscribe(t *testing.T) {
+	privateKey, _, err := GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := newTestAPI(t, map[string]interface{}{
+		"WebPush": map[string]interface{}{"PrivateKey": privateKey},
+	})
+	subscribe := func(email string, endpoint string) map[string]interface{} {
+		return map[string]interface{}{
+			"Email": email,
+			"Subscription": map[string]interface{}{
+				"endpoint": endpoint,
+				"keys":     map[string]string{"p256dh": "BNcRdreALRFXTkOOUHK1EtK2wtaz5Ry4YfYCAw", "auth": "tBHItJI5svbpez7KI4CCXg"},
+			},
+		}
+	}
+	subscription := func(endpoint string) *PushSubscription {
+		var sub PushSubscription
+		if err := api.db.Where(&PushSubscription{Endpoint: endpoint}).First(&sub).Error; err != nil {
+			t.Fatal(err)
+		}
+		return &sub
+	}
+	emailID := func(address string) uint {
+		var email Email
+		if err := api.db.Where(&Email{Email: address}).First(&email).Error; err != nil {
+			t.Fatal(err)
+		}
+		return email.ID
+	}
+
+	// an anonymous subscription waits for the confirmation of the owner of the address
+	w := api.request("POST", "/api1/push/subscribe", subscribe("victim@example.com", "https://push.example.com/a"), nil)
+	if w.Code != 200 {
+		t.Fatal(w.Body.String())
+	}
+	if sub := subscription("https://push.example.com/a"); !sub.Pending {
+		t.Fatal("anonymous subscription is not pending")
+	}
+	var count int
+	api.db.Model(&Message{}).Where("kind = ? AND recipient = ?", KindConfirm, "victim@example.com").Count(&count)
+	if count != 1 {
+		t.Fatalf("%d confirmation mails", count)
+	}
+
+	// a logged in user subscribes with the address of the account, the subscription is active
+	header := api.login("owner@example.com")
+	w = api.request("POST", "/api1/push/subscribe", subscribe("victim@example.com", "https://push.example.com/b"), header)
+	if w.Code != 200 {
+		t.Fatal(w.Body.String())
+	}
+	sub := subscription("https://push.example.com/b")
+	if sub.Pending || sub.EmailID != emailID("owner@example.com") {
+		t.Fatalf("%+v", sub)
+	}
+
+	// an anonymous request can not move a confirmed subscription to another address
+	w = api.request("POST", "/api1/push/subscribe", subscribe("attacker@example.com", "https://push.example.com/b"), nil)
+	if w.Code != 403 {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	if sub = subscription("https://push.example.com/b"); sub.Pending || sub.EmailID != emailID("owner@example.com") {
+		t.Fatalf("%+v", sub)
+	}
+}
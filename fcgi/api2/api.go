@@ -0,0 +1,232 @@
+package api2
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Eun/domwatch/fcgi/api1"
+	"github.com/gorilla/mux"
+)
+
+const (
+	perPage    = 50
+	maxPerPage = 200
+)
+
+// API serves the resources of version 2, it uses the database and the checker of api1
+type API struct {
+	v1     *api1.API
+	logger *log.Logger
+}
+
+// methods maps the HTTP methods of a resource to their handlers
+type methods map[string]http.HandlerFunc
+
+func NewApi(v1 *api1.API, router *mux.Router, logger *log.Logger) (*API, error) {
+	api := &API{v1: v1, logger: logger}
+
+	router.HandleFunc("/domains", api.resource(methods{"GET": api.listDomains}))
+	router.HandleFunc("/domains/{domain}", api.resource(methods{"GET": api.getDomain}))
+	router.HandleFunc("/watches", api.resource(methods{"GET": api.listWatches, "POST": api.createWatch}))
+	router.HandleFunc("/watches/{domain}", api.resource(methods{"GET": api.getWatch, "PUT": api.putWatch, "DELETE": api.deleteWatch}))
+	router.HandleFunc("/checks/{domain}", api.resource(methods{"GET": api.getCheck}))
+	router.HandleFunc("/events", api.resource(methods{"GET": api.listEvents}))
+	router.HandleFunc("/events/{id:[0-9]+}", api.resource(methods{"GET": api.getEvent}))
+	router.HandleFunc("/openapi.json", api.resource(methods{"GET": api.getOpenAPI}))
+	router.PathPrefix("/").HandlerFunc(api.writeNotFound)
+	return api, nil
+}
+
+// resource dispatches a request by its method, HEAD is served by GET,
+// other methods are answered with 405 and the allowed methods
+func (api *API) resource(handlers methods) http.HandlerFunc {
+	var allowed []string
+	for method := range handlers {
+		allowed = append(allowed, method)
+		if method == "GET" {
+			allowed = append(allowed, "HEAD")
+		}
+	}
+	sort.Strings(allowed)
+	return func(w http.ResponseWriter, r *http.Request) {
+		method := strings.ToUpper(r.Method)
+		if method == "HEAD" {
+			method = "GET"
+		}
+		handler, ok := handlers[method]
+		if !ok {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			api.writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" is not allowed")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// authenticate returns the account of a request, nil for anonymous requests.
+// It writes 401 for invalid keys and 403 for keys without the scope.
+func (api *API) authenticate(w http.ResponseWriter, r *http.Request, scope string) (*api1.Account, bool) {
+	account, err := api.v1.Authenticate(r, scope)
+	switch {
+	case err == api1.ErrScope:
+		api.writeProblem(w, r, http.StatusForbidden, CodeInsufficientScope, "the API key needs the scope "+scope)
+	case err == api1.ErrInvalidKey:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="domwatch", error="invalid_token"`)
+		api.writeProblem(w, r, http.StatusUnauthorized, CodeInvalidKey, "")
+	case err != nil:
+		api.logError(w, r, err)
+	default:
+		return account, true
+	}
+	return nil, false
+}
+
+// account is authenticate for resources that need an account, anonymous requests get 401
+func (api *API) account(w http.ResponseWriter, r *http.Request, scope string) (*api1.Account, bool) {
+	account, ok := api.authenticate(w, r, scope)
+	if ok && account == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="domwatch"`)
+		api.writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "log in or send an API key")
+		return nil, false
+	}
+	return account, ok
+}
+
+// client identifies a request for the rate limits that it shares with api1
+func (api *API) client(w http.ResponseWriter, r *http.Request, scope string) (string, bool) {
+	account, ok := api.authenticate(w, r, scope)
+	if !ok {
+		return "", false
+	}
+	return api1.Client(account, r), true
+}
+
+// etag returns a strong entity tag for a representation
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// representation returns the JSON of a resource and its entity tag
+func representation(v interface{}) ([]byte, string, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, etag(body), nil
+}
+
+// matchETag reports whether a If-Match or If-None-Match header lists the tag, weak tags compare equal
+func matchETag(header string, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeResource writes a resource with its ETag,
+// a GET with a matching If-None-Match gets 304 Not Modified
+func (api *API) writeResource(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	body, tag, err := representation(v)
+	if err != nil {
+		api.logError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", tag)
+	if status == http.StatusOK && (r.Method == "GET" || r.Method == "HEAD") {
+		if header := r.Header.Get("If-None-Match"); header != "" && matchETag(header, tag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// preconditions evaluates If-Match and If-None-Match of a request that changes a resource,
+// current is the resource as it is, nil if it does not exist.
+// If it returns false 412 Precondition Failed was written.
+func (api *API) preconditions(w http.ResponseWriter, r *http.Request, current interface{}) bool {
+	tag := ""
+	if current != nil {
+		_, t, err := representation(current)
+		if err != nil {
+			api.logError(w, r, err)
+			return false
+		}
+		tag = t
+	}
+	if header := r.Header.Get("If-Match"); header != "" {
+		if tag == "" || !matchETag(header, tag) {
+			api.writeProblem(w, r, http.StatusPreconditionFailed, CodePreconditionFailed, "the resource was changed")
+			return false
+		}
+	}
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		if tag != "" && matchETag(header, tag) {
+			api.writeProblem(w, r, http.StatusPreconditionFailed, CodePreconditionFailed, "the resource exists")
+			return false
+		}
+	}
+	return true
+}
+
+// decodeJSON decodes the JSON body of a request, it writes 415 for other content types
+func (api *API) decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if !strings.HasPrefix(strings.ToLower(r.Header.Get("Content-Type")), "application/json") {
+		api.writeProblem(w, r, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "the body must be application/json")
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		api.writeProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+// intParam reads a positive integer query parameter
+func (api *API) intParam(w http.ResponseWriter, r *http.Request, name string, def int, max int) (int, bool) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || (max > 0 && n > max) {
+		api.writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, "invalid "+name)
+		return 0, false
+	}
+	return n, true
+}
+
+// page reads the parameters page and per_page and returns the bounds of the page in a list of total items
+func (api *API) page(w http.ResponseWriter, r *http.Request, total int) (int, int, int, int, bool) {
+	page, ok := api.intParam(w, r, "page", 1, 0)
+	if !ok {
+		return 0, 0, 0, 0, false
+	}
+	size, ok := api.intParam(w, r, "per_page", perPage, maxPerPage)
+	if !ok {
+		return 0, 0, 0, 0, false
+	}
+	start, end := api1.Page(total, page, size)
+	return page, size, start, end, true
+}
+
+// domainVar returns the normalized domain of the route, it writes 404 for invalid names
+func (api *API) domainVar(w http.ResponseWriter, r *http.Request) (string, bool) {
+	domain, ok := api1.NormalizeDomain(mux.Vars(r)["domain"])
+	if !ok {
+		api.writeNotFound(w, r)
+	}
+	return domain, ok
+}
@@ -0,0 +1,108 @@
+package api2
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Eun/domwatch/fcgi/api1"
+	"github.com/Eun/domwatch/fcgi/api1/api1test"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// testAPI serves api1 and api2 with a sqlite database in a temporary directory
+type testAPI struct {
+	t      *testing.T
+	v1     *api1.API
+	db     *gorm.DB
+	router *mux.Router
+}
+
+func newTestAPI(t *testing.T, settings map[string]interface{}) *testAPI {
+	env := api1test.New(t, settings)
+	config, err := api1.NewConfigFromMap(env.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	logger := api1test.Logger(t)
+	v1, err := api1.NewApi(config, env.DB, router.PathPrefix("/api1").Subrouter(), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewApi(v1, router.PathPrefix("/api2").Subrouter(), logger); err != nil {
+		t.Fatal(err)
+	}
+	return &testAPI{t: t, v1: v1, db: env.DB, router: router}
+}
+
+// key creates an account for email and returns an API key of it with all scopes
+func (api *testAPI) key(email string) (*api1.Account, string) {
+	account := api1.Account{Email: email}
+	if err := api.db.Create(&account).Error; err != nil {
+		api.t.Fatal(err)
+	}
+	secret := "dw_test_" + strconv.FormatUint(uint64(account.ID), 10)
+	err := api.db.Create(&api1.APIKey{
+		AccountID: account.ID,
+		Name:      "test",
+		Prefix:    secret[:8],
+		Hash:      api1test.HashKey(secret),
+		Scopes:    api1.ScopeWatchRead + "," + api1.ScopeWatchWrite,
+	}).Error
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	return &account, secret
+}
+
+func (api *testAPI) get(path string, key string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	r.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	api.router.ServeHTTP(w, r)
+	return w
+}
+
+func TestPages(t *testing.T) {
+	api := newTestAPI(t, nil)
+	account, key := api.key("a@example.com")
+	for _, domain := range []string{"a-example.com", "b-example.com", "c-example.com"} {
+		if _, err := api.v1.SetWatch(account, domain, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	maxInt := int(^uint(0) >> 1)
+	tests := []struct {
+		path  string
+		page  int
+		items int
+	}{
+		{"/api2/domains", 1, 2},
+		{"/api2/domains", 2, 1},
+		{"/api2/domains", 3, 0},
+		{"/api2/domains", maxInt, 0},
+		{"/api2/watches", 2, 1},
+		{"/api2/watches", maxInt, 0},
+	}
+	for _, test := range tests {
+		w := api.get(test.path+"?per_page=2&page="+strconv.Itoa(test.page), key)
+		if w.Code != 200 {
+			t.Fatalf("%s page %d: %d %s", test.path, test.page, w.Code, w.Body.String())
+		}
+		var list struct {
+			Total   int
+			Domains []json.RawMessage
+			Watches []json.RawMessage
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		if list.Total != 3 || len(list.Domains)+len(list.Watches) != test.items {
+			t.Fatalf("%s page %d: %s", test.path, test.page, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package api2
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Eun/domwatch"
+	"github.com/Eun/domwatch/fcgi/api1"
+)
+
+// Check is the result of a check, it is cached for Check.CacheTTL
+type Check struct {
+	*domwatch.Result
+	CheckedAt time.Time
+}
+
+// getCheck checks a domain, anonymous clients are limited by their address
+func (api *API) getCheck(w http.ResponseWriter, r *http.Request) {
+	domain, ok := api.domainVar(w, r)
+	if !ok {
+		return
+	}
+	client, ok := api.client(w, r, api1.ScopeCheck)
+	if !ok {
+		return
+	}
+	if !api.v1.Cached(domain) {
+		if ok, retryAfter := api.v1.Allow(client); !ok {
+			api.writeTooManyRequests(w, r, retryAfter)
+			return
+		}
+	}
+
+	result, err := api.v1.Check(domain)
+	if err != nil {
+		// the details of the error stay in the log
+		api.logger.Printf("Error on check of '%s': %s\n", domain, err.Error())
+		api.writeProblem(w, r, http.StatusBadGateway, CodeCheckFailed, "the domain could not be checked")
+		return
+	}
+
+	// clients may reuse the result as long as the daemon does
+	maxAge := int((api.v1.CheckCacheTTL() - time.Since(result.CheckedAt)) / time.Second)
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(maxAge))
+	w.Header().Set("Last-Modified", result.CheckedAt.UTC().Format(http.TimeFormat))
+	api.writeResource(w, r, http.StatusOK, &Check{result.Result, result.CheckedAt})
+}
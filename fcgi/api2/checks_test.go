@@ -0,0 +1,35 @@
+package api2
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckErrors(t *testing.T) {
+	// nothing answers on the DNS server, so checks fail right away
+	api := newTestAPI(t, map[string]interface{}{
+		"DNSServer": "127.0.0.1",
+		"Check":     map[string]interface{}{"Rate": 1, "Burst": 1},
+	})
+	request := func(domain string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		api.router.ServeHTTP(w, httptest.NewRequest("GET", "/api2/checks/"+domain, nil))
+		return w
+	}
+
+	w := request("a-example.com")
+	var problem Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 502 || problem.Type != ProblemTypePrefix+CodeCheckFailed || strings.Contains(w.Body.String(), "127.0.0.1") {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	// the failed check took the only token
+	w = request("b-example.com")
+	if w.Code != 429 || w.Header().Get("Retry-After") == "" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
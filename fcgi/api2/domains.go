@@ -0,0 +1,78 @@
+package api2
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Eun/domwatch"
+	"github.com/Eun/domwatch/fcgi/api1"
+)
+
+// Domain is the state of a watched domain as the daemon knows it from its last check
+type Domain struct {
+	Domain      string
+	Verdict     string // empty if the domain was not checked yet
+	Available   bool
+	LastChecked *time.Time
+	NextCheck   *time.Time // nil if the watch is not checked
+}
+
+func newDomain(watch *api1.WatchInfo) *Domain {
+	return &Domain{
+		Domain:      watch.Domain,
+		Verdict:     watch.Verdict,
+		Available:   watch.Verdict == domwatch.VerdictAvailable,
+		LastChecked: watch.LastChecked,
+		NextCheck:   watch.NextCheck,
+	}
+}
+
+// listDomains returns the domains the account watches, sorted by name
+func (api *API) listDomains(w http.ResponseWriter, r *http.Request) {
+	account, ok := api.account(w, r, api1.ScopeWatchRead)
+	if !ok {
+		return
+	}
+	watches, err := api.v1.Watches(account)
+	if err != nil {
+		api.logError(w, r, err)
+		return
+	}
+	page, size, start, end, ok := api.page(w, r, len(watches))
+	if !ok {
+		return
+	}
+	sortByDomain(watches)
+	domains := []*Domain{}
+	for _, watch := range watches[start:end] {
+		domains = append(domains, newDomain(watch))
+	}
+	api.writeResource(w, r, http.StatusOK, &struct {
+		Total   int
+		Page    int
+		PerPage int
+		Domains []*Domain
+	}{len(watches), page, size, domains})
+}
+
+// getDomain returns a domain the account watches
+func (api *API) getDomain(w http.ResponseWriter, r *http.Request) {
+	account, ok := api.account(w, r, api1.ScopeWatchRead)
+	if !ok {
+		return
+	}
+	name, ok := api.domainVar(w, r)
+	if !ok {
+		return
+	}
+	watch, err := api.v1.Watch(account, name)
+	if err != nil {
+		api.logError(w, r, err)
+		return
+	}
+	if watch == nil {
+		api.writeNotFound(w, r)
+		return
+	}
+	api.writeResource(w, r, http.StatusOK, newDomain(watch))
+}
@@ -0,0 +1,76 @@
+package api2
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Eun/domwatch/fcgi/api1"
+	"github.com/gorilla/mux"
+)
+
+const (
+	eventsLimit    = 50
+	maxEventsLimit = 500
+)
+
+// Event is raised when a watched domain became available
+type Event = api1.Event
+
+// listEvents returns the events of the account after the id in after, the oldest first.
+// Next is the after of the following request, it stays the same if there are no new events.
+func (api *API) listEvents(w http.ResponseWriter, r *http.Request) {
+	account, ok := api.account(w, r, api1.ScopeWatchRead)
+	if !ok {
+		return
+	}
+	after := uint64(0)
+	if s := r.URL.Query().Get("after"); s != "" {
+		var err error
+		after, err = strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			api.writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, "invalid after")
+			return
+		}
+	}
+	limit, ok := api.intParam(w, r, "limit", eventsLimit, maxEventsLimit)
+	if !ok {
+		return
+	}
+
+	events, err := api.v1.Events(account, uint(after), limit)
+	if err != nil {
+		api.logError(w, r, err)
+		return
+	}
+	next := uint(after)
+	if len(events) > 0 {
+		next = events[len(events)-1].ID
+	}
+	api.writeResource(w, r, http.StatusOK, &struct {
+		Events []*Event
+		Next   uint
+	}{events, next})
+}
+
+// getEvent returns an event of the account
+func (api *API) getEvent(w http.ResponseWriter, r *http.Request) {
+	account, ok := api.account(w, r, api1.ScopeWatchRead)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		api.writeNotFound(w, r)
+		return
+	}
+	event, err := api.v1.Event(account, uint(id))
+	if err != nil {
+		api.logError(w, r, err)
+		return
+	}
+	if event == nil {
+		api.writeNotFound(w, r)
+		return
+	}
+	api.writeResource(w, r, http.StatusOK, event)
+}
@@ -0,0 +1,18 @@
+package api2
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+)
+
+// openAPIDocument describes the resources of api2, the tests compare it with the router
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// getOpenAPI serves the OpenAPI 3 document, the servers are relative to it
+func (api *API) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	api.writeResource(w, r, http.StatusOK, json.RawMessage(openAPIDocument))
+}
@@ -0,0 +1,99 @@
+package api2
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestOpenAPIDocument compares the document with the routes and the methods of the router
+func TestOpenAPIDocument(t *testing.T) {
+	api := newTestAPI(t, nil)
+	w := api.get("/api2/openapi.json", "")
+	if w.Code != 200 || w.Header().Get("ETag") == "" || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatal(w.Code, w.Header())
+	}
+	raw := w.Body.Bytes()
+	var document struct {
+		OpenAPI string
+		Servers []struct{ URL string }
+		Paths   map[string]map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.OpenAPI != "3.0.3" || len(document.Servers) != 1 || document.Servers[0].URL != "/api2" {
+		t.Fatal(document.OpenAPI, document.Servers)
+	}
+
+	routes := make(map[string]bool)
+	variable := regexp.MustCompile(`\{(\w+):[^}]*\}`)
+	api.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if template, err := route.GetPathTemplate(); err == nil && strings.HasPrefix(template, "/api2/") {
+			routes[variable.ReplaceAllString(strings.TrimPrefix(template, "/api2"), "{$1}")] = true
+		}
+		return nil
+	})
+	// the document itself is not a resource of the API, "/" answers everything else with 404
+	delete(routes, "/openapi.json")
+	delete(routes, "/")
+	samples := strings.NewReplacer("{domain}", "example.com", "{id}", "1")
+	for path, operations := range document.Paths {
+		if !routes[path] {
+			t.Errorf("%s is documented but has no route", path)
+			continue
+		}
+		delete(routes, path)
+
+		// a resource answers methods it does not support with 405 and the methods it supports
+		var documented []string
+		for method := range operations {
+			if method != "parameters" {
+				documented = append(documented, strings.ToUpper(method))
+				if method == "get" {
+					documented = append(documented, "HEAD")
+				}
+			}
+		}
+		sort.Strings(documented)
+		r := httptest.NewRequest("OPTIONS", "/api2"+samples.Replace(path), nil)
+		w := httptest.NewRecorder()
+		api.router.ServeHTTP(w, r)
+		if w.Code != 405 || w.Header().Get("Allow") != strings.Join(documented, ", ") {
+			t.Errorf("%s: %d, documented %v, allowed %s", path, w.Code, documented, w.Header().Get("Allow"))
+		}
+	}
+	for path := range routes {
+		t.Errorf("%s has a route but is not documented", path)
+	}
+
+	// every reference resolves
+	var all struct {
+		Components map[string]map[string]interface{}
+	}
+	json.Unmarshal(raw, &all)
+	refs := regexp.MustCompile(`"\$ref":\s*"#/components/(\w+)/(\w+)"`).FindAllStringSubmatch(string(raw), -1)
+	if len(refs) == 0 {
+		t.Fatal("no references")
+	}
+	for _, ref := range refs {
+		if all.Components[ref[1]][ref[2]] == nil {
+			t.Errorf("%s does not resolve", ref[0])
+		}
+	}
+
+	// the problem codes are the ones of the API
+	problem, _ := json.Marshal(all.Components["schemas"]["Problem"])
+	for _, code := range []string{CodeInvalidRequest, CodeInvalidParameter, CodeUnauthorized, CodeInvalidKey, CodeInsufficientScope, CodeNotFound,
+		CodeMethodNotAllowed, CodeWatchExists, CodePreconditionFailed, CodeUnsupportedMediaType, CodeInvalidDomain, CodeInvalidChannel,
+		CodeRateLimited, CodeInternalError, CodeCheckFailed} {
+		if !strings.Contains(string(problem), `"`+code+`"`) {
+			t.Errorf("the code %s is not documented", code)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package api2
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Eun/domwatch/fcgi/api1"
+)
+
+// Codes of the problems, clients should rely on these instead of the title or detail
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeInvalidParameter     = "invalid_parameter"
+	CodeUnauthorized         = "unauthorized"
+	CodeInvalidKey           = "invalid_key"
+	CodeInsufficientScope    = "insufficient_scope"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeWatchExists          = "watch_exists"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeInvalidDomain        = "invalid_domain"
+	CodeInvalidChannel       = "invalid_channel"
+	CodeRateLimited          = "rate_limited"
+	CodeInternalError        = "internal_error"
+	CodeCheckFailed          = "check_failed"
+)
+
+// ProblemTypePrefix is followed by the code in the type of a problem
+const ProblemTypePrefix = "urn:domwatch:problem:"
+
+// Problem is an error response as described in RFC 7807, served as application/problem+json
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+}
+
+func (api *API) writeProblem(w http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Del("ETag")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&Problem{
+		Type:     ProblemTypePrefix + code,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     code,
+	})
+}
+
+func (api *API) writeNotFound(w http.ResponseWriter, r *http.Request) {
+	api.writeProblem(w, r, http.StatusNotFound, CodeNotFound, "")
+}
+
+func (api *API) writeTooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	api1.SetRetryAfter(w, retryAfter)
+	api.writeProblem(w, r, http.StatusTooManyRequests, CodeRateLimited, "")
+}
+
+// logError logs an error with an id and returns the id to the client
+func (api *API) logError(w http.ResponseWriter, r *http.Request, err error) {
+	random := make([]byte, 8)
+	rand.Read(random)
+	id := hex.EncodeToString(random)
+	api.logger.Printf("Error ID=%s: %s", id, err.Error())
+	api.writeProblem(w, r, http.StatusInternalServerError, CodeInternalError, "the error was logged with the id "+id)
+}
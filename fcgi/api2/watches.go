@@ -0,0 +1,197 @@
+package api2
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Eun/domwatch/fcgi/api1"
+)
+
+// Watch is the subscription of an account to a domain
+type Watch struct {
+	Domain    string
+	Channels  []string
+	Status    string // pending, active or suspended
+	CreatedAt time.Time
+}
+
+type watchRequest struct {
+	Domain   string // only for POST /watches
+	Channels []string
+}
+
+func newWatch(watch *api1.WatchInfo) *Watch {
+	return &Watch{watch.Domain, watch.Channels, watch.Status, watch.CreatedAt}
+}
+
+func sortByDomain(watches []*api1.WatchInfo) {
+	sort.Slice(watches, func(i, j int) bool {
+		return watches[i].Domain < watches[j].Domain
+	})
+}
+
+// currentWatch returns the watch of the route, nil if the account does not watch the domain
+func (api *API) currentWatch(w http.ResponseWriter, r *http.Request, account *api1.Account) (string, *Watch, bool) {
+	domain, ok := api.domainVar(w, r)
+	if !ok {
+		return "", nil, false
+	}
+	info, err := api.v1.Watch(account, domain)
+	if err != nil {
+		api.logError(w, r, err)
+		return "", nil, false
+	}
+	if info == nil {
+		return domain, nil, true
+	}
+	return domain, newWatch(info), true
+}
+
+// saveWatch stores a watch and writes it, 201 with its location if it was created
+func (api *API) saveWatch(w http.ResponseWriter, r *http.Request, account *api1.Account, domain string, channels []string, location string) {
+	stored, err := api.v1.ParseChannels(channels)
+	if err != nil {
+		api.writeProblem(w, r, http.StatusUnprocessableEntity, CodeInvalidChannel, err.Error())
+		return
+	}
+	created, err := api.v1.SetWatch(account, domain, stored)
+	if err != nil {
+		api.logError(w, r, err)
+		return
+	}
+	info, err := api.v1.Watch(account, domain)
+	if err != nil {
+		api.logError(w, r, err)
+		return
+	}
+	if created {
+		w.Header().Set("Location", location)
+		api.writeResource(w, r, http.StatusCreated, newWatch(info))
+		return
+	}
+	api.writeResource(w, r, http.StatusOK, newWatch(info))
+}
+
+// listWatches returns the watches of the account, sorted by domain
+func (api *API) listWatches(w http.ResponseWriter, r *http.Request) {
+	account, ok := api.account(w, r, api1.ScopeWatchRead)
+	if !ok {
+		return
+	}
+	infos, err := api.v1.Watches(account)
+	if err != nil {
+		api.logError(w, r, err)
+		return
+	}
+	page, size, start, end, ok := api.page(w, r, len(infos))
+	if !ok {
+		return
+	}
+	sortByDomain(infos)
+	watches := []*Watch{}
+	for _, info := range infos[start:end] {
+		watches = append(watches, newWatch(info))
+	}
+	api.writeResource(w, r, http.StatusOK, &struct {
+		Total   int
+		Page    int
+		PerPage int
+		Watches []*Watch
+	}{len(infos), page, size, watches})
+}
+
+// createWatch watches a new domain, 409 if the account already watches it
+func (api *API) createWatch(w http.ResponseWriter, r *http.Request) {
+	account, ok := api.account(w, r, api1.ScopeWatchWrite)
+	if !ok {
+		return
+	}
+	var apiRequest watchRequest
+	if !api.decodeJSON(w, r, &apiRequest) {
+		return
+	}
+	domain, ok := api1.NormalizeDomain(apiRequest.Domain)
+	if !ok {
+		api.writeProblem(w, r, http.StatusUnprocessableEntity, CodeInvalidDomain, "'"+apiRequest.Domain+"' is not a domain")
+		return
+	}
+	info, err := api.v1.Watch(account, domain)
+	if err != nil {
+		api.logError(w, r, err)
+		return
+	}
+	if info != nil {
+		w.Header().Set("Location", r.URL.Path+"/"+domain)
+		api.writeProblem(w, r, http.StatusConflict, CodeWatchExists, domain+" is already watched")
+		return
+	}
+	api.saveWatch(w, r, account, domain, apiRequest.Channels, r.URL.Path+"/"+domain)
+}
+
+// getWatch returns a watch of the account
+func (api *API) getWatch(w http.ResponseWriter, r *http.Request) {
+	account, ok := api.account(w, r, api1.ScopeWatchRead)
+	if !ok {
+		return
+	}
+	_, watch, ok := api.currentWatch(w, r, account)
+	if !ok {
+		return
+	}
+	if watch == nil {
+		api.writeNotFound(w, r)
+		return
+	}
+	api.writeResource(w, r, http.StatusOK, watch)
+}
+
+// putWatch creates or replaces a watch, If-Match and If-None-Match: * guard against lost updates
+func (api *API) putWatch(w http.ResponseWriter, r *http.Request) {
+	account, ok := api.account(w, r, api1.ScopeWatchWrite)
+	if !ok {
+		return
+	}
+	domain, watch, ok := api.currentWatch(w, r, account)
+	if !ok {
+		return
+	}
+	var apiRequest watchRequest
+	if !api.decodeJSON(w, r, &apiRequest) {
+		return
+	}
+	// a nil pointer would be a non-nil interface
+	var current interface{}
+	if watch != nil {
+		current = watch
+	}
+	if !api.preconditions(w, r, current) {
+		return
+	}
+	api.saveWatch(w, r, account, domain, apiRequest.Channels, r.URL.Path)
+}
+
+// deleteWatch removes a watch of the account
+func (api *API) deleteWatch(w http.ResponseWriter, r *http.Request) {
+	account, ok := api.account(w, r, api1.ScopeWatchWrite)
+	if !ok {
+		return
+	}
+	domain, watch, ok := api.currentWatch(w, r, account)
+	if !ok {
+		return
+	}
+	if watch == nil {
+		api.writeNotFound(w, r)
+		return
+	}
+	if !api.preconditions(w, r, watch) {
+		return
+	}
+	_, err := api.v1.RemoveWatch(account, domain)
+	if err != nil {
+		api.logError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,58 @@
+(function () {
+    if (!('serviceWorker' in navigator) || !('PushManager' in window) || !window.fetch) {
+        return;
+    }
+
+    function decodeKey(key) {
+        var raw = atob(key.replace(/-/g, '+').replace(/_/g, '/') + '===='.slice((key.length % 4) || 4));
+        var bytes = new Uint8Array(raw.length);
+        for (var i = 0; i < raw.length; i++) {
+            bytes[i] = raw.charCodeAt(i);
+        }
+        return bytes;
+    }
+
+    function subscribe(publicKey, email) {
+        return navigator.serviceWorker.register('sw.js').then(function () {
+            return navigator.serviceWorker.ready;
+        }).then(function (registration) {
+            return registration.pushManager.subscribe({
+                userVisibleOnly: true,
+                applicationServerKey: decodeKey(publicKey)
+            });
+        }).then(function (subscription) {
+            return fetch('/api1/push/subscribe', {
+                method: 'POST',
+                headers: {'Content-Type': 'application/json'},
+                body: JSON.stringify({Email: email, Subscription: subscription.toJSON()})
+            });
+        }).then(function (response) {
+            if (!response.ok) {
+                throw new Error('subscription failed');
+            }
+        });
+    }
+
+    fetch('/api1/push/key').then(function (response) {
+        return response.ok ? response.json() : null;
+    }).then(function (key) {
+        if (!key) {
+            return;
+        }
+        var form = document.getElementById('watch-form');
+        var channel = document.getElementById('webpush-channel');
+        channel.hidden = false;
+
+        form.addEventListener('submit', function (event) {
+            if (!form.querySelector('input[value="webpush"]').checked) {
+                return;
+            }
+            event.preventDefault();
+            subscribe(key.PublicKey, form.querySelector('input[name="email"]').value).then(function () {
+                form.submit();
+            }, function () {
+                window.location.hash = 'failed_push';
+            });
+        });
+    });
+})();
@@ -0,0 +1,14 @@
+self.addEventListener('push', function (event) {
+    var data = event.data ? event.data.json() : {};
+    event.waitUntil(self.registration.showNotification('⚠️ ' + (data.Title || 'dom.watch'), {
+        body: data.Body || '',
+        tag: data.Domain,
+        data: data
+    }));
+});
+
+self.addEventListener('notificationclick', function (event) {
+    event.notification.close();
+    var data = event.notification.data || {};
+    event.waitUntil(clients.openWindow(data.Ack || '/'));
+});
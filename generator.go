@@ -0,0 +1,160 @@
+package domwatch
+
+import (
+	"sort"
+	"strings"
+)
+
+// MaxLabelLength is the longest label a domain can have
+const MaxLabelLength = 63
+
+// DefaultPrefixes are put in front of the keywords if no prefixes are given
+var DefaultPrefixes = []string{"get", "try", "my", "the", "go", "use", "hey", "join"}
+
+// DefaultSuffixes are appended to the keywords if no suffixes are given
+var DefaultSuffixes = []string{"app", "hq", "hub", "ly", "ify", "lab", "now", "box"}
+
+// GeneratorOptions are the rules for GenerateNames
+type GeneratorOptions struct {
+	Prefixes  []string // put in front of every keyword
+	Suffixes  []string // appended to every keyword
+	Words     []string // combined with every keyword, before and after it
+	Hyphenate bool     // also join the parts with hyphens
+	Plurals   bool     // also use the plural of the keywords
+	Digits    bool     // allow digits in the names
+	MinLength int      // shortest name, without tld
+	MaxLength int      // longest name, without tld, MaxLabelLength if 0
+	Limit     int      // maximum number of names, 0 for all
+}
+
+// Plural returns the english plural of a word
+func Plural(word string) string {
+	switch {
+	case word == "":
+		return word
+	case strings.HasSuffix(word, "s"), strings.HasSuffix(word, "x"), strings.HasSuffix(word, "z"),
+		strings.HasSuffix(word, "ch"), strings.HasSuffix(word, "sh"):
+		return word + "es"
+	case strings.HasSuffix(word, "y") && len(word) > 1 && !strings.ContainsRune("aeiou", rune(word[len(word)-2])):
+		return word[:len(word)-1] + "ies"
+	}
+	return word + "s"
+}
+
+// cleanWord lower cases a word and removes spaces, hyphens and dots
+func cleanWord(word string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '-', '.', '_':
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(word)))
+}
+
+// ValidName reports whether name is a label that follows the rules of the options
+func (options *GeneratorOptions) ValidName(name string) bool {
+	maxLength := options.MaxLength
+	if maxLength <= 0 || maxLength > MaxLabelLength {
+		maxLength = MaxLabelLength
+	}
+	if len(name) == 0 || len(name) < options.MinLength || len(name) > maxLength {
+		return false
+	}
+	if name[0] == '-' || name[len(name)-1] == '-' {
+		return false
+	}
+	// labels with hyphens at the third and fourth position are reserved (xn--)
+	if len(name) >= 4 && name[2:4] == "--" {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9':
+			if !options.Digits {
+				return false
+			}
+		case r == '-':
+			if !options.Hyphenate {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// GenerateNames builds candidate labels from seed keywords: the keywords themselves,
+// their plurals, prefixes and suffixes, pairs of keywords and combinations with the words.
+// Names that break the rules of the options are left out, the shortest names come first.
+func GenerateNames(keywords []string, options GeneratorOptions) []string {
+	var seeds []string
+	for _, keyword := range keywords {
+		if keyword = cleanWord(keyword); keyword != "" {
+			seeds = append(seeds, keyword)
+		}
+	}
+	bases := seeds
+	if options.Plurals {
+		bases = nil
+		for _, seed := range seeds {
+			bases = append(bases, seed, Plural(seed))
+		}
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	add := func(parts ...string) {
+		candidates := []string{strings.Join(parts, "")}
+		if options.Hyphenate && len(parts) > 1 {
+			candidates = append(candidates, strings.Join(parts, "-"))
+		}
+		for _, name := range candidates {
+			if !seen[name] && options.ValidName(name) {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	for _, base := range bases {
+		add(base)
+	}
+	for _, base := range bases {
+		for _, prefix := range options.Prefixes {
+			if prefix = cleanWord(prefix); prefix != "" {
+				add(prefix, base)
+			}
+		}
+		for _, suffix := range options.Suffixes {
+			if suffix = cleanWord(suffix); suffix != "" {
+				add(base, suffix)
+			}
+		}
+	}
+	for _, a := range seeds {
+		for _, b := range seeds {
+			if a != b {
+				add(a, b)
+			}
+		}
+	}
+	for _, base := range bases {
+		for _, word := range options.Words {
+			if word = cleanWord(word); word != "" && word != base {
+				add(base, word)
+				add(word, base)
+			}
+		}
+	}
+
+	sort.SliceStable(names, func(i, j int) bool {
+		return len(names[i]) < len(names[j])
+	})
+	if options.Limit > 0 && len(names) > options.Limit {
+		names = names[:options.Limit]
+	}
+	return names
+}
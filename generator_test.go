@@ -0,0 +1,90 @@
+package domwatch
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPlural(t *testing.T) {
+	tests := map[string]string{
+		"":       "",
+		"cat":    "cats",
+		"box":    "boxes",
+		"bus":    "buses",
+		"buzz":   "buzzes",
+		"church": "churches",
+		"dish":   "dishes",
+		"city":   "cities",
+		"day":    "days",
+		"y":      "ys",
+	}
+	for word, plural := range tests {
+		if p := Plural(word); p != plural {
+			t.Fatalf("%q: %q", word, p)
+		}
+	}
+}
+
+func TestValidName(t *testing.T) {
+	tests := []struct {
+		name    string
+		options GeneratorOptions
+		valid   bool
+	}{
+		{"acme", GeneratorOptions{}, true},
+		{"", GeneratorOptions{}, false},
+		{"acme24", GeneratorOptions{}, false},
+		{"acme24", GeneratorOptions{Digits: true}, true},
+		{"get-acme", GeneratorOptions{}, false},
+		{"get-acme", GeneratorOptions{Hyphenate: true}, true},
+		{"-acme", GeneratorOptions{Hyphenate: true}, false},
+		{"acme-", GeneratorOptions{Hyphenate: true}, false},
+		{"xn--acme", GeneratorOptions{Hyphenate: true}, false},
+		{"ab--cd", GeneratorOptions{Hyphenate: true}, false},
+		{"a--b", GeneratorOptions{Hyphenate: true}, true},
+		{"café", GeneratorOptions{}, false},
+		{"ACME", GeneratorOptions{}, false},
+		{"acme", GeneratorOptions{MinLength: 5}, false},
+		{"acme", GeneratorOptions{MaxLength: 3}, false},
+		{"acme", GeneratorOptions{MinLength: 4, MaxLength: 4}, true},
+		{strings.Repeat("a", MaxLabelLength), GeneratorOptions{}, true},
+		{strings.Repeat("a", MaxLabelLength+1), GeneratorOptions{}, false},
+		{strings.Repeat("a", MaxLabelLength+1), GeneratorOptions{MaxLength: 100}, false},
+	}
+	for _, test := range tests {
+		if valid := test.options.ValidName(test.name); valid != test.valid {
+			t.Fatalf("%q %+v: %v", test.name, test.options, valid)
+		}
+	}
+}
+
+func TestGenerateNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		keywords []string
+		options  GeneratorOptions
+		names    string
+	}{
+		{"keyword", []string{" Acme "}, GeneratorOptions{}, "acme"},
+		{"cleaned", []string{"Ac-me.io"}, GeneratorOptions{}, "acmeio"},
+		{"pairs", []string{"acme", "rocket"}, GeneratorOptions{}, "acme rocket acmerocket rocketacme"},
+		{"hyphenate", []string{"acme"}, GeneratorOptions{Prefixes: []string{"get"}, Hyphenate: true}, "acme getacme get-acme"},
+		{"no hyphens", []string{"acme"}, GeneratorOptions{Prefixes: []string{"get"}}, "acme getacme"},
+		{"plurals", []string{"box"}, GeneratorOptions{Plurals: true, Suffixes: []string{"hq"}}, "box boxes boxhq boxeshq"},
+		{"plural of y", []string{"city"}, GeneratorOptions{Plurals: true}, "city cities"},
+		{"length", []string{"acme"}, GeneratorOptions{Suffixes: []string{"hq", "ify", "labs"}, MinLength: 5, MaxLength: 7}, "acmehq acmeify"},
+		{"digits", []string{"acme"}, GeneratorOptions{Suffixes: []string{"24"}}, "acme"},
+		{"with digits", []string{"acme"}, GeneratorOptions{Suffixes: []string{"24"}, Digits: true}, "acme acme24"},
+		{"words", []string{"acme"}, GeneratorOptions{Words: []string{"acme", "cloud"}}, "acme acmecloud cloudacme"},
+		{"duplicates", []string{"acme", "acme"}, GeneratorOptions{Suffixes: []string{"hq", "HQ"}}, "acme acmehq"},
+		{"limit", []string{"acme", "rocket"}, GeneratorOptions{Limit: 2}, "acme rocket"},
+		{"too long", []string{strings.Repeat("a", 60)}, GeneratorOptions{Suffixes: []string{"hub"}, Hyphenate: true}, strings.Repeat("a", 60) + " " + strings.Repeat("a", 60) + "hub"},
+		{"invalid", []string{"café", ""}, GeneratorOptions{}, ""},
+	}
+	for _, test := range tests {
+		names := strings.Join(GenerateNames(test.keywords, test.options), " ")
+		if names != test.names {
+			t.Fatalf("%s: %q", test.name, names)
+		}
+	}
+}
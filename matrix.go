@@ -0,0 +1,160 @@
+package domwatch
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IANATLDList lists all top level domains, one per line
+const IANATLDList = "https://data.iana.org/TLD/tlds-alpha-by-domain.txt"
+
+// TLDAll selects all generic top level domains
+const TLDAll = "all"
+
+// TLDGroups are named sets of top level domains
+var TLDGroups = map[string][]string{
+	"classic": {"com", "net", "org", "info", "biz"},
+	"popular": {"com", "net", "org", "io", "co", "app", "dev", "ai", "xyz"},
+	"tech":    {"io", "dev", "app", "ai", "tech", "cloud", "software", "codes", "sh", "so"},
+	"shop":    {"shop", "store", "market", "sale", "buy", "deals"},
+	"europe":  {"eu", "de", "fr", "it", "es", "nl", "at", "ch", "be", "se"},
+}
+
+// FetchGTLDs downloads the list of IANA and returns the generic top level domains,
+// two letter country codes and internationalized names are left out
+func FetchGTLDs(client *http.Client) ([]string, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(IANATLDList)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("%s returned %s", IANATLDList, resp.Status)
+	}
+
+	var tlds []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") || len(line) == 2 || strings.HasPrefix(line, "xn--") {
+			continue
+		}
+		tlds = append(tlds, line)
+	}
+	return tlds, scanner.Err()
+}
+
+// ResolveTLDs expands a TLD set: "all" for all generic TLDs, the name of a group,
+// or a list of TLDs separated by commas. gtlds is called for "all".
+func ResolveTLDs(set string, gtlds func() ([]string, error)) ([]string, error) {
+	set = strings.ToLower(strings.TrimSpace(set))
+	if set == TLDAll {
+		return gtlds()
+	}
+	if group, ok := TLDGroups[set]; ok {
+		return group, nil
+	}
+
+	var tlds []string
+	seen := make(map[string]bool)
+	for _, tld := range strings.Split(set, ",") {
+		tld = strings.Trim(strings.TrimSpace(tld), ".")
+		if tld == "" || seen[tld] {
+			continue
+		}
+		if strings.ContainsAny(tld, ". /") {
+			return nil, fmt.Errorf("Invalid TLD '%s'", tld)
+		}
+		seen[tld] = true
+		tlds = append(tlds, tld)
+	}
+	if len(tlds) == 0 {
+		return nil, fmt.Errorf("Unknown TLD set '%s'", set)
+	}
+	return tlds, nil
+}
+
+// TLDGroupNames returns the names of the TLD groups in order
+func TLDGroupNames() []string {
+	var names []string
+	for name := range TLDGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MatrixCell is the availability of one label in one TLD
+type MatrixCell struct {
+	TLD       string
+	Domain    string
+	Available bool
+	Verdict   string
+	Error     string `json:",omitempty"`
+}
+
+// MatrixRow holds the cells of a label in the order of the TLDs
+type MatrixRow struct {
+	Label string
+	Cells []*MatrixCell
+}
+
+// Matrix is the availability grid of labels and TLDs
+type Matrix struct {
+	TLDs []string
+	Rows []*MatrixRow
+}
+
+// CheckMatrix checks every label in every TLD with at most concurrency checks at once,
+// check is usually a closure around CheckDomain
+func CheckMatrix(labels []string, tlds []string, concurrency int, check func(domain string) (*Result, error)) *Matrix {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	matrix := &Matrix{TLDs: tlds}
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, label := range labels {
+		row := &MatrixRow{Label: label, Cells: make([]*MatrixCell, len(tlds))}
+		matrix.Rows = append(matrix.Rows, row)
+		for i, tld := range tlds {
+			cell := &MatrixCell{TLD: tld, Domain: label + "." + tld}
+			row.Cells[i] = cell
+			wg.Add(1)
+			slots <- struct{}{}
+			go func(cell *MatrixCell) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				result, err := check(cell.Domain)
+				if err != nil {
+					cell.Error = err.Error()
+					return
+				}
+				cell.Available = result.Available
+				cell.Verdict = result.Verdict
+			}(cell)
+		}
+	}
+	wg.Wait()
+	return matrix
+}
+
+// Taken returns the domains of the matrix that are registered
+func (matrix *Matrix) Taken() []string {
+	var domains []string
+	for _, row := range matrix.Rows {
+		for _, cell := range row.Cells {
+			if cell.Error == "" && !cell.Available {
+				domains = append(domains, cell.Domain)
+			}
+		}
+	}
+	return domains
+}
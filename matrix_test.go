@@ -0,0 +1,108 @@
+package domwatch
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestResolveTLDs(t *testing.T) {
+	gtlds := func() ([]string, error) { return []string{"com", "net", "shop"}, nil }
+	tests := []struct {
+		set  string
+		tlds []string
+	}{
+		{"all", []string{"com", "net", "shop"}},
+		{" ALL ", []string{"com", "net", "shop"}},
+		{"classic", TLDGroups["classic"]},
+		{"Popular", TLDGroups["popular"]},
+		{"com,.io, dev.,com", []string{"com", "io", "dev"}},
+		{"de", []string{"de"}},
+	}
+	for _, test := range tests {
+		tlds, err := ResolveTLDs(test.set, gtlds)
+		if err != nil || !reflect.DeepEqual(tlds, test.tlds) {
+			t.Fatalf("%q: %v %v", test.set, tlds, err)
+		}
+	}
+
+	for _, set := range []string{"", ",,", "co.uk", "com,a b", "com/net"} {
+		if tlds, err := ResolveTLDs(set, gtlds); err == nil {
+			t.Fatalf("%q: %v", set, tlds)
+		}
+	}
+
+	// the list of all TLDs is only fetched for "all"
+	failing := func() ([]string, error) { return nil, errors.New("offline") }
+	if _, err := ResolveTLDs("all", failing); err == nil || err.Error() != "offline" {
+		t.Fatal(err)
+	}
+	if _, err := ResolveTLDs("com", failing); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckMatrix(t *testing.T) {
+	var mu sync.Mutex
+	running, most := 0, 0
+	check := func(domain string) (*Result, error) {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+		switch {
+		case strings.HasSuffix(domain, ".com"):
+			return &Result{Available: false, Verdict: VerdictRegistered}, nil
+		case strings.HasSuffix(domain, ".io"):
+			return nil, errors.New("timeout")
+		default:
+			return &Result{Available: true, Verdict: VerdictAvailable}, nil
+		}
+	}
+
+	matrix := CheckMatrix([]string{"acme", "rocket"}, []string{"com", "io", "dev"}, 2, check)
+	if most > 2 {
+		t.Fatal("concurrency", most)
+	}
+	if !reflect.DeepEqual(matrix.TLDs, []string{"com", "io", "dev"}) || len(matrix.Rows) != 2 {
+		t.Fatalf("%+v", matrix)
+	}
+	for i, label := range []string{"acme", "rocket"} {
+		row := matrix.Rows[i]
+		if row.Label != label || len(row.Cells) != 3 {
+			t.Fatalf("%+v", row)
+		}
+		// the cells are in the order of the TLDs
+		expected := []MatrixCell{
+			{TLD: "com", Domain: label + ".com", Available: false, Verdict: VerdictRegistered},
+			{TLD: "io", Domain: label + ".io", Error: "timeout"},
+			{TLD: "dev", Domain: label + ".dev", Available: true, Verdict: VerdictAvailable},
+		}
+		for j, cell := range row.Cells {
+			if *cell != expected[j] {
+				t.Fatalf("%+v", cell)
+			}
+		}
+	}
+
+	// failed checks are neither taken nor available
+	if taken := matrix.Taken(); !reflect.DeepEqual(taken, []string{"acme.com", "rocket.com"}) {
+		t.Fatal(taken)
+	}
+
+	// a concurrency below one checks one domain at a time
+	most = 0
+	CheckMatrix([]string{"acme"}, []string{"com", "dev"}, 0, check)
+	if most != 1 {
+		t.Fatal("concurrency", most)
+	}
+}